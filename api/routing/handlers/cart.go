@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/auth"
+)
+
+// cartCookie keeps the token of the cart of an anonymous session, clients without cookies may send CartHeader instead
+const (
+	cartCookie   = "cart"
+	CartHeader   = "X-Cart-Token"
+	cartTokenTTL = 30 * 24 * time.Hour
+	// cartTokenMaxLength is the length of the token column
+	cartTokenMaxLength = 64
+)
+
+// Cart manages the cart of the authenticated user or of the anonymous session.
+type Cart struct {
+	CartMapper mappers.CartMapperInterface
+}
+
+func (c Cart) Get(w http.ResponseWriter, r *http.Request) {
+	owner, ok := cartOwner(w, r, false)
+	cart := models.NewCart(nil)
+	if ok {
+		var err error
+		cart, err = c.CartMapper.Find(owner)
+		if err != nil {
+			logrus.Error(err)
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	c.respond(w, cart, http.StatusOK)
+}
+
+// SetItem adds the pet of the path to the cart or changes its quantity, the body is {"quantity": 1}.
+func (c Cart) SetItem(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(chi.URLParam(r, "petId"))
+	if err != nil || petID < 1 {
+		JSONApiResponse(w, "Invalid pet ID supplied", http.StatusBadRequest)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	input := struct {
+		Quantity int `json:"quantity"`
+	}{Quantity: 1}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &input)
+		if err != nil {
+			logrus.Error(err)
+			JSONApiResponse(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+	}
+	if input.Quantity < 1 {
+		JSONApiResponse(w, "Invalid quantity", http.StatusBadRequest)
+		return
+	}
+
+	owner, _ := cartOwner(w, r, true)
+	err = c.CartMapper.SetItem(owner, petID, input.Quantity)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Pet not found", http.StatusNotFound)
+			return
+		case mappers.ConflictError:
+			JSONApiResponse(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	c.findAndRespond(w, owner)
+}
+
+func (c Cart) RemoveItem(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(chi.URLParam(r, "petId"))
+	if err != nil || petID < 1 {
+		JSONApiResponse(w, "Invalid pet ID supplied", http.StatusBadRequest)
+		return
+	}
+	owner, ok := cartOwner(w, r, false)
+	if !ok {
+		JSONApiResponse(w, "Pet is not in the cart", http.StatusNotFound)
+		return
+	}
+	err = c.CartMapper.RemoveItem(owner, petID)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Pet is not in the cart", http.StatusNotFound)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	c.findAndRespond(w, owner)
+}
+
+func (c Cart) Clear(w http.ResponseWriter, r *http.Request) {
+	owner, ok := cartOwner(w, r, false)
+	if ok {
+		err := c.CartMapper.Clear(owner)
+		if err != nil {
+			logrus.Error(err)
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	c.respond(w, models.NewCart(nil), http.StatusOK)
+}
+
+// Checkout places an order of the cart, the optional body may bring the requested ship date and a coupon code.
+func (c Cart) Checkout(w http.ResponseWriter, r *http.Request) {
+	owner, ok := cartOwner(w, r, false)
+	if !ok {
+		JSONApiResponse(w, "cart is empty", http.StatusBadRequest)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// only the ship date and the coupon code are taken from the body, the items come from the cart
+	order := &models.Order{}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, order)
+		if err != nil {
+			logrus.Error(err)
+			if _, ok := err.(models.ValidationError); ok {
+				JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			JSONApiResponse(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+	}
+	order = &models.Order{ShipDate: order.ShipDate, CouponCode: order.CouponCode}
+	if user := auth.GetAuthService().GetUser(r); user != nil {
+		order.UserID = &user.ID
+	}
+
+	err = c.CartMapper.WithActor(actor(r)).Checkout(owner, order)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case models.ValidationError:
+			JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		case mappers.NotFoundError:
+			JSONApiResponse(w, err.Error(), http.StatusNotFound)
+			return
+		case mappers.ConflictError:
+			JSONApiResponse(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	setETag(w, order.Version)
+	output, err := json.Marshal(order)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusCreated)
+}
+
+func (c Cart) findAndRespond(w http.ResponseWriter, owner mappers.CartOwner) {
+	cart, err := c.CartMapper.Find(owner)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.respond(w, cart, http.StatusOK)
+}
+
+func (Cart) respond(w http.ResponseWriter, cart *models.Cart, statusCode int) {
+	output, err := json.Marshal(cart)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, statusCode)
+}
+
+// cartOwner returns the owner of the cart the request works with, the authenticated user
+// or the anonymous session. A new session token is issued when issue is set and the request has none,
+// false means the request has no cart.
+func cartOwner(w http.ResponseWriter, r *http.Request, issue bool) (mappers.CartOwner, bool) {
+	if user := auth.GetAuthService().GetUser(r); user != nil {
+		return mappers.CartOwner{UserID: user.ID}, true
+	}
+	token := CartToken(r)
+	if token == "" && issue {
+		token = newCartToken()
+		http.SetCookie(w, &http.Cookie{
+			Name:     cartCookie,
+			Value:    token,
+			Path:     "/",
+			Expires:  time.Now().Add(cartTokenTTL),
+			HttpOnly: true,
+		})
+		w.Header().Set(CartHeader, token)
+	}
+	return mappers.CartOwner{Token: token}, token != ""
+}
+
+// CartToken returns the token of the anonymous cart sent with the request, an empty string when there is none.
+// Tokens longer than the ones issued are ignored.
+func CartToken(r *http.Request) string {
+	token := r.Header.Get(CartHeader)
+	if c, err := r.Cookie(cartCookie); err == nil && c.Value != "" {
+		token = c.Value
+	}
+	if len(token) > cartTokenMaxLength {
+		return ""
+	}
+	return token
+}
+
+func newCartToken() string {
+	buf := make([]byte, 24)
+	_, err := rand.Read(buf)
+	if err != nil {
+		logrus.Fatal("random source failed: ", err)
+	}
+	return hex.EncodeToString(buf)
+}
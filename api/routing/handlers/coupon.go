@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+// Coupon manages discount codes, it is meant for admins only.
+type Coupon struct {
+	CouponMapper mappers.CouponMapperInterface
+}
+
+func (c Coupon) List(w http.ResponseWriter, r *http.Request) {
+	coupons, err := c.CouponMapper.FindAll()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	output, err := json.Marshal(coupons)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+func (c Coupon) Create(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	coupon := &models.Coupon{Active: true}
+	err = json.Unmarshal(data, coupon)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	err = coupon.Validate()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = c.CouponMapper.Create(coupon)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.ConflictError:
+			JSONApiResponse(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	output, err := json.Marshal(coupon)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusCreated)
+}
+
+func (c Coupon) GetByCode(w http.ResponseWriter, r *http.Request) {
+	coupon, err := c.CouponMapper.FindByCode(chi.URLParam(r, "code"))
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Coupon not found", http.StatusNotFound)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	output, err := json.Marshal(coupon)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+// Deactivate stops the coupon from being used, it is kept for the orders which have used it.
+func (c Coupon) Deactivate(w http.ResponseWriter, r *http.Request) {
+	err := c.CouponMapper.Deactivate(chi.URLParam(r, "code"))
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Coupon not found", http.StatusNotFound)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	JSONApiResponse(w, "Coupon deactivated", http.StatusOK)
+}
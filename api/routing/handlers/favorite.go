@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/auth"
+)
+
+// Favorite manages pets favorited by the authenticated user.
+type Favorite struct {
+	FavoriteMapper mappers.FavoriteMapperInterface
+	// PublicURL is the configured address of the API that links to images start with
+	PublicURL string
+}
+
+func (f Favorite) List(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetAuthService().GetUser(r)
+	if user == nil {
+		JSONApiResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	size, err := imageSize(r)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, "Invalid size value", http.StatusBadRequest)
+		return
+	}
+
+	pets, err := f.FavoriteMapper.FindPets(user.ID)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, pet := range pets {
+		err = fillPhotoURLs(f.PublicURL, pet, size)
+		if err != nil {
+			logrus.Error(err)
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	output, err := json.Marshal(pets)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+func (f Favorite) Add(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetAuthService().GetUser(r)
+	if user == nil {
+		JSONApiResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	petID, err := strconv.Atoi(chi.URLParam(r, "petId"))
+	if err != nil || petID < 1 {
+		JSONApiResponse(w, "Invalid pet ID supplied", http.StatusBadRequest)
+		return
+	}
+
+	err = f.FavoriteMapper.Add(user.ID, petID)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Pet not found", http.StatusNotFound)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	JSONApiResponse(w, "Pet added to favorites", http.StatusOK)
+}
+
+func (f Favorite) Remove(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetAuthService().GetUser(r)
+	if user == nil {
+		JSONApiResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	petID, err := strconv.Atoi(chi.URLParam(r, "petId"))
+	if err != nil || petID < 1 {
+		JSONApiResponse(w, "Invalid pet ID supplied", http.StatusBadRequest)
+		return
+	}
+
+	err = f.FavoriteMapper.Remove(user.ID, petID)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Favorite not found", http.StatusNotFound)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	JSONApiResponse(w, "Pet removed from favorites", http.StatusOK)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/storage"
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+)
+
+const (
+	defaultInvoicePageSize = 50
+	maxInvoicePageSize     = 500
+)
+
+// Invoice gives access to the invoices made by the invoice worker, it is meant for admins only.
+type Invoice struct {
+	InvoiceMapper mappers.InvoiceMapperInterface
+}
+
+// List returns a page of invoices, the latest first, with the limit and offset query params.
+func (h Invoice) List(w http.ResponseWriter, r *http.Request) {
+	limit := defaultInvoicePageSize
+	if value, err := utils.GetURLParam(r, "limit"); err == nil {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxInvoicePageSize {
+			JSONApiResponse(w, fmt.Sprintf("Invalid limit value, expected 1 to %d", maxInvoicePageSize), http.StatusBadRequest)
+			return
+		}
+	}
+	offset := 0
+	if value, err := utils.GetURLParam(r, "offset"); err == nil {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			JSONApiResponse(w, "Invalid offset value", http.StatusBadRequest)
+			return
+		}
+	}
+
+	count, err := h.InvoiceMapper.Count()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	invoices, err := h.InvoiceMapper.Find(limit, offset)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	output, err := json.Marshal(invoices)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(count))
+	JSONResponse(w, output, http.StatusOK)
+}
+
+func (h Invoice) GetByID(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+	output, err := json.Marshal(invoice)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+// Download streams the invoice document from the storage as an attachment, the format query param
+// chooses one of the documents of the invoice, the main document is sent without it.
+func (h Invoice) Download(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+	if invoice.Status == models.InvoiceStatusPending {
+		JSONApiResponse(w, "Invoice documents are not stored yet", http.StatusNotFound)
+		return
+	}
+	key, contentType := invoice.StorageKey, invoice.ContentType
+	if format := r.URL.Query().Get("format"); format != "" {
+		document := invoice.Documents.Find(format)
+		if document == nil {
+			JSONApiResponse(w, fmt.Sprintf("Invoice has no %v document", format), http.StatusNotFound)
+			return
+		}
+		key, contentType = document.StorageKey, document.ContentType
+	}
+	if key == "" {
+		// invoices made before they were stored keep their body in the table
+		w.Header().Set("Content-Type", "text/markdown; charset=UTF-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"invoice-%06d.md\"", invoice.ID))
+		http.ServeContent(w, r, "", time.Unix(invoice.CreatedDate, 0), strings.NewReader(invoice.Body))
+		return
+	}
+
+	object, info, err := storage.GetStorage().Get(storage.InvoicesBucket, key)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case storage.NotFoundError:
+			JSONApiResponse(w, "Invoice document not found", http.StatusNotFound)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	defer func() {
+		err = object.Close()
+		if err != nil {
+			logrus.Error(err)
+		}
+	}()
+
+	if contentType == "" {
+		contentType = info.ContentType
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", key))
+	http.ServeContent(w, r, key, info.LastModified, object)
+}
+
+func (h Invoice) find(w http.ResponseWriter, r *http.Request) (*models.Invoice, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return nil, false
+	}
+	invoice, err := h.InvoiceMapper.FindByID(id)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Invoice not found", http.StatusNotFound)
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return invoice, true
+}
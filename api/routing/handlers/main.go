@@ -2,9 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/auth"
 )
 
 type APIResponse struct {
@@ -43,3 +49,94 @@ func JSONResponse(w http.ResponseWriter, output []byte, statusCode int) {
 		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// actor returns the username of the authenticated user, or an empty string for anonymous requests.
+func actor(r *http.Request) string {
+	user := auth.GetAuthService().GetUser(r)
+	if user == nil {
+		return ""
+	}
+	return user.Username
+}
+
+// petRole returns the role deciding which pet status transitions the request may perform,
+// anonymous requests cannot change pet statuses.
+func petRole(r *http.Request) string {
+	user := auth.GetAuthService().GetUser(r)
+	if user == nil {
+		return models.PetRoleAnonymous
+	}
+	if auth.IsAdmin(user) {
+		return models.PetRoleAdmin
+	}
+	return models.PetRoleCustomer
+}
+
+// ifMatchVersion returns the version required by the If-Match header,
+// zero means the header is absent or matches any version.
+func ifMatchVersion(r *http.Request) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(header, "W/"), `"`))
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid If-Match header %v", header)
+	}
+	return version, nil
+}
+
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, version))
+}
+
+func preconditionFailed(w http.ResponseWriter) {
+	JSONApiResponse(w, "Resource has been modified, If-Match does not match", http.StatusPreconditionFailed)
+}
+
+// exportWriter streams an export to the client. The export headers are set right before the first bytes are
+// written, so a failed export still gets an error response until anything has reached the client.
+type exportWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	filename    string
+	written     bool
+}
+
+func newExportWriter(w http.ResponseWriter, contentType, filename string) *exportWriter {
+	return &exportWriter{w: w, contentType: contentType, filename: filename}
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	e.setHeaders()
+	e.written = true
+	return e.w.Write(p)
+}
+
+// Flush sends the written bytes to the client, an empty export gets its headers as well.
+func (e *exportWriter) Flush() {
+	e.setHeaders()
+	if flusher, ok := e.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Fail responds with the error unless the export has been started, a started export is cut short.
+func (e *exportWriter) Fail(err error) {
+	if e.written {
+		return
+	}
+	e.w.Header().Del("Content-Disposition")
+	JSONApiResponse(e.w, err.Error(), http.StatusInternalServerError)
+}
+
+func (e *exportWriter) setHeaders() {
+	if e.written {
+		return
+	}
+	e.w.Header().Set("Content-Type", e.contentType)
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.filename))
+}
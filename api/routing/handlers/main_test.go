@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/auth"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/payment"
+)
+
+const testAdmin = "admin"
+
+func TestMain(m *testing.M) {
+	auth.Init(auth.Config{Type: "jwt", Admins: []string{testAdmin}})
+	payment.Init(payment.Config{Type: "fake", Fake: payment.FakeConfig{Secret: "test-secret"}})
+	os.Exit(m.Run())
+}
+
+// authenticated returns the request carrying the token of the user logged in.
+func authenticated(t *testing.T, r *http.Request, user *models.User) *http.Request {
+	w := httptest.NewRecorder()
+	err := auth.GetAuthService().Authenticate(w, r, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestPetRole(t *testing.T) {
+	anonymous := httptest.NewRequest(http.MethodPut, "/pet", nil)
+	if role := petRole(anonymous); role != models.PetRoleAnonymous {
+		t.Errorf("anonymous request: got role %v", role)
+	}
+	if err := (models.Pet{}).CheckTransition(models.PetStatusAvailable, models.PetStatusPending, petRole(anonymous)); err == nil {
+		t.Error("anonymous request may change pet status")
+	}
+	customer := authenticated(t, httptest.NewRequest(http.MethodPut, "/pet", nil), &models.User{ID: 2, Username: "customer"})
+	if role := petRole(customer); role != models.PetRoleCustomer {
+		t.Errorf("customer request: got role %v", role)
+	}
+	admin := authenticated(t, httptest.NewRequest(http.MethodPut, "/pet", nil), &models.User{ID: 1, Username: testAdmin})
+	if role := petRole(admin); role != models.PetRoleAdmin {
+		t.Errorf("admin request: got role %v", role)
+	}
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/payment"
+)
+
+// Payment takes payments of orders through the configured provider, orders are approved
+// when the provider confirms the payment to the webhook.
+type Payment struct {
+	PaymentMapper mappers.PaymentMapperInterface
+	OrderMapper   mappers.OrderMapperInterface
+}
+
+// Pay authorizes and captures the total of a placed order, the body is {"method": "<payment method token>"}.
+// The payment is accepted and succeeds once the provider confirms it. Customers may pay only orders of their own.
+func (p Payment) Pay(w http.ResponseWriter, r *http.Request) {
+	order, ok := ownOrder(w, r, p.OrderMapper)
+	if !ok {
+		return
+	}
+	orderID := order.ID
+	data, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var input struct {
+		Method string `json:"method"`
+	}
+	err = json.Unmarshal(data, &input)
+	if err != nil || input.Method == "" {
+		JSONApiResponse(w, "Invalid input, payment method is required", http.StatusBadRequest)
+		return
+	}
+
+	provider := payment.GetProvider()
+	attempt := &models.Payment{OrderID: orderID, Provider: provider.Name()}
+	err = p.PaymentMapper.Create(attempt)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Order not found", http.StatusNotFound)
+			return
+		case mappers.ConflictError:
+			JSONApiResponse(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result, err := provider.Authorize(payment.AuthorizeRequest{
+		OrderID:  orderID,
+		Amount:   attempt.Amount,
+		Currency: attempt.Currency,
+		Method:   input.Method,
+	})
+	if err == nil {
+		attempt.Reference = &result.Reference
+		attempt.Status = result.Status
+		err = p.PaymentMapper.Update(attempt)
+		if err != nil {
+			logrus.Error(err)
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result, err = provider.Capture(result.Reference)
+	}
+	if err != nil {
+		logrus.Error(err)
+		p.fail(w, attempt, err)
+		return
+	}
+	attempt.Status = result.Status
+	err = p.PaymentMapper.Update(attempt)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.respond(w, attempt, http.StatusAccepted)
+}
+
+// fail records the error of the provider, a declined payment is 402 Payment Required,
+// the provider being unavailable is 502 Bad Gateway.
+func (p Payment) fail(w http.ResponseWriter, attempt *models.Payment, cause error) {
+	attempt.Status = models.PaymentStatusFailed
+	attempt.Error = cause.Error()
+	err := p.PaymentMapper.Update(attempt)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, ok := cause.(payment.DeclinedError); ok {
+		JSONApiResponse(w, cause.Error(), http.StatusPaymentRequired)
+		return
+	}
+	JSONApiResponse(w, "Payment provider error: "+cause.Error(), http.StatusBadGateway)
+}
+
+// ListByOrder returns the payment attempts of the order, customers see only orders of their own.
+func (p Payment) ListByOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := ownOrder(w, r, p.OrderMapper)
+	if !ok {
+		return
+	}
+	payments, err := p.PaymentMapper.FindByOrderID(order.ID)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	output, err := json.Marshal(payments)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+// Webhook receives payment events signed by the provider, a succeeded payment approves its order.
+func (p Payment) Webhook(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	provider := payment.GetProvider()
+	event, err := provider.ParseWebhook(r.Header, data)
+	if err != nil {
+		logrus.Error(err)
+		if err == payment.ErrInvalidSignature {
+			JSONApiResponse(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		JSONApiResponse(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	mapper := p.PaymentMapper.WithActor("payment:" + provider.Name())
+	var attempt *models.Payment
+	switch event.Status {
+	case payment.StatusSucceeded:
+		attempt, err = mapper.Succeed(provider.Name(), event.Reference)
+	case payment.StatusFailed:
+		attempt, err = mapper.Fail(provider.Name(), event.Reference, event.Message)
+	default:
+		JSONApiResponse(w, "Event ignored", http.StatusOK)
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, err.Error(), http.StatusNotFound)
+			return
+		case mappers.ConflictError:
+			JSONApiResponse(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	p.respond(w, attempt, http.StatusOK)
+}
+
+// Refund returns money of a succeeded payment, the body is {"amount": "10.00"}, the whole rest is refunded without it.
+func (p Payment) Refund(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var input struct {
+		Amount *models.Decimal `json:"amount"`
+	}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &input)
+		if err != nil || input.Amount != nil && *input.Amount <= 0 {
+			JSONApiResponse(w, "Invalid input, amount must be positive", http.StatusBadRequest)
+			return
+		}
+	}
+
+	attempt, err := p.PaymentMapper.FindByID(id)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Payment not found", http.StatusNotFound)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	amount := attempt.Amount - attempt.Refunded
+	if input.Amount != nil {
+		amount = *input.Amount
+	}
+	if attempt.Status != models.PaymentStatusSucceeded || attempt.Reference == nil || amount > attempt.Amount-attempt.Refunded {
+		JSONApiResponse(w, "Payment cannot be refunded by this amount", http.StatusConflict)
+		return
+	}
+
+	attempt, err = refundPayment(p.PaymentMapper.WithActor(actor(r)), attempt, amount, nil)
+	if err != nil {
+		logrus.Error(err)
+		refundError(w, err)
+		return
+	}
+	p.respond(w, attempt, http.StatusOK)
+}
+
+func (p Payment) respond(w http.ResponseWriter, attempt *models.Payment, code int) {
+	output, err := json.Marshal(attempt)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, code)
+}
+
+// refundPayment returns the amount of the succeeded payment through its provider, the refund is recorded
+// as pending before the provider is asked, so a refund without an answer is asked again by the refund worker.
+// returnID is the return completed by the refund, nil for refunds of the payment alone.
+func refundPayment(mapper mappers.PaymentMapperInterface, attempt *models.Payment, amount models.Decimal,
+	returnID *int) (*models.Payment, error) {
+	provider := payment.GetProvider()
+	if attempt.Provider != provider.Name() || attempt.Reference == nil {
+		return nil, mappers.ConflictError(fmt.Sprintf("payment %d cannot be refunded through %v", attempt.ID, provider.Name()))
+	}
+	refund, err := mapper.BeginRefund(attempt.ID, amount, returnID)
+	if err != nil {
+		return nil, err
+	}
+	return payment.SettleRefund(mapper, attempt, refund)
+}
+
+// refundablePayment returns the succeeded payment of the order with the amount left to refund, nil if there is none.
+func refundablePayment(mapper mappers.PaymentMapperInterface, orderID int, amount models.Decimal) (*models.Payment, error) {
+	payments, err := mapper.FindByOrderID(orderID)
+	if err != nil {
+		return nil, err
+	}
+	for _, attempt := range payments {
+		if attempt.Status == models.PaymentStatusSucceeded && attempt.Amount-attempt.Refunded >= amount {
+			return attempt, nil
+		}
+	}
+	return nil, nil
+}
+
+// refundError responds to a failed refund, a refund refused by the provider or the payment state is a conflict.
+// Other errors leave the refund pending, it is asked again later.
+func refundError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case payment.DeclinedError, mappers.ConflictError:
+		JSONApiResponse(w, err.Error(), http.StatusConflict)
+	case mappers.NotFoundError:
+		JSONApiResponse(w, err.Error(), http.StatusNotFound)
+	default:
+		JSONApiResponse(w, "Refund is pending, payment provider error: "+err.Error(), http.StatusBadGateway)
+	}
+}
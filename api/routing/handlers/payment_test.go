@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/payment"
+)
+
+// orderPaymentMapper takes payments of any order and records the orders paid and listed.
+type orderPaymentMapper struct {
+	mappers.PaymentMapperInterface
+	reached *[]int
+}
+
+func (m orderPaymentMapper) Create(p *models.Payment) error {
+	*m.reached = append(*m.reached, p.OrderID)
+	p.ID, p.Status, p.Amount, p.Currency = 1, models.PaymentStatusPending, 1999, "USD"
+	return nil
+}
+
+func (m orderPaymentMapper) Update(p *models.Payment) error {
+	return nil
+}
+
+func (m orderPaymentMapper) FindByOrderID(orderID int) ([]*models.Payment, error) {
+	*m.reached = append(*m.reached, orderID)
+	return []*models.Payment{}, nil
+}
+
+func TestPayOwnership(t *testing.T) {
+	reached := []int{}
+	testOwnership(t, http.MethodPost, `{"method": "card"}`, http.StatusAccepted, func(mapper ownedOrderMapper) http.HandlerFunc {
+		reached = nil
+		return Payment{OrderMapper: mapper, PaymentMapper: orderPaymentMapper{reached: &reached}}.Pay
+	}, func(ownedOrderMapper, *httptest.ResponseRecorder) bool {
+		return len(reached) == 1
+	})
+}
+
+func TestListPaymentsOwnership(t *testing.T) {
+	reached := []int{}
+	testOwnership(t, http.MethodGet, "", http.StatusOK, func(mapper ownedOrderMapper) http.HandlerFunc {
+		reached = nil
+		return Payment{OrderMapper: mapper, PaymentMapper: orderPaymentMapper{reached: &reached}}.ListByOrder
+	}, func(ownedOrderMapper, *httptest.ResponseRecorder) bool {
+		return len(reached) == 1
+	})
+}
+
+// webhookPaymentMapper settles payments confirmed to the webhook.
+type webhookPaymentMapper struct {
+	mappers.PaymentMapperInterface
+	succeeded *[]string
+}
+
+func (m webhookPaymentMapper) WithActor(actor string) mappers.PaymentMapperInterface {
+	return m
+}
+
+func (m webhookPaymentMapper) Succeed(provider, reference string) (*models.Payment, error) {
+	*m.succeeded = append(*m.succeeded, reference)
+	return &models.Payment{Reference: &reference, Status: models.PaymentStatusSucceeded}, nil
+}
+
+func TestPaymentWebhookSignature(t *testing.T) {
+	body := `{"reference": "fake_1", "status": "succeeded"}`
+	signature := payment.GetProvider().(payment.FakeProvider).Sign([]byte(body))
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		code      int
+	}{
+		{"signed", body, signature, http.StatusOK},
+		{"unsigned", body, "", http.StatusUnauthorized},
+		{"tampered", strings.Replace(body, "fake_1", "fake_2", 1), signature, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		succeeded := []string{}
+		r := httptest.NewRequest(http.MethodPost, "/payments/webhook", strings.NewReader(test.body))
+		r.Header.Set(payment.SignatureHeader, test.signature)
+		w := httptest.NewRecorder()
+		Payment{PaymentMapper: webhookPaymentMapper{succeeded: &succeeded}}.Webhook(w, r)
+		if code := w.Result().StatusCode; code != test.code {
+			t.Errorf("%v: got status %d, want %d", test.name, code, test.code)
+		}
+		if settled := len(succeeded) == 1; settled != (test.code == http.StatusOK) {
+			t.Errorf("%v: got payments settled %v", test.name, succeeded)
+		}
+	}
+}
@@ -1,23 +1,40 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/sirupsen/logrus"
 
 	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
 	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/imaging"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/petio"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/storage"
 	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
 )
 
-const imagePath = "static/uploads/images"
+const importBatchSize = 100
+
+// errImageNotFound is returned by image updates for keys the pet does not have
+var errImageNotFound = errors.New("image not found")
+
+const exportFlushSize = 100
 
 type Pet struct {
-	PetMapper mappers.PetMapperInterface
+	PetMapper        mappers.PetMapperInterface
+	PetHistoryMapper mappers.PetHistoryMapperInterface
+	// PublicURL is the configured address of the API that links to images start with
+	PublicURL string
 }
 
 func (p Pet) Create(w http.ResponseWriter, r *http.Request) {
@@ -44,7 +61,7 @@ func (p Pet) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = p.PetMapper.Create(pet)
+	err = p.PetMapper.WithActor(actor(r)).Create(pet)
 	if err != nil {
 		logrus.Error("Pet model save has failed", err)
 		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
@@ -54,6 +71,144 @@ func (p Pet) Create(w http.ResponseWriter, r *http.Request) {
 
 }
 
+type importReport struct {
+	Imported int              `json:"imported"`
+	Failed   int              `json:"failed"`
+	IDs      []int            `json:"ids"`
+	Errors   []petio.RowError `json:"errors"`
+}
+
+// Import creates pets from a csv or ndjson stream, the format is taken from the "format" query param
+// or the Content-Type header. Rows are saved in batches, failed rows are listed in the report.
+func (p Pet) Import(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	format, err := utils.GetURLParam(r, "format")
+	if err != nil {
+		format = petio.FormatFromContentType(r.Header.Get("Content-Type"))
+	}
+	reader, err := petio.NewReader(format, r.Body)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report := &importReport{IDs: []int{}, Errors: []petio.RowError{}}
+	mapper := p.PetMapper.WithActor(actor(r))
+	var batch []*models.Pet
+	var lines []int
+	saveBatch := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := mapper.CreateMany(batch)
+		if err != nil {
+			logrus.Error(err)
+			for _, line := range lines {
+				report.Errors = append(report.Errors, petio.RowError{Line: line, Err: "batch save failed: " + err.Error()})
+			}
+			report.Failed += len(batch)
+		} else {
+			for _, pet := range batch {
+				report.IDs = append(report.IDs, pet.ID)
+			}
+			report.Imported += len(batch)
+		}
+		batch, lines = nil, nil
+	}
+
+	for {
+		line, pet, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if rowErr, ok := err.(petio.RowError); ok {
+			report.Errors = append(report.Errors, rowErr)
+			report.Failed++
+			continue
+		}
+		if err != nil {
+			logrus.Error(err)
+			saveBatch()
+			report.Errors = append(report.Errors, petio.RowError{Line: line, Err: "import aborted: " + err.Error()})
+			break
+		}
+		err = pet.Validate()
+		if err != nil {
+			report.Errors = append(report.Errors, petio.RowError{Line: line, Err: err.Error()})
+			report.Failed++
+			continue
+		}
+		batch = append(batch, pet)
+		lines = append(lines, line)
+		if len(batch) == importBatchSize {
+			saveBatch()
+		}
+	}
+	saveBatch()
+
+	output, err := json.Marshal(report)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+// Export streams pets matching the filters of the query params as csv or ndjson.
+func (p Pet) Export(w http.ResponseWriter, r *http.Request) {
+	format, err := utils.GetURLParam(r, "format")
+	if err != nil {
+		format = petio.FormatCSV
+	}
+	contentType := petio.ContentType(format)
+	if contentType == "" {
+		JSONApiResponse(w, "Invalid format value", http.StatusBadRequest)
+		return
+	}
+	filter, err := petFilter(r)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out := newExportWriter(w, contentType, "pets."+format)
+	writer, err := petio.NewWriter(format, out)
+	if err != nil {
+		logrus.Error(err)
+		out.Fail(err)
+		return
+	}
+	flush := func() error {
+		err := writer.Flush()
+		out.Flush()
+		return err
+	}
+	count := 0
+	err = p.PetMapper.Export(filter, func(pet *models.Pet) error {
+		err := writer.Write(pet)
+		if err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushSize == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Error("pet export failed: ", err)
+		out.Fail(err)
+		return
+	}
+	err = flush()
+	if err != nil {
+		logrus.Error("pet export failed: ", err)
+	}
+}
+
 func (p Pet) GetByID(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
@@ -65,7 +220,24 @@ func (p Pet) GetByID(w http.ResponseWriter, r *http.Request) {
 		JSONApiResponse(w, "Invalid id supplied", http.StatusBadRequest)
 		return
 	}
-	pet, err := p.PetMapper.FindByID(id)
+	size, err := imageSize(r)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, "Invalid size value", http.StatusBadRequest)
+		return
+	}
+	var pet *models.Pet
+	if asOf, asOfErr := utils.GetURLParam(r, "asOf"); asOfErr == nil {
+		t, parseErr := time.Parse(time.RFC3339, asOf)
+		if parseErr != nil {
+			logrus.Error(parseErr)
+			JSONApiResponse(w, "Invalid asOf value", http.StatusBadRequest)
+			return
+		}
+		pet, err = p.PetHistoryMapper.FindPetAsOf(id, t)
+	} else {
+		pet, err = p.PetMapper.FindByID(id)
+	}
 	if err != nil {
 		logrus.Error(err)
 		switch err.(type) {
@@ -77,6 +249,15 @@ func (p Pet) GetByID(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	err = fillPhotoURLs(p.PublicURL, pet, size)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if pet.Version > 0 {
+		setETag(w, pet.Version)
+	}
 	output, err := json.Marshal(pet)
 	if err != nil {
 		logrus.Error(err)
@@ -87,6 +268,33 @@ func (p Pet) GetByID(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// GetHistory returns the changes of the pet with their authors, it is meant for admins only.
+func (p Pet) GetHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return
+	}
+
+	history, err := p.PetHistoryMapper.FindByPetID(id)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(history) == 0 {
+		JSONApiResponse(w, "Pet history not found", http.StatusNotFound)
+		return
+	}
+	output, err := json.Marshal(history)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
 func (p Pet) Update(w http.ResponseWriter, r *http.Request) {
 	data, err := ioutil.ReadAll(r.Body)
 	defer r.Body.Close()
@@ -107,8 +315,14 @@ func (p Pet) Update(w http.ResponseWriter, r *http.Request) {
 		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
 		return
 	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		logrus.Error(err)
+		preconditionFailed(w)
+		return
+	}
 
-	_, err = p.PetMapper.FindByID(pet.ID)
+	existing, err := p.PetMapper.FindByID(pet.ID)
 	if err != nil {
 		logrus.Error(err)
 		switch err.(type) {
@@ -121,6 +335,8 @@ func (p Pet) Update(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	pet.StripImageLinks(existing.Images)
+
 	err = pet.Validate()
 	if err != nil {
 		logrus.Error(err)
@@ -128,15 +344,36 @@ func (p Pet) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = p.PetMapper.Update(pet)
+	err = p.PetMapper.WithActor(actor(r)).WithVersion(version).WithRole(petRole(r)).Update(pet)
 	if err != nil {
 		logrus.Error(err)
-		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
-		return
+		switch err.(type) {
+		case mappers.VersionMismatchError:
+			preconditionFailed(w)
+			return
+		case mappers.ConflictError:
+			JSONApiResponse(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
+	setETag(w, pet.Version)
 
 }
 
+// List returns pets matching the filters of the query params, see petFilter.
+func (p Pet) List(w http.ResponseWriter, r *http.Request) {
+	filter, err := petFilter(r)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.find(w, r, filter)
+}
+
 func (p Pet) FindByStatus(w http.ResponseWriter, r *http.Request) {
 
 	status, err := utils.GetURLParam(r, "status")
@@ -152,19 +389,13 @@ func (p Pet) FindByStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pets, err := p.PetMapper.FindByStatus(status)
-	if err != nil {
-		logrus.Error(err)
-		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	output, err := json.Marshal(pets)
+	filter, err := petFilter(r)
 	if err != nil {
 		logrus.Error(err)
-		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		JSONApiResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	JSONResponse(w, output, http.StatusOK)
+	p.find(w, r, filter)
 
 }
 
@@ -183,12 +414,38 @@ func (p Pet) FindByTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pets, err := p.PetMapper.FindByTags(tags)
+	filter, err := petFilter(r)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.find(w, r, filter)
+
+}
+
+func (p Pet) find(w http.ResponseWriter, r *http.Request, filter mappers.PetFilter) {
+	size, err := imageSize(r)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, "Invalid size value", http.StatusBadRequest)
+		return
+	}
+
+	pets, err := p.PetMapper.Find(filter)
 	if err != nil {
 		logrus.Error(err)
 		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	for _, pet := range pets {
+		err = fillPhotoURLs(p.PublicURL, pet, size)
+		if err != nil {
+			logrus.Error(err)
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 	output, err := json.Marshal(pets)
 	if err != nil {
 		logrus.Error(err)
@@ -196,7 +453,6 @@ func (p Pet) FindByTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	JSONResponse(w, output, http.StatusOK)
-
 }
 
 func (p Pet) UpdateByID(w http.ResponseWriter, r *http.Request) {
@@ -205,6 +461,12 @@ func (p Pet) UpdateByID(w http.ResponseWriter, r *http.Request) {
 		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
 		return
 	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		logrus.Error(err)
+		preconditionFailed(w)
+		return
+	}
 
 	pet, err := p.PetMapper.FindByID(id)
 	if err != nil {
@@ -232,12 +494,22 @@ func (p Pet) UpdateByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = p.PetMapper.Update(pet)
+	err = p.PetMapper.WithActor(actor(r)).WithVersion(version).WithRole(petRole(r)).Update(pet)
 	if err != nil {
 		logrus.Error(err)
-		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
-		return
+		switch err.(type) {
+		case mappers.VersionMismatchError:
+			preconditionFailed(w)
+			return
+		case mappers.ConflictError:
+			JSONApiResponse(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
+	setETag(w, pet.Version)
 
 }
 
@@ -247,11 +519,20 @@ func (p Pet) Delete(w http.ResponseWriter, r *http.Request) {
 		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
 		return
 	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		logrus.Error(err)
+		preconditionFailed(w)
+		return
+	}
 
-	err = p.PetMapper.Delete(id)
+	err = p.PetMapper.WithActor(actor(r)).WithVersion(version).Delete(id)
 	if err != nil {
 		logrus.Error(err)
 		switch err.(type) {
+		case mappers.VersionMismatchError:
+			preconditionFailed(w)
+			return
 		case mappers.NotFoundError:
 			JSONApiResponse(w, "Pet not found", http.StatusNotFound)
 			return
@@ -263,12 +544,18 @@ func (p Pet) Delete(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (p Pet) UploadImage(w http.ResponseWriter, r *http.Request) {
+func (p Pet) GetImage(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil || id < 1 {
 		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
 		return
 	}
+	size, err := imageSize(r)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, "Invalid size value", http.StatusBadRequest)
+		return
+	}
 
 	pet, err := p.PetMapper.FindByID(id)
 	if err != nil {
@@ -282,23 +569,333 @@ func (p Pet) UploadImage(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	allowedMIMETypes := []string{"image/jpeg", "image/png"}
+	image := pet.Images.Find(chi.URLParam(r, "key"))
+	if image == nil {
+		JSONApiResponse(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	filename, ok := image.Variants[size]
+	if !ok {
+		filename = image.Variants[imaging.SizeOriginal]
+	}
 
-	filename := utils.RandomString(16)
-	imgURL, err := utils.SaveFile(r, "file", imagePath, filename, allowedMIMETypes)
+	object, info, err := storage.GetStorage().Get(storage.ImagesBucket, filename)
 	if err != nil {
 		logrus.Error(err)
-		JSONApiResponse(w, "Server error", http.StatusInternalServerError)
+		switch err.(type) {
+		case storage.NotFoundError:
+			JSONApiResponse(w, "Image not found", http.StatusNotFound)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	defer func() {
+		err = object.Close()
+		if err != nil {
+			logrus.Error(err)
+		}
+	}()
+
+	// every upload gets a new key, so the content behind a key never changes
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", fmt.Sprintf("%q", info.ETag))
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	// ServeContent handles Range, If-None-Match and If-Modified-Since
+	http.ServeContent(w, r, filename, info.LastModified, object)
+}
+
+func (p Pet) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		logrus.Error(err)
+		preconditionFailed(w)
 		return
 	}
-	pet.PhotoURLs = append(pet.PhotoURLs, imgURL)
 
-	err = p.PetMapper.Update(pet)
+	key := chi.URLParam(r, "key")
+	var filenames []string
+	_, err = p.PetMapper.WithActor(actor(r)).WithVersion(version).UpdateImages(id,
+		func(images models.PetImages) (models.PetImages, error) {
+			image := images.Find(key)
+			if image == nil {
+				return nil, errImageNotFound
+			}
+			filenames = models.PetImages{*image}.Filenames()
+			return images.Remove(key), nil
+		})
+	if err != nil {
+		logrus.Error(err)
+		if err == errImageNotFound {
+			JSONApiResponse(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Pet not found", http.StatusNotFound)
+			return
+		case mappers.VersionMismatchError:
+			preconditionFailed(w)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	// files are removed after the pet no longer references them,
+	// whatever fails to be removed here is collected by the image gc worker
+	deleteImageFiles(filenames)
+}
+
+func (p Pet) ReorderImages(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		logrus.Error(err)
+		preconditionFailed(w)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var keys []string
+	err = json.Unmarshal(data, &keys)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	images, err := p.PetMapper.WithActor(actor(r)).WithVersion(version).UpdateImages(id,
+		func(images models.PetImages) (models.PetImages, error) {
+			return images.Reorder(keys)
+		})
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case models.ValidationError:
+			JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Pet not found", http.StatusNotFound)
+			return
+		case mappers.VersionMismatchError:
+			preconditionFailed(w)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	output, err := json.Marshal(images)
 	if err != nil {
 		logrus.Error(err)
 		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+func (p Pet) UploadImage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		logrus.Error(err)
+		preconditionFailed(w)
+		return
+	}
+
+	pet, err := p.PetMapper.FindByID(id)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Pet not found", http.StatusNotFound)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	allowedMIMETypes := []string{"image/jpeg", "image/png"}
+
+	data, err := utils.ReadFormFile(r, "file", allowedMIMETypes)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, "Invalid file supplied", http.StatusBadRequest)
+		return
+	}
+	variants, err := imaging.Variants(data)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, "Invalid image supplied", http.StatusBadRequest)
+		return
+	}
+
+	image := models.PetImage{Key: utils.RandomString(16), Variants: map[string]string{}}
+	store := storage.GetStorage()
+	for _, v := range variants {
+		filename := fmt.Sprintf("%d/%s/%s.%s", pet.ID, image.Key, v.Size, v.Extension)
+		err = store.Put(storage.ImagesBucket, filename, v.ContentType, bytes.NewReader(v.Data), int64(len(v.Data)))
+		if err != nil {
+			logrus.Error(err)
+			deleteImageFiles(models.PetImages{image}.Filenames())
+			JSONApiResponse(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+		image.Variants[v.Size] = filename
+	}
+
+	_, err = p.PetMapper.WithActor(actor(r)).WithVersion(version).UpdateImages(pet.ID,
+		func(images models.PetImages) (models.PetImages, error) {
+			return append(images, image), nil
+		})
+	if err != nil {
+		logrus.Error(err)
+		// the pet does not reference the uploaded files
+		deleteImageFiles(models.PetImages{image}.Filenames())
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Pet not found", http.StatusNotFound)
+			return
+		case mappers.VersionMismatchError:
+			preconditionFailed(w)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	JSONApiResponse(w, "Success upload", http.StatusOK)
 }
+
+// imageSize returns the image size requested by the "size" query param.
+func imageSize(r *http.Request) (string, error) {
+	size, err := utils.GetURLParam(r, "size")
+	if err != nil {
+		return imaging.SizeOriginal, nil
+	}
+	if !imaging.IsSize(size) {
+		return "", fmt.Errorf("unknown image size %v", size)
+	}
+	return size, nil
+}
+
+// fillPhotoURLs appends links to the uploaded images of the given size.
+// Backends without direct links get a link to the image streaming endpoint under the public url,
+// the request headers are never trusted to build it.
+func fillPhotoURLs(publicURL string, pet *models.Pet, size string) error {
+	store := storage.GetStorage()
+	for _, image := range pet.Images {
+		filename, ok := image.Variants[size]
+		if !ok {
+			filename = image.Variants[imaging.SizeOriginal]
+		}
+		link, err := store.GetLink(storage.ImagesBucket, filename)
+		if err == storage.ErrLinkNotSupported {
+			link = fmt.Sprintf("%v/pet/%d/images/%s?size=%s", strings.TrimSuffix(publicURL, "/"), pet.ID, image.Key, size)
+		} else if err != nil {
+			return err
+		}
+		pet.PhotoURLs = append(pet.PhotoURLs, link)
+	}
+	return nil
+}
+
+func deleteImageFiles(filenames []string) {
+	store := storage.GetStorage()
+	for _, filename := range filenames {
+		err := store.Delete(storage.ImagesBucket, filename)
+		if err != nil {
+			logrus.Errorf("unable to remove image file %v: %v", filename, err)
+		}
+	}
+}
+
+// petFilter reads listing filters from the query params: status, category, tags, species, breed, sex,
+// currency, minPrice, maxPrice, minAge and maxAge (in years).
+func petFilter(r *http.Request) (mappers.PetFilter, error) {
+	filter := mappers.PetFilter{}
+	if status, err := utils.GetURLParam(r, "status"); err == nil {
+		err = models.Pet{}.CheckStatus(status)
+		if err != nil {
+			return filter, err
+		}
+		filter.Status = status
+	}
+	if category, err := utils.GetURLParam(r, "category"); err == nil {
+		filter.Category = category
+	}
+	if tags, err := utils.GetURLParams(r, "tags"); err == nil {
+		filter.Tags = tags
+	}
+	filter.Species, _ = utils.GetURLParam(r, "species")
+	filter.Breed, _ = utils.GetURLParam(r, "breed")
+	filter.Sex, _ = utils.GetURLParam(r, "sex")
+	filter.Currency, _ = utils.GetURLParam(r, "currency")
+	var err error
+	filter.MinPrice, err = decimalParam(r, "minPrice")
+	if err != nil {
+		return filter, err
+	}
+	filter.MaxPrice, err = decimalParam(r, "maxPrice")
+	if err != nil {
+		return filter, err
+	}
+	filter.MinAge, err = ageParam(r, "minAge")
+	if err != nil {
+		return filter, err
+	}
+	filter.MaxAge, err = ageParam(r, "maxAge")
+	if err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// decimalParam reads an optional decimal query param, nil when it is missing.
+func decimalParam(r *http.Request, name string) (*models.Decimal, error) {
+	value, err := utils.GetURLParam(r, name)
+	if err != nil {
+		return nil, nil
+	}
+	d, err := models.ParseDecimal(value)
+	if err != nil {
+		return nil, models.ValidationError("invalid " + name + " value")
+	}
+	return &d, nil
+}
+
+// ageParam reads an optional non negative integer query param, nil when it is missing.
+func ageParam(r *http.Request, name string) (*int, error) {
+	value, err := utils.GetURLParam(r, name)
+	if err != nil {
+		return nil, nil
+	}
+	age, err := strconv.Atoi(value)
+	if err != nil || age < 0 {
+		return nil, models.ValidationError("invalid " + name + " value")
+	}
+	return &age, nil
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/imaging"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/storage"
+)
+
+func TestFillPhotoURLsUsesPublicURL(t *testing.T) {
+	root, err := ioutil.TempDir("", "petstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	storage.InitFilesystem(storage.FilesystemConfig{Root: root})
+
+	pet := &models.Pet{ID: 7, Images: models.PetImages{{Key: "abc", Variants: map[string]string{
+		imaging.SizeOriginal: "7/abc/original.png",
+		imaging.SizeMedium:   "7/abc/medium.png",
+	}}}}
+	tests := []struct {
+		publicURL string
+		want      string
+	}{
+		{"https://shop.example.com/", "https://shop.example.com/pet/7/images/abc?size=medium"},
+		{"", "/pet/7/images/abc?size=medium"},
+	}
+	for _, test := range tests {
+		pet.PhotoURLs = nil
+		err = fillPhotoURLs(test.publicURL, pet, imaging.SizeMedium)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pet.PhotoURLs) != 1 || pet.PhotoURLs[0] != test.want {
+			t.Errorf("public url %q: got %v, want %v", test.publicURL, pet.PhotoURLs, test.want)
+		}
+	}
+}
+
+// exportPetMapper exports count pets and fails with err after them.
+type exportPetMapper struct {
+	mappers.PetMapperInterface
+	count int
+	err   error
+}
+
+func (m exportPetMapper) Export(filter mappers.PetFilter, fn func(*models.Pet) error) error {
+	for i := 1; i <= m.count; i++ {
+		err := fn(&models.Pet{ID: i, Name: "Pet with a long enough name", Status: models.PetStatusAvailable})
+		if err != nil {
+			return err
+		}
+	}
+	return m.err
+}
+
+func TestPetExport(t *testing.T) {
+	failure := errors.New("export failure")
+	tests := []struct {
+		name        string
+		mapper      exportPetMapper
+		code        int
+		contentType string
+		attachment  bool
+		lines       int
+	}{
+		// hundreds of rows are far more than the buffer of the csv writer
+		{"complete", exportPetMapper{count: 300}, http.StatusOK, "text/csv", true, 301},
+		{"empty", exportPetMapper{}, http.StatusOK, "text/csv", true, 1},
+		{"failed before the first row", exportPetMapper{err: failure}, http.StatusInternalServerError,
+			"application/json", false, 0},
+		{"failed after rows are sent", exportPetMapper{count: 150, err: failure}, http.StatusOK, "text/csv", true, 101},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		Pet{PetMapper: test.mapper}.Export(w, httptest.NewRequest(http.MethodGet, "/pet/export?format=csv", nil))
+		response := w.Result()
+		body, _ := ioutil.ReadAll(response.Body)
+		if response.StatusCode != test.code {
+			t.Errorf("%v: got status %d, want %d", test.name, response.StatusCode, test.code)
+		}
+		if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, test.contentType) {
+			t.Errorf("%v: got content type %q, want %v", test.name, contentType, test.contentType)
+		}
+		if attachment := response.Header.Get("Content-Disposition") != ""; attachment != test.attachment {
+			t.Errorf("%v: got Content-Disposition %q", test.name, response.Header.Get("Content-Disposition"))
+		}
+		if lines := strings.Count(string(body), "\n"); lines != test.lines {
+			t.Errorf("%v: got %d lines, want %d", test.name, lines, test.lines)
+		}
+		if test.code == http.StatusOK && strings.Contains(string(body), failure.Error()) {
+			t.Errorf("%v: error response has been appended to the export", test.name)
+		}
+	}
+}
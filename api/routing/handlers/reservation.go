@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/auth"
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+)
+
+const defaultHoldTime = 48 * time.Hour
+
+type ReservationConfig struct {
+	HoldTime utils.Duration
+}
+
+type Reservation struct {
+	ReservationMapper mappers.ReservationMapperInterface
+	HoldTime          time.Duration
+}
+
+func NewReservation(reservationMapper mappers.ReservationMapperInterface, config ReservationConfig) Reservation {
+	holdTime := config.HoldTime.Duration
+	if holdTime <= 0 {
+		holdTime = defaultHoldTime
+	}
+	return Reservation{ReservationMapper: reservationMapper, HoldTime: holdTime}
+}
+
+func (res Reservation) Create(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetAuthService().GetUser(r)
+	if user == nil {
+		JSONApiResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reservation := &models.Reservation{}
+	err = json.Unmarshal(data, reservation)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if reservation.PetID < 1 {
+		JSONApiResponse(w, "Invalid pet ID supplied", http.StatusBadRequest)
+		return
+	}
+	reservation.UserID = user.ID
+
+	err = res.ReservationMapper.WithActor(user.Username).Create(reservation, res.HoldTime)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Pet not found", http.StatusNotFound)
+			return
+		case mappers.ConflictError:
+			JSONApiResponse(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	output, err := json.Marshal(reservation)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusCreated)
+}
+
+func (res Reservation) List(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetAuthService().GetUser(r)
+	if user == nil {
+		JSONApiResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	reservations, err := res.ReservationMapper.FindByUserID(user.ID)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	output, err := json.Marshal(reservations)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+func (res Reservation) GetByID(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := res.findOwn(w, r)
+	if !ok {
+		return
+	}
+	output, err := json.Marshal(reservation)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+func (res Reservation) Cancel(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := res.findOwn(w, r)
+	if !ok {
+		return
+	}
+	reservation, err := res.ReservationMapper.WithActor(actor(r)).Cancel(reservation.ID)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Reservation not found", http.StatusNotFound)
+			return
+		case mappers.ConflictError:
+			JSONApiResponse(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	output, err := json.Marshal(reservation)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+// findOwn loads the reservation from the id url param, only its holder and admins may access it.
+// The error response is already written when ok is false.
+func (res Reservation) findOwn(w http.ResponseWriter, r *http.Request) (reservation *models.Reservation, ok bool) {
+	user := auth.GetAuthService().GetUser(r)
+	if user == nil {
+		JSONApiResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return nil, false
+	}
+	reservation, err = res.ReservationMapper.FindByID(id)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Reservation not found", http.StatusNotFound)
+			return nil, false
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return nil, false
+		}
+	}
+	if reservation.UserID != user.ID && !auth.IsAdmin(user) {
+		JSONApiResponse(w, "Reservation not found", http.StatusNotFound)
+		return nil, false
+	}
+	return reservation, true
+}
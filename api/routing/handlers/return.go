@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/auth"
+)
+
+// Return lets customers return pets of delivered orders and the staff approve, reject and refund the returns.
+type Return struct {
+	ReturnMapper  mappers.ReturnMapperInterface
+	OrderMapper   mappers.OrderMapperInterface
+	PaymentMapper mappers.PaymentMapperInterface
+}
+
+// Create requests a return of the order, the body is {"reason": "...", "items": [{"petId": 1}]},
+// without items every pet of the order not returned yet goes back.
+func (h Return) Create(w http.ResponseWriter, r *http.Request) {
+	order, ok := ownOrder(w, r, h.OrderMapper)
+	if !ok {
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ret := &models.Return{}
+	err = json.Unmarshal(data, ret)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	err = ret.Validate()
+	if err != nil {
+		JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ret.OrderID = order.ID
+	ret.UserID = order.UserID
+
+	err = h.ReturnMapper.WithActor(actor(r)).Create(ret)
+	if err != nil {
+		logrus.Error(err)
+		h.error(w, err)
+		return
+	}
+	h.respond(w, ret, http.StatusCreated)
+}
+
+func (h Return) ListByOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := ownOrder(w, r, h.OrderMapper)
+	if !ok {
+		return
+	}
+	returns, err := h.ReturnMapper.FindByOrderID(order.ID)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	output, err := json.Marshal(returns)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+// List returns returns with the status query parameter, admins see every return and customers their own.
+func (h Return) List(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetAuthService().GetUser(r)
+	if user == nil {
+		JSONApiResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var userID *int
+	if !auth.IsAdmin(user) {
+		userID = &user.ID
+	}
+	returns, err := h.ReturnMapper.Find(r.URL.Query().Get("status"), userID)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	output, err := json.Marshal(returns)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+func (h Return) GetByID(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetAuthService().GetUser(r)
+	if user == nil {
+		JSONApiResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return
+	}
+	ret, err := h.ReturnMapper.FindByID(id)
+	if err != nil {
+		logrus.Error(err)
+		h.error(w, err)
+		return
+	}
+	if !auth.IsAdmin(user) && (ret.UserID == nil || *ret.UserID != user.ID) {
+		JSONApiResponse(w, "Return not found", http.StatusNotFound)
+		return
+	}
+	h.respond(w, ret, http.StatusOK)
+}
+
+// Approve accepts the return, the pets go back to sale, a credit note is issued
+// and the amount is refunded to the payment of the order when it has been paid through the provider.
+// A failed refund leaves the return approved, it can be refunded again later.
+func (h Return) Approve(w http.ResponseWriter, r *http.Request) {
+	id, resolution, ok := h.resolution(w, r, false)
+	if !ok {
+		return
+	}
+	ret, err := h.ReturnMapper.WithActor(actor(r)).Approve(id, resolution)
+	if err != nil {
+		logrus.Error(err)
+		h.error(w, err)
+		return
+	}
+	paid, err := refundablePayment(h.PaymentMapper, ret.OrderID, ret.Amount)
+	if err != nil {
+		logrus.Error(err)
+	}
+	if paid != nil {
+		refunded, err := h.refund(r, ret, paid)
+		if err != nil {
+			logrus.Errorf("refund of return %d failed: %v", ret.ID, err)
+		} else {
+			ret = refunded
+		}
+	}
+	h.respond(w, ret, http.StatusOK)
+}
+
+// Reject refuses the return, the body is {"resolution": "..."} telling the customer why.
+func (h Return) Reject(w http.ResponseWriter, r *http.Request) {
+	id, resolution, ok := h.resolution(w, r, true)
+	if !ok {
+		return
+	}
+	ret, err := h.ReturnMapper.WithActor(actor(r)).Reject(id, resolution)
+	if err != nil {
+		logrus.Error(err)
+		h.error(w, err)
+		return
+	}
+	h.respond(w, ret, http.StatusOK)
+}
+
+// Refund completes an approved return, the amount goes back to the payment of the order through the provider.
+// Returns of orders without such a payment are recorded as refunded outside of the provider.
+func (h Return) Refund(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return
+	}
+	ret, err := h.ReturnMapper.FindByID(id)
+	if err != nil {
+		logrus.Error(err)
+		h.error(w, err)
+		return
+	}
+	if ret.Status != models.ReturnStatusApproved {
+		JSONApiResponse(w, "Only approved returns can be refunded", http.StatusConflict)
+		return
+	}
+	paid, err := refundablePayment(h.PaymentMapper, ret.OrderID, ret.Amount)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if paid != nil {
+		ret, err = h.refund(r, ret, paid)
+		if err != nil {
+			logrus.Error(err)
+			refundError(w, err)
+			return
+		}
+	} else {
+		ret, err = h.ReturnMapper.WithActor(actor(r)).MarkRefunded(id, nil)
+		if err != nil {
+			logrus.Error(err)
+			h.error(w, err)
+			return
+		}
+	}
+	h.respond(w, ret, http.StatusOK)
+}
+
+func (h Return) refund(r *http.Request, ret *models.Return, paid *models.Payment) (*models.Return, error) {
+	_, err := refundPayment(h.PaymentMapper.WithActor(actor(r)), paid, ret.Amount, &ret.ID)
+	if err != nil {
+		return nil, err
+	}
+	// the completed refund has completed the return as well
+	return h.ReturnMapper.FindByID(ret.ID)
+}
+
+// resolution reads the return id of the path and the {"resolution": "..."} body of the staff.
+func (h Return) resolution(w http.ResponseWriter, r *http.Request, required bool) (int, string, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return 0, "", false
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return 0, "", false
+	}
+	var input struct {
+		Resolution string `json:"resolution"`
+	}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &input)
+		if err != nil {
+			JSONApiResponse(w, "Invalid input", http.StatusBadRequest)
+			return 0, "", false
+		}
+	}
+	if required && input.Resolution == "" {
+		JSONApiResponse(w, "resolution is required", http.StatusBadRequest)
+		return 0, "", false
+	}
+	return id, input.Resolution, true
+}
+
+func (h Return) error(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case models.ValidationError:
+		JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+	case mappers.NotFoundError:
+		JSONApiResponse(w, err.Error(), http.StatusNotFound)
+	case mappers.ConflictError:
+		JSONApiResponse(w, err.Error(), http.StatusConflict)
+	default:
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h Return) respond(w http.ResponseWriter, ret *models.Return, code int) {
+	output, err := json.Marshal(ret)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, code)
+}
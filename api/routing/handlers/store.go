@@ -1,32 +1,79 @@
 package handlers
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/sirupsen/logrus"
 	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
 	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/auth"
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
 )
 
+const (
+	defaultOrderPageSize = 50
+	maxOrderPageSize     = 500
+)
+
+var orderCSVHeader = []string{"id", "petId", "userId", "quantity", "status", "complete", "shipDate",
+	"createdAt", "updatedAt", "items", "currency", "subtotal", "discount", "total", "couponCode"}
+
 type Store struct {
-	PetMapper   mappers.PetMapperInterface
-	OrderMapper mappers.OrderMapperInterface
+	PetMapper        mappers.PetMapperInterface
+	OrderMapper      mappers.OrderMapperInterface
+	InventoryMapper  mappers.InventoryMapperInterface
+	PaymentMapper    mappers.PaymentMapperInterface
+	OrderEventMapper mappers.OrderEventMapperInterface
 }
 
-func (Store) GetInventory(w http.ResponseWriter, r *http.Request) {
+// GetInventory returns pet counts by status. The "by" query param breaks them down
+// by "category" or "tag", "measure=favorites" counts favorites of the pets instead.
+func (s Store) GetInventory(w http.ResponseWriter, r *http.Request) {
+	by, _ := utils.GetURLParam(r, "by")
+	if by != "" && by != "category" && by != "tag" {
+		JSONApiResponse(w, "Invalid by value", http.StatusBadRequest)
+		return
+	}
+	measure, _ := utils.GetURLParam(r, "measure")
+	if measure != "" && measure != "pets" && measure != "favorites" {
+		JSONApiResponse(w, "Invalid measure value", http.StatusBadRequest)
+		return
+	}
 
-	data := struct {
-		AdditionalProp1 int
-		AdditionalProp2 int
-		AdditionalProp3 int
-	}{
-		1,
-		2,
-		3,
+	report, err := s.InventoryMapper.Get()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pick := func(counts *models.InventoryCounts) models.Inventory {
+		if measure == "favorites" {
+			return counts.Favorites
+		}
+		return counts.Pets
+	}
+	var data interface{}
+	switch by {
+	case "category", "tag":
+		groups := report.Categories
+		if by == "tag" {
+			groups = report.Tags
+		}
+		breakdown := make(map[string]models.Inventory, len(groups))
+		for name, counts := range groups {
+			breakdown[name] = pick(counts)
+		}
+		data = breakdown
+	default:
+		data = pick(report.Total)
 	}
 
 	output, err := json.Marshal(data)
@@ -50,93 +97,455 @@ func (s Store) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(b, order)
 	if err != nil {
 		logrus.Error(err)
+		if _, ok := err.(models.ValidationError); ok {
+			JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	logrus.Info("order:", order)
+	order.UserID = nil
+	if user := auth.GetAuthService().GetUser(r); user != nil {
+		order.UserID = &user.ID
+	}
+	// orders are always placed, later statuses are reached through the lifecycle endpoints
+	order.Status = models.OrderStatusPlaced
 	err = order.Validate()
 	if err != nil {
 		logrus.Error(err)
-		JSONApiResponse(w, err.Error(), http.StatusMethodNotAllowed)
+		if _, ok := err.(models.ValidationError); ok {
+			JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	_, err = s.PetMapper.FindByID(order.PetID)
+	err = s.OrderMapper.WithActor(actor(r)).Create(order)
 	if err != nil {
 		logrus.Error(err)
 		switch err.(type) {
 		case mappers.NotFoundError:
-			JSONApiResponse(w, "Pet with given id have not found", http.StatusNotFound)
+			JSONApiResponse(w, err.Error(), http.StatusNotFound)
+			return
+		case mappers.ConflictError:
+			JSONApiResponse(w, err.Error(), http.StatusConflict)
 			return
 		default:
 			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
-	err = s.OrderMapper.Create(order)
+	setETag(w, order.Version)
+	output, err := json.Marshal(order)
 	if err != nil {
 		logrus.Error(err)
 		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	output, err := json.Marshal(order)
+	JSONResponse(w, output, http.StatusCreated)
+
+}
+
+// ListOrders returns a page of orders matching the filters of the query params, see orderFilter.
+// Totals of all matching orders are sent in the X-Total-Count and X-Total-Quantity headers.
+func (s Store) ListOrders(w http.ResponseWriter, r *http.Request) {
+	filter, ok := s.orderFilter(w, r)
+	if !ok {
+		return
+	}
+	filter.Limit = defaultOrderPageSize
+	if value, err := utils.GetURLParam(r, "limit"); err == nil {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxOrderPageSize {
+			JSONApiResponse(w, fmt.Sprintf("Invalid limit value, expected 1 to %d", maxOrderPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+	if value, err := utils.GetURLParam(r, "offset"); err == nil {
+		filter.Offset, err = strconv.Atoi(value)
+		if err != nil || filter.Offset < 0 {
+			JSONApiResponse(w, "Invalid offset value", http.StatusBadRequest)
+			return
+		}
+	}
+
+	totals, err := s.OrderMapper.Totals(filter)
 	if err != nil {
 		logrus.Error(err)
 		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	JSONResponse(w, output, http.StatusCreated)
+	orders, err := s.OrderMapper.Find(filter)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	output, err := json.Marshal(orders)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(totals.Count))
+	w.Header().Set("X-Total-Quantity", strconv.Itoa(totals.Quantity))
+	JSONResponse(w, output, http.StatusOK)
+}
+
+// ExportOrders streams every order matching the filters of the query params as csv.
+func (s Store) ExportOrders(w http.ResponseWriter, r *http.Request) {
+	filter, ok := s.orderFilter(w, r)
+	if !ok {
+		return
+	}
+
+	out := newExportWriter(w, "text/csv; charset=utf-8", "orders.csv")
+	writer := csv.NewWriter(out)
+	flush := func() error {
+		writer.Flush()
+		out.Flush()
+		return writer.Error()
+	}
+	err := writer.Write(orderCSVHeader)
+	if err != nil {
+		logrus.Error(err)
+		out.Fail(err)
+		return
+	}
+	count := 0
+	err = s.OrderMapper.Export(filter, func(o *models.Order) error {
+		err := writer.Write(orderCSVRecord(o))
+		if err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushSize == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Error("order export failed: ", err)
+		out.Fail(err)
+		return
+	}
+	err = flush()
+	if err != nil {
+		logrus.Error("order export failed: ", err)
+	}
+}
+
+func orderCSVRecord(o *models.Order) []string {
+	userID, shipDate, couponCode := "", "", ""
+	if o.CouponCode != nil {
+		couponCode = *o.CouponCode
+	}
+	items := make([]string, 0, len(o.Items))
+	for _, item := range o.Items {
+		items = append(items, fmt.Sprintf("%d:%d", item.PetID, item.Quantity))
+	}
+	if o.UserID != nil {
+		userID = strconv.Itoa(*o.UserID)
+	}
+	if o.ShipDate != nil {
+		shipDate = o.ShipDate.UTC().Format(models.ShipDateFormat)
+	}
+	return []string{
+		strconv.Itoa(o.ID),
+		strconv.Itoa(o.PetID),
+		userID,
+		strconv.Itoa(o.Quantity),
+		o.Status,
+		strconv.FormatBool(o.Complete),
+		shipDate,
+		o.CreatedAt.UTC().Format(models.ShipDateFormat),
+		o.UpdatedAt.UTC().Format(models.ShipDateFormat),
+		// pet id and quantity of every line, e.g. "12:1 15:2"
+		strings.Join(items, " "),
+		o.Currency,
+		o.Subtotal.String(),
+		o.Discount.String(),
+		o.Total.String(),
+		couponCode,
+	}
+}
+
+// orderFilter reads the order filter from the query params: status, complete, petId, customer (username),
+// createdFrom, createdTo, shipFrom, shipTo (dates or RFC 3339 times) and sort (e.g. "-createdAt").
+// Customers only see their own orders, it writes the error response and returns false when the request is invalid.
+func (s Store) orderFilter(w http.ResponseWriter, r *http.Request) (mappers.OrderFilter, bool) {
+	filter := mappers.OrderFilter{}
+	user := auth.GetAuthService().GetUser(r)
+	if user == nil {
+		JSONApiResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return filter, false
+	}
+	if auth.IsAdmin(user) {
+		filter.Customer, _ = utils.GetURLParam(r, "customer")
+	} else {
+		filter.UserID = user.ID
+	}
+
+	invalid := func(name string) (mappers.OrderFilter, bool) {
+		JSONApiResponse(w, "Invalid "+name+" value", http.StatusBadRequest)
+		return filter, false
+	}
+	if status, err := utils.GetURLParam(r, "status"); err == nil {
+		err = models.Order{}.CheckStatus(status)
+		if err != nil {
+			return invalid("status")
+		}
+		filter.Status = status
+	}
+	if value, err := utils.GetURLParam(r, "complete"); err == nil {
+		complete, err := strconv.ParseBool(value)
+		if err != nil {
+			return invalid("complete")
+		}
+		filter.Complete = &complete
+	}
+	if value, err := utils.GetURLParam(r, "petId"); err == nil {
+		filter.PetID, err = strconv.Atoi(value)
+		if err != nil || filter.PetID < 1 {
+			return invalid("petId")
+		}
+	}
+	bounds := []struct {
+		name  string
+		value **time.Time
+	}{
+		{"createdFrom", &filter.CreatedFrom},
+		{"createdTo", &filter.CreatedTo},
+		{"shipFrom", &filter.ShipFrom},
+		{"shipTo", &filter.ShipTo},
+	}
+	for _, bound := range bounds {
+		t, err := timeParam(r, bound.name)
+		if err != nil {
+			return invalid(bound.name)
+		}
+		*bound.value = t
+	}
+	if sort, err := utils.GetURLParam(r, "sort"); err == nil {
+		if _, ok := mappers.OrderSorts[strings.TrimPrefix(sort, "-")]; !ok {
+			return invalid("sort")
+		}
+		filter.Sort = sort
+	}
+	return filter, true
+}
 
+// timeParam reads an optional time query param formatted as a date or an RFC 3339 time, nil when it is missing.
+// Dates are midnights in UTC.
+func timeParam(r *http.Request, name string) (*time.Time, error) {
+	value, err := utils.GetURLParam(r, name)
+	if err != nil {
+		return nil, nil
+	}
+	for _, layout := range []string{models.DateFormat, time.RFC3339Nano} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return &t, nil
+		}
+	}
+	return nil, models.ValidationError("invalid " + name + " value")
 }
 
+// GetByID returns the order, customers see only orders of their own.
 func (s Store) GetByID(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	order, ok := ownOrder(w, r, s.OrderMapper)
+	if !ok {
+		return
+	}
+	setETag(w, order.Version)
+	output, err := json.Marshal(order)
 	if err != nil {
 		logrus.Error(err)
 		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	JSONResponse(w, output, http.StatusOK)
+
+}
 
-	order, err := s.OrderMapper.FindByID(id)
+// ownOrder finds the order of the path, customers may only reach orders of their own.
+func ownOrder(w http.ResponseWriter, r *http.Request, orderMapper mappers.OrderMapperInterface) (*models.Order, bool) {
+	user := auth.GetAuthService().GetUser(r)
+	if user == nil {
+		JSONApiResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return nil, false
+	}
+	order, err := orderMapper.FindByID(id)
 	if err != nil {
 		logrus.Error(err)
 		switch err.(type) {
 		case mappers.NotFoundError:
 			JSONApiResponse(w, "Order not found", http.StatusNotFound)
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	if !auth.IsAdmin(user) && (order.UserID == nil || *order.UserID != user.ID) {
+		JSONApiResponse(w, "Order not found", http.StatusNotFound)
+		return nil, false
+	}
+	return order, true
+}
+
+// Delete moves the order to the trash, an open order is cancelled first and what has been paid is refunded.
+func (s Store) Delete(w http.ResponseWriter, r *http.Request) {
+	order, ok := ownOrder(w, r, s.OrderMapper)
+	if !ok {
+		return
+	}
+
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		logrus.Error(err)
+		preconditionFailed(w)
+		return
+	}
+
+	err = s.OrderMapper.WithActor(actor(r)).WithVersion(version).Delete(order.ID)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.VersionMismatchError:
+			preconditionFailed(w)
+			return
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Order not found", http.StatusNotFound)
+			return
+		case mappers.ConflictError:
+			JSONApiResponse(w, err.Error(), http.StatusConflict)
 			return
 		default:
 			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
-	output, err := json.Marshal(order)
+	if order.IsOpen() {
+		s.refundCancelled(order.ID, actor(r))
+	}
+}
+
+// Approve confirms a placed order.
+func (s Store) Approve(w http.ResponseWriter, r *http.Request) {
+	s.transition(w, r, func(mapper mappers.OrderMapperInterface, id int) (*models.Order, error) {
+		return mapper.Approve(id)
+	})
+}
+
+// Deliver completes an approved order, its pet becomes sold.
+func (s Store) Deliver(w http.ResponseWriter, r *http.Request) {
+	s.transition(w, r, func(mapper mappers.OrderMapperInterface, id int) (*models.Order, error) {
+		return mapper.Deliver(id)
+	})
+}
+
+// Cancel cancels a placed or approved order, its pet becomes available again and what has been paid is refunded.
+// Customers may cancel only orders of their own.
+func (s Store) Cancel(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ownOrder(w, r, s.OrderMapper); !ok {
+		return
+	}
+	s.transition(w, r, func(mapper mappers.OrderMapperInterface, id int) (*models.Order, error) {
+		order, err := mapper.Cancel(id)
+		if err != nil {
+			return nil, err
+		}
+		s.refundCancelled(order.ID, actor(r))
+		return order, nil
+	})
+}
+
+// refundCancelled refunds what is left of the succeeded payments of the cancelled order,
+// failures are logged and left to the staff, the credit note of the cancellation records the debt.
+func (s Store) refundCancelled(orderID int, actor string) {
+	payments, err := s.PaymentMapper.FindByOrderID(orderID)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	for _, attempt := range payments {
+		if attempt.Status != models.PaymentStatusSucceeded || attempt.Refunded >= attempt.Amount {
+			continue
+		}
+		_, err = refundPayment(s.PaymentMapper.WithActor(actor), attempt, attempt.Amount-attempt.Refunded, nil)
+		if err != nil {
+			logrus.Errorf("refund of payment %d of cancelled order %d failed: %v", attempt.ID, orderID, err)
+		}
+	}
+}
+
+// GetTimeline returns the steps the order has gone through, the oldest first.
+// Customers see only orders of their own.
+func (s Store) GetTimeline(w http.ResponseWriter, r *http.Request) {
+	order, ok := ownOrder(w, r, s.OrderMapper)
+	if !ok {
+		return
+	}
+	events, err := s.OrderEventMapper.FindByOrderID(order.ID)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	output, err := json.Marshal(events)
 	if err != nil {
 		logrus.Error(err)
 		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	JSONResponse(w, output, http.StatusOK)
-
 }
 
-func (s Store) Delete(w http.ResponseWriter, r *http.Request) {
+func (s Store) transition(w http.ResponseWriter, r *http.Request,
+	fn func(mapper mappers.OrderMapperInterface, id int) (*models.Order, error)) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil || id < 1 {
 		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
 		return
 	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		logrus.Error(err)
+		preconditionFailed(w)
+		return
+	}
 
-	err = s.OrderMapper.Delete(id)
+	order, err := fn(s.OrderMapper.WithActor(actor(r)).WithVersion(version), id)
 	if err != nil {
 		logrus.Error(err)
 		switch err.(type) {
+		case mappers.VersionMismatchError:
+			preconditionFailed(w)
+			return
 		case mappers.NotFoundError:
 			JSONApiResponse(w, "Order not found", http.StatusNotFound)
 			return
+		case mappers.ConflictError:
+			JSONApiResponse(w, err.Error(), http.StatusConflict)
+			return
 		default:
 			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
-
+	setETag(w, order.Version)
+	output, err := json.Marshal(order)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
 }
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+// exportOrderMapper exports count orders and fails with err after them.
+type exportOrderMapper struct {
+	mappers.OrderMapperInterface
+	count int
+	err   error
+}
+
+func (m exportOrderMapper) Export(filter mappers.OrderFilter, fn func(*models.Order) error) error {
+	for i := 1; i <= m.count; i++ {
+		err := fn(&models.Order{ID: i, PetID: i, Quantity: 1, Status: models.OrderStatusPlaced, Currency: "USD",
+			Subtotal: 1999, Total: 1999})
+		if err != nil {
+			return err
+		}
+	}
+	return m.err
+}
+
+func TestExportOrders(t *testing.T) {
+	failure := errors.New("export failure")
+	tests := []struct {
+		name       string
+		mapper     exportOrderMapper
+		code       int
+		attachment bool
+		lines      int
+	}{
+		{"complete", exportOrderMapper{count: 300}, http.StatusOK, true, 301},
+		{"empty", exportOrderMapper{}, http.StatusOK, true, 1},
+		{"failed before the first row", exportOrderMapper{err: failure}, http.StatusInternalServerError, false, 0},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := authenticated(t, httptest.NewRequest(http.MethodGet, "/store/order/export", nil), &models.User{ID: 1, Username: testAdmin})
+		Store{OrderMapper: test.mapper}.ExportOrders(w, r)
+		response := w.Result()
+		body, _ := ioutil.ReadAll(response.Body)
+		if response.StatusCode != test.code {
+			t.Errorf("%v: got status %d, want %d", test.name, response.StatusCode, test.code)
+		}
+		if attachment := response.Header.Get("Content-Disposition") != ""; attachment != test.attachment {
+			t.Errorf("%v: got Content-Disposition %q", test.name, response.Header.Get("Content-Disposition"))
+		}
+		if test.attachment && !strings.HasPrefix(response.Header.Get("Content-Type"), "text/csv") {
+			t.Errorf("%v: got content type %q, want text/csv", test.name, response.Header.Get("Content-Type"))
+		}
+		if lines := strings.Count(string(body), "\n"); lines != test.lines {
+			t.Errorf("%v: got %d lines, want %d", test.name, lines, test.lines)
+		}
+	}
+}
+
+// withID routes the request as if the path held the id.
+func withID(r *http.Request, id string) *http.Request {
+	routeContext := chi.NewRouteContext()
+	routeContext.URLParams.Add("id", id)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, routeContext))
+}
+
+// ownedOrderMapper holds a single order and records what is done to it.
+type ownedOrderMapper struct {
+	mappers.OrderMapperInterface
+	order     *models.Order
+	deleted   *[]int
+	cancelled *[]int
+}
+
+func (m ownedOrderMapper) WithActor(actor string) mappers.OrderMapperInterface {
+	return m
+}
+
+func (m ownedOrderMapper) WithVersion(version int) mappers.OrderMapperInterface {
+	return m
+}
+
+func (m ownedOrderMapper) FindByID(id int) (*models.Order, error) {
+	if id != m.order.ID {
+		return nil, mappers.NotFoundError("order not found")
+	}
+	return m.order, nil
+}
+
+func (m ownedOrderMapper) Cancel(id int) (*models.Order, error) {
+	*m.cancelled = append(*m.cancelled, id)
+	order := *m.order
+	order.Status = models.OrderStatusCancelled
+	return &order, nil
+}
+
+func (m ownedOrderMapper) Delete(id int) error {
+	*m.deleted = append(*m.deleted, id)
+	return nil
+}
+
+type noPaymentMapper struct {
+	mappers.PaymentMapperInterface
+}
+
+func (m noPaymentMapper) FindByOrderID(orderID int) ([]*models.Payment, error) {
+	return nil, nil
+}
+
+const orderOwner = 2
+
+var ownershipTests = []struct {
+	name string
+	user *models.User
+	id   string
+	code int
+}{
+	{"anonymous", nil, "5", http.StatusUnauthorized},
+	{"other customer", &models.User{ID: 3, Username: "other"}, "5", http.StatusNotFound},
+	{"owner", &models.User{ID: orderOwner, Username: "owner"}, "5", http.StatusOK},
+	{"admin", &models.User{ID: 1, Username: testAdmin}, "5", http.StatusOK},
+	{"unknown order", &models.User{ID: 1, Username: testAdmin}, "6", http.StatusNotFound},
+}
+
+// testOwnership sends the request with the body to the handler of order 5 of orderOwner as every user
+// of ownershipTests, code is the status of allowed requests and done tells whether the handler has reached the order.
+func testOwnership(t *testing.T, method, body string, code int, handler func(ownedOrderMapper) http.HandlerFunc,
+	done func(ownedOrderMapper, *httptest.ResponseRecorder) bool) {
+	for _, test := range ownershipTests {
+		owner := orderOwner
+		mapper := ownedOrderMapper{order: &models.Order{ID: 5, UserID: &owner, Status: models.OrderStatusPlaced},
+			deleted: &[]int{}, cancelled: &[]int{}}
+		r := httptest.NewRequest(method, "/store/order/"+test.id, strings.NewReader(body))
+		if test.user != nil {
+			r = authenticated(t, r, test.user)
+		}
+		want := test.code
+		if want == http.StatusOK {
+			want = code
+		}
+		w := httptest.NewRecorder()
+		handler(mapper)(w, withID(r, test.id))
+		if got := w.Result().StatusCode; got != want {
+			t.Errorf("%v: got status %d, want %d", test.name, got, want)
+		}
+		if reached := done(mapper, w); reached != (test.code == http.StatusOK) {
+			t.Errorf("%v: got order reached %v", test.name, reached)
+		}
+	}
+}
+
+func TestDeleteOrderOwnership(t *testing.T) {
+	testOwnership(t, http.MethodDelete, "", http.StatusOK, func(mapper ownedOrderMapper) http.HandlerFunc {
+		return Store{OrderMapper: mapper, PaymentMapper: noPaymentMapper{}}.Delete
+	}, func(mapper ownedOrderMapper, w *httptest.ResponseRecorder) bool {
+		return len(*mapper.deleted) == 1
+	})
+}
+
+func TestCancelOrderOwnership(t *testing.T) {
+	testOwnership(t, http.MethodPost, "", http.StatusOK, func(mapper ownedOrderMapper) http.HandlerFunc {
+		return Store{OrderMapper: mapper, PaymentMapper: noPaymentMapper{}}.Cancel
+	}, func(mapper ownedOrderMapper, w *httptest.ResponseRecorder) bool {
+		return len(*mapper.cancelled) == 1
+	})
+}
+
+func TestCreateOrderValidation(t *testing.T) {
+	bodies := []string{
+		`{}`,
+		`{"items": [{"petId": 1, "quantity": 0}]}`,
+		`{"petId": 1, "quantity": 1, "shipDate": "2000-01-01T00:00:00Z"}`,
+	}
+	for _, body := range bodies {
+		w := httptest.NewRecorder()
+		Store{}.CreateOrder(w, httptest.NewRequest(http.MethodPost, "/store/order", strings.NewReader(body)))
+		if code := w.Result().StatusCode; code != http.StatusBadRequest {
+			t.Errorf("order %v: got status %d, want %d", body, code, http.StatusBadRequest)
+		}
+	}
+}
+
+// orderEvents returns a placed event of any order.
+type orderEvents struct{}
+
+func (orderEvents) FindByOrderID(orderID int) ([]*models.OrderEvent, error) {
+	return []*models.OrderEvent{{OrderID: orderID, Event: models.OrderEventPlaced}}, nil
+}
+
+func TestGetOrderOwnership(t *testing.T) {
+	testOwnership(t, http.MethodGet, "", http.StatusOK, func(mapper ownedOrderMapper) http.HandlerFunc {
+		return Store{OrderMapper: mapper}.GetByID
+	}, func(mapper ownedOrderMapper, w *httptest.ResponseRecorder) bool {
+		return w.Header().Get("ETag") != ""
+	})
+}
+
+func TestGetTimelineOwnership(t *testing.T) {
+	testOwnership(t, http.MethodGet, "", http.StatusOK, func(mapper ownedOrderMapper) http.HandlerFunc {
+		return Store{OrderMapper: mapper, OrderEventMapper: orderEvents{}}.GetTimeline
+	}, func(mapper ownedOrderMapper, w *httptest.ResponseRecorder) bool {
+		return strings.Contains(w.Body.String(), models.OrderEventPlaced)
+	})
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+// Trash lists and restores deleted pets and orders, it is meant for admins only.
+type Trash struct {
+	PetMapper   mappers.PetMapperInterface
+	OrderMapper mappers.OrderMapperInterface
+}
+
+type trashList struct {
+	Pets   []*models.Pet   `json:"pets"`
+	Orders []*models.Order `json:"orders"`
+}
+
+func (t Trash) List(w http.ResponseWriter, r *http.Request) {
+	pets, err := t.PetMapper.FindDeleted()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	orders, err := t.OrderMapper.FindDeleted()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	output, err := json.Marshal(trashList{Pets: pets, Orders: orders})
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+func (t Trash) RestorePet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		logrus.Error(err)
+		preconditionFailed(w)
+		return
+	}
+
+	err = t.PetMapper.WithActor(actor(r)).WithVersion(version).Restore(id)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.VersionMismatchError:
+			preconditionFailed(w)
+			return
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Deleted pet not found", http.StatusNotFound)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	JSONApiResponse(w, "Pet restored", http.StatusOK)
+}
+
+func (t Trash) RestoreOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		logrus.Error(err)
+		preconditionFailed(w)
+		return
+	}
+
+	err = t.OrderMapper.WithVersion(version).Restore(id)
+	if err != nil {
+		logrus.Error(err)
+		switch err.(type) {
+		case mappers.VersionMismatchError:
+			preconditionFailed(w)
+			return
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "Deleted order not found", http.StatusNotFound)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	JSONApiResponse(w, "Order restored", http.StatusOK)
+}
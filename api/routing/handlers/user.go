@@ -18,6 +18,7 @@ import (
 
 type User struct {
 	UserMapper mappers.UserMapperInterface
+	CartMapper mappers.CartMapperInterface
 }
 
 func (u User) Create(w http.ResponseWriter, r *http.Request) {
@@ -169,7 +170,24 @@ func (u User) Login(w http.ResponseWriter, r *http.Request) {
 	err = auth.GetAuthService().Authenticate(w, r, user)
 	if err != nil {
 		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u.mergeCart(w, r, user)
+}
+
+// mergeCart moves the cart of the anonymous session into the cart of the user who has just logged in,
+// a failed merge keeps the anonymous cart and does not fail the login.
+func (u User) mergeCart(w http.ResponseWriter, r *http.Request, user *models.User) {
+	token := CartToken(r)
+	if token == "" || u.CartMapper == nil {
+		return
+	}
+	err := u.CartMapper.Merge(token, user.ID)
+	if err != nil {
+		logrus.Error("cart merge failed: ", err)
+		return
 	}
+	http.SetCookie(w, &http.Cookie{Name: cartCookie, Path: "/", MaxAge: -1})
 }
 
 func (User) Logout(w http.ResponseWriter, r *http.Request) {
@@ -193,6 +211,7 @@ func (u User) GetByUsername(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	setETag(w, user.Version)
 	output, err := json.Marshal(user)
 	if err != nil {
 		logrus.Error(err)
@@ -205,6 +224,12 @@ func (u User) GetByUsername(w http.ResponseWriter, r *http.Request) {
 
 func (u User) Update(w http.ResponseWriter, r *http.Request) {
 	username := chi.URLParam(r, "username")
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		logrus.Error(err)
+		preconditionFailed(w)
+		return
+	}
 	data, err := ioutil.ReadAll(r.Body)
 	defer r.Body.Close()
 	if err != nil {
@@ -275,22 +300,41 @@ func (u User) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	user.Password = hash
-	err = u.UserMapper.UpdateByUsername(user, username)
+	err = u.UserMapper.WithVersion(version).UpdateByUsername(user, username)
 	if err != nil {
 		logrus.Error(err)
-		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
-		return
+		switch err.(type) {
+		case mappers.VersionMismatchError:
+			preconditionFailed(w)
+			return
+		case mappers.NotFoundError:
+			JSONApiResponse(w, "User not found", http.StatusNotFound)
+			return
+		default:
+			JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
+	setETag(w, user.Version)
 
 }
 
 func (u User) Delete(w http.ResponseWriter, r *http.Request) {
 	username := chi.URLParam(r, "username")
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		logrus.Error(err)
+		preconditionFailed(w)
+		return
+	}
 
-	err := u.UserMapper.DeleteByUsername(username)
+	err = u.UserMapper.WithVersion(version).DeleteByUsername(username)
 	if err != nil {
 		logrus.Error(err)
 		switch err.(type) {
+		case mappers.VersionMismatchError:
+			preconditionFailed(w)
+			return
 		case mappers.NotFoundError:
 			JSONApiResponse(w, "User not found", http.StatusNotFound)
 			return
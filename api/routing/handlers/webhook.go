@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+// Webhook manages subscriptions of partner systems to store events and their deliveries, it is meant for admins only.
+type Webhook struct {
+	WebhookMapper mappers.WebhookMapperInterface
+}
+
+func (h Webhook) List(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := h.WebhookMapper.FindAll()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, subscription := range subscriptions {
+		subscription.Secret = ""
+	}
+	output, err := json.Marshal(subscriptions)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+// Create subscribes the URL, the body is {"url": "...", "eventTypes": ["pet.created"], "description": "..."},
+// without event types every event is sent. The response has the secret signing the deliveries, it is not shown again.
+func (h Webhook) Create(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	subscription := &models.WebhookSubscription{Active: true}
+	err = json.Unmarshal(data, subscription)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	err = subscription.Validate()
+	if err != nil {
+		JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	subscription.Secret = newWebhookSecret()
+
+	err = h.WebhookMapper.Create(subscription)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.respond(w, subscription, http.StatusCreated)
+}
+
+func (h Webhook) GetByID(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+	subscription.Secret = ""
+	h.respond(w, subscription, http.StatusOK)
+}
+
+// Update changes the subscription with the fields of the body, the others are kept.
+// {"active": false} pauses the deliveries, they are sent once the subscription is active again.
+func (h Webhook) Update(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := subscription.ID
+	err = json.Unmarshal(data, subscription)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	subscription.ID = id
+	err = subscription.Validate()
+	if err != nil {
+		JSONApiResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.WebhookMapper.Update(subscription)
+	if err != nil {
+		logrus.Error(err)
+		h.error(w, err)
+		return
+	}
+	subscription.Secret = ""
+	h.respond(w, subscription, http.StatusOK)
+}
+
+func (h Webhook) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return
+	}
+	err = h.WebhookMapper.Delete(id)
+	if err != nil {
+		logrus.Error(err)
+		h.error(w, err)
+		return
+	}
+	JSONApiResponse(w, "Webhook deleted", http.StatusOK)
+}
+
+// ListDeliveries returns the latest deliveries of the subscription with the status query parameter,
+// dead deliveries are listed with ?status=dead.
+func (h Webhook) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryDead:
+	default:
+		JSONApiResponse(w, "Invalid status value", http.StatusBadRequest)
+		return
+	}
+	deliveries, err := h.WebhookMapper.FindDeliveries(subscription.ID, status)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	output, err := json.Marshal(deliveries)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusOK)
+}
+
+// Redeliver queues the delivery to be sent again by the delivery worker with a fresh count of attempts.
+func (h Webhook) Redeliver(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return
+	}
+	delivery, err := h.WebhookMapper.Redeliver(id)
+	if err != nil {
+		logrus.Error(err)
+		h.error(w, err)
+		return
+	}
+	output, err := json.Marshal(delivery)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, http.StatusAccepted)
+}
+
+func (h Webhook) find(w http.ResponseWriter, r *http.Request) (*models.WebhookSubscription, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		JSONApiResponse(w, "Invalid ID supplied", http.StatusBadRequest)
+		return nil, false
+	}
+	subscription, err := h.WebhookMapper.FindByID(id)
+	if err != nil {
+		logrus.Error(err)
+		h.error(w, err)
+		return nil, false
+	}
+	return subscription, true
+}
+
+func (h Webhook) error(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case mappers.NotFoundError:
+		JSONApiResponse(w, err.Error(), http.StatusNotFound)
+	default:
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h Webhook) respond(w http.ResponseWriter, subscription *models.WebhookSubscription, code int) {
+	output, err := json.Marshal(subscription)
+	if err != nil {
+		logrus.Error(err)
+		JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, output, code)
+}
+
+func newWebhookSecret() string {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		logrus.Fatal("random source failed: ", err)
+	}
+	return hex.EncodeToString(buf)
+}
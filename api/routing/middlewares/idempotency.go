@@ -0,0 +1,204 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/api/routing/handlers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/auth"
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	idempotencyKeyMaxLength   = 255
+	defaultIdempotencyKeysTTL = 24 * time.Hour
+	// defaultIdempotencyMaxBodySize matches the memory kept for multipart forms
+	defaultIdempotencyMaxBodySize = 32 << 20
+)
+
+// unrecordedHeaders carry credentials of the client, they are neither recorded nor replayed
+var unrecordedHeaders = map[string]bool{
+	"Set-Cookie":        true,
+	handlers.CartHeader: true,
+}
+
+type IdempotencyConfig struct {
+	// TTL is how long responses are kept for replays of their key
+	TTL utils.Duration
+	// MaxBodySize limits the bodies of requests with a key in bytes, they are kept in memory to be hashed
+	MaxBodySize int64
+}
+
+// Idempotency makes POST, PUT, PATCH and DELETE requests carrying an Idempotency-Key header safe to retry.
+// The first request with a key is handled and its response recorded, retries with the same key get
+// the recorded response back and reusing the key for another request is 422 Unprocessable Entity.
+// Keys are scoped to the authenticated user or to the cart token of an anonymous client, requests of clients
+// without either are handled without a key. Server errors are not recorded, so such requests can be retried.
+func Idempotency(mapper mappers.IdempotencyMapperInterface, config IdempotencyConfig) func(http.Handler) http.Handler {
+	ttl := config.TTL.Duration
+	if ttl <= 0 {
+		ttl = defaultIdempotencyKeysTTL
+	}
+	maxBodySize := config.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultIdempotencyMaxBodySize
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || !mutating(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > idempotencyKeyMaxLength {
+				handlers.JSONApiResponse(w, "Idempotency-Key must be at most 255 characters", http.StatusBadRequest)
+				return
+			}
+			scope := idempotencyScope(r)
+			if scope == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+			r.Body.Close()
+			if err != nil {
+				logrus.Error(err)
+				handlers.JSONApiResponse(w, fmt.Sprintf("Request body must be at most %d bytes", maxBodySize),
+					http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			hash := requestHash(r, body)
+			record, err := mapper.Begin(key, scope, hash, ttl)
+			if err != nil {
+				logrus.Error(err)
+				if _, ok := err.(mappers.ConflictError); ok {
+					handlers.JSONApiResponse(w, err.Error(), http.StatusConflict)
+					return
+				}
+				handlers.JSONApiResponse(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if record != nil {
+				replay(w, record, hash)
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, code: http.StatusOK}
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				// the handler has panicked or failed, the key is given back for a retry
+				err := mapper.Release(key, scope)
+				if err != nil {
+					logrus.Error(err)
+				}
+			}()
+			next.ServeHTTP(recorder, r)
+			if recorder.code >= http.StatusInternalServerError {
+				return
+			}
+			headers := models.ResponseHeaders{}
+			for name, values := range recorder.Header() {
+				if !unrecordedHeaders[name] {
+					headers[name] = append([]string(nil), values...)
+				}
+			}
+			err = mapper.Complete(key, scope, recorder.code, headers, recorder.body.Bytes())
+			if err != nil {
+				logrus.Error(err)
+				return
+			}
+			completed = true
+		})
+	}
+}
+
+func replay(w http.ResponseWriter, record *models.IdempotencyRecord, hash string) {
+	if record.RequestHash != hash {
+		handlers.JSONApiResponse(w, "Idempotency-Key has been used for another request", http.StatusUnprocessableEntity)
+		return
+	}
+	if record.ResponseCode == nil {
+		handlers.JSONApiResponse(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+		return
+	}
+	for name, values := range record.ResponseHeaders {
+		if !unrecordedHeaders[name] {
+			w.Header()[name] = values
+		}
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(*record.ResponseCode)
+	_, err := w.Write(record.ResponseBody)
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// idempotencyScope returns the username of the authenticated client or the hashed cart token
+// of an anonymous one, an empty string for clients which cannot be told apart.
+func idempotencyScope(r *http.Request) string {
+	if user := auth.GetAuthService().GetUser(r); user != nil {
+		return user.Username
+	}
+	if token := handlers.CartToken(r); token != "" {
+		sum := sha256.Sum256([]byte(token))
+		return "cart:" + hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// requestHash identifies the request by its method, URI and body.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	_, _ = h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	_, _ = h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes the response on and keeps a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.code = code
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
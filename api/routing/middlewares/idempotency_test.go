@@ -0,0 +1,147 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/api/routing/handlers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/auth"
+)
+
+func TestMain(m *testing.M) {
+	auth.Init(auth.Config{Type: "jwt"})
+	os.Exit(m.Run())
+}
+
+// memoryIdempotencyMapper keeps the records in memory.
+type memoryIdempotencyMapper struct {
+	records map[string]*models.IdempotencyRecord
+}
+
+func (m memoryIdempotencyMapper) Begin(key, scope, requestHash string, ttl time.Duration) (*models.IdempotencyRecord, error) {
+	if record, ok := m.records[scope+"\n"+key]; ok {
+		return record, nil
+	}
+	m.records[scope+"\n"+key] = &models.IdempotencyRecord{Key: key, Scope: scope, RequestHash: requestHash}
+	return nil, nil
+}
+
+func (m memoryIdempotencyMapper) Complete(key, scope string, code int, headers models.ResponseHeaders, body []byte) error {
+	record := m.records[scope+"\n"+key]
+	record.ResponseCode, record.ResponseHeaders, record.ResponseBody = &code, headers, body
+	return nil
+}
+
+func (m memoryIdempotencyMapper) Release(key, scope string) error {
+	delete(m.records, scope+"\n"+key)
+	return nil
+}
+
+func (m memoryIdempotencyMapper) Purge(expiredBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+// countingHandler issues a cart token like the cart handlers do and counts the requests it has handled.
+func countingHandler(handled *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*handled++
+		http.SetCookie(w, &http.Cookie{Name: "cart", Value: "issued-token"})
+		w.Header().Set(handlers.CartHeader, "issued-token")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 1}`))
+	})
+}
+
+func idempotentRequest(body string, cartToken string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/store/order", strings.NewReader(body))
+	r.Header.Set(IdempotencyKeyHeader, "key-1")
+	if cartToken != "" {
+		r.Header.Set(handlers.CartHeader, cartToken)
+	}
+	return r
+}
+
+func TestIdempotencyReplaysWithoutCredentials(t *testing.T) {
+	handled := 0
+	mapper := memoryIdempotencyMapper{records: map[string]*models.IdempotencyRecord{}}
+	handler := Idempotency(mapper, IdempotencyConfig{})(countingHandler(&handled))
+
+	handler.ServeHTTP(httptest.NewRecorder(), idempotentRequest(`{"petId": 1}`, "token-a"))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, idempotentRequest(`{"petId": 1}`, "token-a"))
+	if handled != 1 {
+		t.Fatalf("retry has been handled again, %d requests handled", handled)
+	}
+	response := w.Result()
+	if response.StatusCode != http.StatusCreated || response.Header.Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("got status %d, replayed %q", response.StatusCode, response.Header.Get(IdempotentReplayedHeader))
+	}
+	if response.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("got content type %q", response.Header.Get("Content-Type"))
+	}
+	if cookie := response.Header.Get("Set-Cookie"); cookie != "" {
+		t.Errorf("cookie %q has been replayed", cookie)
+	}
+	if token := response.Header.Get(handlers.CartHeader); token != "" {
+		t.Errorf("cart token %q has been replayed", token)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, idempotentRequest(`{"petId": 2}`, "token-a"))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another request: got status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestIdempotencyScopesAnonymousClients(t *testing.T) {
+	handled := 0
+	mapper := memoryIdempotencyMapper{records: map[string]*models.IdempotencyRecord{}}
+	handler := Idempotency(mapper, IdempotencyConfig{})(countingHandler(&handled))
+
+	handler.ServeHTTP(httptest.NewRecorder(), idempotentRequest(`{"petId": 1}`, "token-a"))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, idempotentRequest(`{"petId": 1}`, "token-b"))
+	if handled != 2 || w.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Errorf("response of another cart has been replayed")
+	}
+
+	// clients without a cart token cannot be told apart, their requests are always handled
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, idempotentRequest(`{"petId": 1}`, ""))
+		if w.Header().Get(IdempotentReplayedHeader) != "" || w.Header().Get("Set-Cookie") == "" {
+			t.Errorf("request without a cart token has got a replayed response")
+		}
+	}
+	if handled != 4 {
+		t.Errorf("got %d requests handled, want 4", handled)
+	}
+	for _, record := range mapper.records {
+		if record.Scope == "" || strings.Contains(record.Scope, "token-") {
+			t.Errorf("got scope %q, want the hashed cart token", record.Scope)
+		}
+	}
+}
+
+func TestIdempotencyLimitsBody(t *testing.T) {
+	handled := 0
+	mapper := memoryIdempotencyMapper{records: map[string]*models.IdempotencyRecord{}}
+	handler := Idempotency(mapper, IdempotencyConfig{MaxBodySize: 16})(countingHandler(&handled))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, idempotentRequest(strings.Repeat("x", 17), "token-a"))
+	if w.Code != http.StatusRequestEntityTooLarge || handled != 0 {
+		t.Errorf("got status %d and %d requests handled, want %d", w.Code, handled, http.StatusRequestEntityTooLarge)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, idempotentRequest(strings.Repeat("x", 16), "token-a"))
+	if w.Code != http.StatusCreated {
+		t.Errorf("body within the limit: got status %d", w.Code)
+	}
+}
@@ -6,8 +6,56 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/cors"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/api/routing/handlers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/auth"
+)
+
+const (
+	IfMatchOptional = "none"
+	IfMatchAdmins   = "admins"
+	IfMatchAll      = "all"
 )
 
+type ConcurrencyConfig struct {
+	// RequireIfMatch is one of "none", "admins" or "all"
+	RequireIfMatch string
+}
+
+// RequireIfMatch rejects requests without If-Match header with 428 Precondition Required
+// for the clients the config requires conditional requests from.
+func RequireIfMatch(config ConcurrencyConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-Match") == "" {
+				required := config.RequireIfMatch == IfMatchAll ||
+					config.RequireIfMatch == IfMatchAdmins && auth.IsAdmin(auth.GetAuthService().GetUser(r))
+				if required {
+					handlers.JSONApiResponse(w, "If-Match header is required", http.StatusPreconditionRequired)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AdminOnly lets through only the users listed as admins in the auth config.
+func AdminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetAuthService().GetUser(r)
+		if user == nil {
+			handlers.JSONApiResponse(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !auth.IsAdmin(user) {
+			handlers.JSONApiResponse(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -18,8 +66,8 @@ func SetMiddlewares(r *chi.Mux) {
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match", IdempotencyKeyHeader},
+		ExposedHeaders:   []string{"Link", "ETag", IdempotentReplayedHeader},
 		AllowCredentials: true,
 		MaxAge:           300,
 	})
@@ -11,35 +11,138 @@ import (
 	"github.com/jmoiron/sqlx"
 
 	"gitlab.com/i4s-edu/petstore-kovalyk/api/routing/middlewares"
+	"gitlab.com/i4s-edu/petstore-kovalyk/configuration"
 )
 
-func NewRouter(db *sqlx.DB) http.Handler {
+func NewRouter(db *sqlx.DB, config configuration.Config) http.Handler {
 	r := chi.NewRouter()
 	middlewares.SetMiddlewares(r)
+	r.Use(middlewares.Idempotency(mappers.IdempotencyMapper{DB: db}, config.Idempotency))
+	ifMatch := middlewares.RequireIfMatch(config.Concurrency)
 
 	pet := handlers.Pet{
-		PetMapper: mappers.PetMapper{DB: db}}
+		PetMapper:        mappers.PetMapper{DB: db},
+		PetHistoryMapper: mappers.PetHistoryMapper{DB: db},
+		PublicURL:        config.Server.PublicURL}
 	store := handlers.Store{
+		PetMapper:        mappers.PetMapper{DB: db},
+		OrderMapper:      mappers.OrderMapper{DB: db},
+		InventoryMapper:  mappers.NewCachedInventoryMapper(mappers.InventoryMapper{DB: db}, config.Inventory),
+		PaymentMapper:    mappers.PaymentMapper{DB: db},
+		OrderEventMapper: mappers.OrderEventMapper{DB: db}}
+	favorite := handlers.Favorite{
+		FavoriteMapper: mappers.FavoriteMapper{DB: db},
+		PublicURL:      config.Server.PublicURL}
+	user := handlers.User{
+		UserMapper: mappers.UserMapper{DB: db},
+		CartMapper: mappers.CartMapper{DB: db}}
+	cart := handlers.Cart{
+		CartMapper: mappers.CartMapper{DB: db}}
+	coupon := handlers.Coupon{
+		CouponMapper: mappers.CouponMapper{DB: db}}
+	payment := handlers.Payment{
+		PaymentMapper: mappers.PaymentMapper{DB: db},
+		OrderMapper:   mappers.OrderMapper{DB: db}}
+	returns := handlers.Return{
+		ReturnMapper:  mappers.ReturnMapper{DB: db},
+		OrderMapper:   mappers.OrderMapper{DB: db},
+		PaymentMapper: mappers.PaymentMapper{DB: db}}
+	invoice := handlers.Invoice{
+		InvoiceMapper: mappers.InvoiceMapper{DB: db}}
+	webhook := handlers.Webhook{
+		WebhookMapper: mappers.WebhookMapper{DB: db}}
+	trash := handlers.Trash{
 		PetMapper:   mappers.PetMapper{DB: db},
 		OrderMapper: mappers.OrderMapper{DB: db}}
-	user := handlers.User{
-		UserMapper: mappers.UserMapper{DB: db}}
+	reservation := handlers.NewReservation(mappers.ReservationMapper{DB: db}, config.Reservations)
 
 	r.Route("/pet", func(r chi.Router) {
+		r.Get("/", pet.List)
 		r.Post("/", pet.Create)
-		r.Put("/", pet.Update)
+		r.Post("/import", pet.Import)
+		r.Get("/export", pet.Export)
+		r.With(ifMatch).Put("/", pet.Update)
 		r.Get("/{id}", pet.GetByID)
+		r.With(middlewares.AdminOnly).Get("/{id}/history", pet.GetHistory)
 		r.Get("/findByStatus", pet.FindByStatus)
 		r.Get("/findByTags", pet.FindByTags)
-		r.Post("/{id}", pet.UpdateByID)
-		r.Delete("/{id}", pet.Delete)
-		r.Post("/{id}/uploadImage", pet.UploadImage)
+		r.With(ifMatch).Post("/{id}", pet.UpdateByID)
+		r.With(ifMatch).Delete("/{id}", pet.Delete)
+		r.With(ifMatch).Post("/{id}/uploadImage", pet.UploadImage)
+		r.With(ifMatch).Put("/{id}/images", pet.ReorderImages)
+		r.Get("/{id}/images/{key}", pet.GetImage)
+		r.With(ifMatch).Delete("/{id}/images/{key}", pet.DeleteImage)
 	})
 	r.Route("/store", func(r chi.Router) {
 		r.Get("/inventory", store.GetInventory)
+		r.Get("/order", store.ListOrders)
+		r.Get("/order/export", store.ExportOrders)
 		r.Post("/order", store.CreateOrder)
 		r.Get("/order/{id}", store.GetByID)
-		r.Delete("/order/{id}", store.Delete)
+		r.With(ifMatch).Delete("/order/{id}", store.Delete)
+		r.With(middlewares.AdminOnly, ifMatch).Post("/order/{id}/approve", store.Approve)
+		r.With(middlewares.AdminOnly, ifMatch).Post("/order/{id}/deliver", store.Deliver)
+		r.With(ifMatch).Post("/order/{id}/cancel", store.Cancel)
+		r.Post("/order/{id}/pay", payment.Pay)
+		r.Get("/order/{id}/payments", payment.ListByOrder)
+		r.Get("/order/{id}/timeline", store.GetTimeline)
+		r.Post("/order/{id}/returns", returns.Create)
+		r.Get("/order/{id}/returns", returns.ListByOrder)
+		r.With(middlewares.AdminOnly).Get("/invoices", invoice.List)
+		r.With(middlewares.AdminOnly).Get("/invoices/{id}", invoice.GetByID)
+		r.With(middlewares.AdminOnly).Get("/invoices/{id}/body", invoice.Download)
+	})
+	r.Route("/returns", func(r chi.Router) {
+		r.Get("/", returns.List)
+		r.Get("/{id}", returns.GetByID)
+		r.With(middlewares.AdminOnly).Post("/{id}/approve", returns.Approve)
+		r.With(middlewares.AdminOnly).Post("/{id}/reject", returns.Reject)
+		r.With(middlewares.AdminOnly).Post("/{id}/refund", returns.Refund)
+	})
+	r.Route("/payments", func(r chi.Router) {
+		r.Post("/webhook", payment.Webhook)
+		r.With(middlewares.AdminOnly).Post("/{id}/refund", payment.Refund)
+	})
+	r.Route("/trash", func(r chi.Router) {
+		r.Use(middlewares.AdminOnly)
+		r.Get("/", trash.List)
+		r.With(ifMatch).Post("/pet/{id}/restore", trash.RestorePet)
+		r.With(ifMatch).Post("/order/{id}/restore", trash.RestoreOrder)
+	})
+	r.Route("/coupons", func(r chi.Router) {
+		r.Use(middlewares.AdminOnly)
+		r.Get("/", coupon.List)
+		r.Post("/", coupon.Create)
+		r.Get("/{code}", coupon.GetByCode)
+		r.Delete("/{code}", coupon.Deactivate)
+	})
+	r.Route("/webhooks", func(r chi.Router) {
+		r.Use(middlewares.AdminOnly)
+		r.Get("/", webhook.List)
+		r.Post("/", webhook.Create)
+		r.Get("/{id}", webhook.GetByID)
+		r.Put("/{id}", webhook.Update)
+		r.Delete("/{id}", webhook.Delete)
+		r.Get("/{id}/deliveries", webhook.ListDeliveries)
+		r.Post("/deliveries/{id}/redeliver", webhook.Redeliver)
+	})
+	r.Route("/favorites", func(r chi.Router) {
+		r.Get("/", favorite.List)
+		r.Put("/{petId}", favorite.Add)
+		r.Delete("/{petId}", favorite.Remove)
+	})
+	r.Route("/cart", func(r chi.Router) {
+		r.Get("/", cart.Get)
+		r.Delete("/", cart.Clear)
+		r.Put("/items/{petId}", cart.SetItem)
+		r.Delete("/items/{petId}", cart.RemoveItem)
+		r.Post("/checkout", cart.Checkout)
+	})
+	r.Route("/reservation", func(r chi.Router) {
+		r.Post("/", reservation.Create)
+		r.Get("/", reservation.List)
+		r.Get("/{id}", reservation.GetByID)
+		r.Delete("/{id}", reservation.Cancel)
 	})
 	r.Route("/user", func(r chi.Router) {
 		r.Post("/", user.Create)
@@ -48,8 +151,8 @@ func NewRouter(db *sqlx.DB) http.Handler {
 		r.Get("/login", user.Login)
 		r.Get("/logout", user.Logout)
 		r.Get("/{username}", user.GetByUsername)
-		r.Put("/{username}", user.Update)
-		r.Delete("/{username}", user.Delete)
+		r.With(ifMatch).Put("/{username}", user.Update)
+		r.With(ifMatch).Delete("/{username}", user.Delete)
 
 	})
 
@@ -13,8 +13,12 @@ import (
 
 	"gitlab.com/i4s-edu/petstore-kovalyk/db/migrations"
 	"gitlab.com/i4s-edu/petstore-kovalyk/services/auth"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/notification"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/payment"
 
 	db2 "gitlab.com/i4s-edu/petstore-kovalyk/db"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
@@ -44,10 +48,13 @@ func NewApp() *App {
 	}
 	storage.Init(config.Storage)
 	auth.Init(config.Auth)
+	notification.Init(config.Notification)
+	payment.Init(config.Payment)
+	registerPetStatusHooks()
 
 	srv := http.Server{
 		Addr:         fmt.Sprintf("%v:%v", config.Server.Host, config.Server.Port),
-		Handler:      routing.NewRouter(db),
+		Handler:      routing.NewRouter(db, config),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  15 * time.Second,
@@ -56,6 +63,14 @@ func NewApp() *App {
 	return &App{Config: config, Server: &srv, DB: db}
 }
 
+// registerPetStatusHooks wires the actions taken when pets change their status.
+func registerPetStatusHooks() {
+	// pets returned to sale drop the orders which are still open
+	mappers.OnPetStatus("", models.PetStatusAvailable, mappers.CancelOpenOrders)
+	// pets leaving pending outside of their reservation release it
+	mappers.OnPetStatus(models.PetStatusPending, "", mappers.ReleaseReservations(notification.GetNotifier().Notify))
+}
+
 func (a *App) Run() {
 	go func() {
 		err := a.Server.ListenAndServe()
@@ -63,7 +78,13 @@ func (a *App) Run() {
 			logrus.Fatalf("Cannot run app: %v", err)
 		}
 	}()
-	workers.DispatchInvoiceWorker(a.Config.Workers.Invoice.Interval.Duration, a.DB)
+	workers.DispatchInvoiceWorker(a.Config.Workers.Invoice, a.DB)
+	workers.DispatchImageGCWorker(a.Config.Workers.ImageGC, a.DB)
+	workers.DispatchReservationExpiryWorker(a.Config.Workers.Reservations, a.DB)
+	workers.DispatchTrashPurgeWorker(a.Config.Workers.Trash, a.DB)
+	workers.DispatchIdempotencyPurgeWorker(a.Config.Workers.Idempotency, a.DB)
+	workers.DispatchWebhookDeliveryWorker(a.Config.Workers.Webhooks, a.DB)
+	workers.DispatchRefundReconcileWorker(a.Config.Workers.Refunds, a.DB)
 
 	a.gracefulShutdown()
 }
@@ -4,7 +4,11 @@ import (
 	"io/ioutil"
 	"sync"
 
+	"gitlab.com/i4s-edu/petstore-kovalyk/api/routing/handlers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/api/routing/middlewares"
 	"gitlab.com/i4s-edu/petstore-kovalyk/services/auth"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/notification"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/payment"
 
 	"gitlab.com/i4s-edu/petstore-kovalyk/services/storage"
 	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
@@ -12,6 +16,7 @@ import (
 	"gitlab.com/i4s-edu/petstore-kovalyk/workers"
 
 	"gitlab.com/i4s-edu/petstore-kovalyk/db"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
 
 	"github.com/sirupsen/logrus"
 
@@ -24,14 +29,23 @@ type Server struct {
 	Host            string
 	Port            string
 	ShutdownTimeout utils.Duration
+	// PublicURL is the address clients reach the API with, links served by the API start with it,
+	// they are relative to the host when it is empty
+	PublicURL string
 }
 
 type Config struct {
-	Server  Server
-	DB      db.Config
-	Workers workers.Config
-	Storage storage.Config
-	Auth    auth.Config
+	Server       Server
+	DB           db.Config
+	Workers      workers.Config
+	Storage      storage.Config
+	Auth         auth.Config
+	Notification notification.Config
+	Payment      payment.Config
+	Concurrency  middlewares.ConcurrencyConfig
+	Idempotency  middlewares.IdempotencyConfig
+	Reservations handlers.ReservationConfig
+	Inventory    mappers.InventoryConfig
 }
 
 var config Config
@@ -0,0 +1,283 @@
+package mappers
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+// CartOwner identifies a cart either by the authenticated user or by the token of an anonymous session.
+type CartOwner struct {
+	UserID int
+	Token  string
+}
+
+func (o CartOwner) where() (string, interface{}) {
+	if o.UserID != 0 {
+		return "user_id = $1", o.UserID
+	}
+	return "token = $1", o.Token
+}
+
+type CartMapperInterface interface {
+	Find(owner CartOwner) (*models.Cart, error)
+	SetItem(owner CartOwner, petID, quantity int) error
+	RemoveItem(owner CartOwner, petID int) error
+	Clear(owner CartOwner) error
+	Merge(token string, userID int) error
+	Checkout(owner CartOwner, o *models.Order) error
+	WithActor(actor string) CartMapperInterface
+}
+
+type CartMapper struct {
+	DB *sqlx.DB
+	// Actor is recorded in the pet history as the author of orders placed on checkout
+	Actor string
+}
+
+func (m CartMapper) WithActor(actor string) CartMapperInterface {
+	m.Actor = actor
+	return m
+}
+
+// Find returns the cart with the current price and availability of its pets, missing carts are empty.
+func (m CartMapper) Find(owner CartOwner) (*models.Cart, error) {
+	where, arg := owner.where()
+	stmt := `SELECT ci.pet_id, ci.quantity, ci.added_at, p.name, p.price AS unit_price, p.currency, p.status,
+		            (p.status = $2 AND p.deleted_at IS NULL) AS available
+		FROM cart_items ci
+		    INNER JOIN pets p ON ci.pet_id = p.id
+		WHERE ci.cart_id = (SELECT id FROM carts WHERE ` + where + `)
+		ORDER BY ci.added_at, ci.pet_id`
+	items := []*models.CartItem{}
+	err := m.DB.Select(&items, stmt, arg, models.PetStatusAvailable)
+	if err != nil {
+		return nil, errors.Wrap(err, "find cart error")
+	}
+	return models.NewCart(items), nil
+}
+
+// SetItem adds the available pet to the cart or changes its quantity.
+func (m CartMapper) SetItem(owner CartOwner, petID, quantity int) error {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
+
+	var status string
+	err = txn.Get(&status, `SELECT status FROM pets WHERE id = $1 AND deleted_at IS NULL`, petID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return NotFoundError(fmt.Sprintf("Pet record have not found by id: %d", petID))
+		}
+		return errors.Wrap(err, "find pet error")
+	}
+	if status != models.PetStatusAvailable {
+		return ConflictError(fmt.Sprintf("pet %d is %v and cannot be added to the cart", petID, status))
+	}
+	cartID, err := m.cartID(txn, owner, true)
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT INTO cart_items (cart_id, pet_id, quantity) VALUES ($1, $2, $3)
+			 ON CONFLICT (cart_id, pet_id) DO UPDATE SET quantity = EXCLUDED.quantity`
+	_, err = txn.Exec(stmt, cartID, petID, quantity)
+	if err != nil {
+		return errors.Wrap(err, "set cart item error")
+	}
+	err = m.touch(txn, cartID)
+	if err != nil {
+		return err
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	return nil
+}
+
+// RemoveItem removes the pet from the cart.
+func (m CartMapper) RemoveItem(owner CartOwner, petID int) error {
+	where, arg := owner.where()
+	stmt := `DELETE FROM cart_items
+			 WHERE cart_id = (SELECT id FROM carts WHERE ` + where + `) AND pet_id = $2`
+	result, err := m.DB.Exec(stmt, arg, petID)
+	if err != nil {
+		return errors.Wrap(err, "remove cart item error")
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return NotFoundError(fmt.Sprintf("pet %d is not in the cart", petID))
+	}
+	return nil
+}
+
+// Clear removes every item of the cart.
+func (m CartMapper) Clear(owner CartOwner) error {
+	where, arg := owner.where()
+	stmt := `DELETE FROM cart_items WHERE cart_id = (SELECT id FROM carts WHERE ` + where + `)`
+	_, err := m.DB.Exec(stmt, arg)
+	if err != nil {
+		return errors.Wrap(err, "clear cart error")
+	}
+	return nil
+}
+
+// Merge moves items of the anonymous cart into the cart of the user and drops the anonymous cart.
+// Pets in both carts keep the larger quantity.
+func (m CartMapper) Merge(token string, userID int) error {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
+
+	anonymousID, err := m.cartID(txn, CartOwner{Token: token}, false)
+	if _, ok := err.(NotFoundError); ok {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	userCartID, err := m.cartID(txn, CartOwner{UserID: userID}, true)
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT INTO cart_items (cart_id, pet_id, quantity, added_at)
+			 SELECT $1, pet_id, quantity, added_at FROM cart_items WHERE cart_id = $2
+			 ON CONFLICT (cart_id, pet_id) DO UPDATE SET quantity = GREATEST(cart_items.quantity, EXCLUDED.quantity)`
+	_, err = txn.Exec(stmt, userCartID, anonymousID)
+	if err != nil {
+		return errors.Wrap(err, "merge cart items error")
+	}
+	_, err = txn.Exec(`DELETE FROM carts WHERE id = $1`, anonymousID)
+	if err != nil {
+		return errors.Wrap(err, "delete anonymous cart error")
+	}
+	err = m.touch(txn, userCartID)
+	if err != nil {
+		return err
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	return nil
+}
+
+// Checkout places an order of the cart items and empties the cart in one transaction.
+// The order brings the ship date and the customer, an empty cart is a ValidationError
+// and pets which are not available anymore are a ConflictError.
+func (m CartMapper) Checkout(owner CartOwner, o *models.Order) error {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
+
+	cartID, err := m.cartID(txn, owner, false)
+	if _, ok := err.(NotFoundError); ok {
+		return models.ValidationError("cart is empty")
+	}
+	if err != nil {
+		return err
+	}
+	o.Items = models.OrderItems{}
+	stmt := `SELECT pet_id, quantity FROM cart_items WHERE cart_id = $1 ORDER BY added_at, pet_id`
+	err = txn.Select(&o.Items, stmt, cartID)
+	if err != nil {
+		return errors.Wrap(err, "find cart items error")
+	}
+	if len(o.Items) == 0 {
+		return models.ValidationError("cart is empty")
+	}
+	o.Status = models.OrderStatusPlaced
+	err = o.Validate()
+	if err != nil {
+		return err
+	}
+	err = OrderMapper{DB: m.DB, Actor: m.Actor}.create(txn, o)
+	if err != nil {
+		return err
+	}
+	_, err = txn.Exec(`DELETE FROM cart_items WHERE cart_id = $1`, cartID)
+	if err != nil {
+		return errors.Wrap(err, "clear cart error")
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	invalidateInventory()
+	return nil
+}
+
+// cartID locks the cart of the owner, the cart is created when create is set, otherwise a missing one is a NotFoundError.
+func (m CartMapper) cartID(txn *sqlx.Tx, owner CartOwner, create bool) (int, error) {
+	if create {
+		var userID interface{}
+		var token interface{}
+		if owner.UserID != 0 {
+			userID = owner.UserID
+		} else {
+			token = owner.Token
+		}
+		_, err := txn.Exec(`INSERT INTO carts (user_id, token) VALUES ($1, $2) ON CONFLICT DO NOTHING`, userID, token)
+		if err != nil {
+			return 0, errors.Wrap(err, "create cart error")
+		}
+	}
+	where, arg := owner.where()
+	var id int
+	err := txn.Get(&id, `SELECT id FROM carts WHERE `+where+` FOR UPDATE`, arg)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, NotFoundError("cart not found")
+		}
+		return 0, errors.Wrap(err, "find cart error")
+	}
+	return id, nil
+}
+
+func (m CartMapper) touch(txn *sqlx.Tx, cartID int) error {
+	_, err := txn.Exec(`UPDATE carts SET updated_at = now() WHERE id = $1`, cartID)
+	if err != nil {
+		return errors.Wrap(err, "update cart error")
+	}
+	return nil
+}
+
+// PurgeAnonymous removes carts of anonymous sessions not changed since the given time and returns their count,
+// carts of users are kept.
+func (m CartMapper) PurgeAnonymous(updatedBefore time.Time) (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM carts WHERE user_id IS NULL AND updated_at < $1`, updatedBefore)
+	if err != nil {
+		return 0, errors.Wrap(err, "purge anonymous carts error")
+	}
+	return result.RowsAffected()
+}
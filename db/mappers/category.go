@@ -2,6 +2,8 @@ package mappers
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
@@ -12,6 +14,7 @@ import (
 type CategoryMapperInterface interface {
 	FindByID(id int) (*models.Category, error)
 	FindOrCreate(catName string) (*models.Category, error)
+	FindOrCreateMany(names []string) ([]models.Category, error)
 	Create(*models.Category) error
 	Update(*models.Category) error
 	Delete(id int) error
@@ -60,6 +63,40 @@ func (m CategoryMapper) FindOrCreate(catName string) (*models.Category, error) {
 
 }
 
+func (m CategoryMapper) FindOrCreateMany(names []string) (categories []models.Category, err error) {
+	if len(names) < 1 {
+		return
+	}
+	var markStrings []string
+	var inStmtStrings []string
+	var valueArgs []interface{}
+	i := 1
+	for _, name := range names {
+		markStrings = append(markStrings, fmt.Sprintf("($%d)", i))
+		inStmtStrings = append(inStmtStrings, fmt.Sprintf("$%d", i+len(names)))
+		valueArgs = append(valueArgs, name)
+		i++
+	}
+	stmt := fmt.Sprintf(
+		`WITH ins AS (
+	    	  INSERT INTO categories (name) 
+			  VALUES %v
+			  ON CONFLICT (name) DO NOTHING 
+			  RETURNING *
+	    	 )
+	    	 SELECT * FROM ins
+	    	 UNION
+	    	 SELECT * FROM categories WHERE name IN(%v);`,
+		strings.Join(markStrings, ","), strings.Join(inStmtStrings, ","))
+
+	if m.Tx != nil {
+		err = m.Tx.Select(&categories, stmt, append(valueArgs, valueArgs...)...)
+		return
+	}
+	err = m.DB.Select(&categories, stmt, append(valueArgs, valueArgs...)...)
+	return
+}
+
 func (m CategoryMapper) Create(c *models.Category) error {
 	stmt := `INSERT INTO categories ( name ) VALUES ($1) RETURNING id;`
 	var categoryID int
@@ -78,10 +115,16 @@ func (m CategoryMapper) Update(c *models.Category) error {
 	if err != nil {
 		return errors.Wrap(err, "category update have failed")
 	}
+	// the inventory is broken down by category names
+	invalidateInventory()
 	return nil
 }
 
 func (m CategoryMapper) Delete(id int) error {
 	_, err := m.DB.Exec(`DELETE FROM categories where id=$1`, id)
-	return err
+	if err != nil {
+		return err
+	}
+	invalidateInventory()
+	return nil
 }
@@ -0,0 +1,132 @@
+package mappers
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+type CouponMapperInterface interface {
+	FindAll() ([]*models.Coupon, error)
+	FindByCode(code string) (*models.Coupon, error)
+	Create(c *models.Coupon) error
+	Deactivate(code string) error
+}
+
+type CouponMapper struct {
+	DB *sqlx.DB
+}
+
+// couponColumns select a coupon with the count of orders using it, cancelled orders give their use back
+const couponColumns = `c.*,
+		(SELECT count(*) FROM coupon_redemptions r INNER JOIN orders o ON r.order_id = o.id
+		 WHERE r.coupon_id = c.id AND o.status <> $1) AS used`
+
+func (m CouponMapper) FindAll() ([]*models.Coupon, error) {
+	coupons := []*models.Coupon{}
+	err := m.DB.Select(&coupons, `SELECT `+couponColumns+` FROM coupons c ORDER BY c.id`, models.OrderStatusCancelled)
+	if err != nil {
+		return nil, errors.Wrap(err, "find coupons error")
+	}
+	return coupons, nil
+}
+
+func (m CouponMapper) FindByCode(code string) (*models.Coupon, error) {
+	coupon := &models.Coupon{}
+	stmt := `SELECT ` + couponColumns + ` FROM coupons c WHERE c.code = $2`
+	err := m.DB.Get(coupon, stmt, models.OrderStatusCancelled, models.NormalizeCouponCode(code))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError(fmt.Sprintf("coupon %v not found", code))
+		}
+		return nil, errors.Wrap(err, "find coupon error")
+	}
+	return coupon, nil
+}
+
+// Create adds the coupon, a coupon with the same code is a ConflictError.
+func (m CouponMapper) Create(c *models.Coupon) error {
+	stmt := `INSERT INTO coupons (code, kind, value, currency, valid_from, valid_until, usage_limit, per_user_limit, active)
+			 VALUES (:code, :kind, :value, :currency, :valid_from, :valid_until, :usage_limit, :per_user_limit, :active)
+			 ON CONFLICT (code) DO NOTHING
+			 RETURNING id, created_at`
+	rows, err := m.DB.NamedQuery(stmt, c)
+	if err != nil {
+		return errors.Wrap(err, "insert coupon error")
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return errors.Wrap(err, "insert coupon error")
+		}
+		return ConflictError(fmt.Sprintf("coupon %v already exists", c.Code))
+	}
+	err = rows.Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		return errors.Wrap(err, "scan coupon id error")
+	}
+	return nil
+}
+
+// Deactivate stops the coupon from being used, orders which have used it keep their discount.
+func (m CouponMapper) Deactivate(code string) error {
+	result, err := m.DB.Exec(`UPDATE coupons SET active = FALSE WHERE code = $1`, models.NormalizeCouponCode(code))
+	if err != nil {
+		return errors.Wrap(err, "deactivate coupon error")
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return NotFoundError(fmt.Sprintf("coupon %v not found", code))
+	}
+	return nil
+}
+
+// redeem locks the coupon of the order and returns it when the order may use it.
+// Missing coupons are a NotFoundError, coupons which cannot be used are a ConflictError.
+func (m CouponMapper) redeem(txn *sqlx.Tx, o *models.Order) (*models.Coupon, error) {
+	coupon := &models.Coupon{}
+	code := models.NormalizeCouponCode(*o.CouponCode)
+	err := txn.Get(coupon, `SELECT c.*, 0 AS used FROM coupons c WHERE c.code = $1 FOR UPDATE`, code)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError(fmt.Sprintf("coupon %v not found", code))
+		}
+		return nil, errors.Wrap(err, "find coupon error")
+	}
+	err = coupon.CheckApplicable(time.Now(), o.Currency)
+	if err != nil {
+		return nil, ConflictError(err.Error())
+	}
+
+	var usage struct {
+		Total int `db:"total"`
+		User  int `db:"by_user"`
+	}
+	stmt := `SELECT count(*) AS total, count(*) FILTER (WHERE r.user_id = $3) AS by_user
+			 FROM coupon_redemptions r INNER JOIN orders o ON r.order_id = o.id
+			 WHERE r.coupon_id = $1 AND o.status <> $2`
+	err = txn.Get(&usage, stmt, coupon.ID, models.OrderStatusCancelled, o.UserID)
+	if err != nil {
+		return nil, errors.Wrap(err, "count coupon usage error")
+	}
+	if coupon.UsageLimit != nil && usage.Total >= *coupon.UsageLimit {
+		return nil, ConflictError(fmt.Sprintf("coupon %v has been used up", code))
+	}
+	if coupon.PerUserLimit != nil {
+		if o.UserID == nil {
+			return nil, ConflictError(fmt.Sprintf("coupon %v is for logged in customers only", code))
+		}
+		if usage.User >= *coupon.PerUserLimit {
+			return nil, ConflictError(fmt.Sprintf("coupon %v has been used the allowed number of times", code))
+		}
+	}
+	return coupon, nil
+}
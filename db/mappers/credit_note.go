@@ -0,0 +1,68 @@
+package mappers
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+type CreditNoteMapperInterface interface {
+	FindByOrderID(orderID int) ([]*models.CreditNote, error)
+}
+
+type CreditNoteMapper struct {
+	DB *sqlx.DB
+}
+
+func (m CreditNoteMapper) FindByOrderID(orderID int) ([]*models.CreditNote, error) {
+	notes := []*models.CreditNote{}
+	err := m.DB.Select(&notes, `SELECT * FROM credit_notes WHERE order_id=$1 ORDER BY id`, orderID)
+	if err != nil {
+		return nil, errors.Wrap(err, "find credit notes error")
+	}
+	return notes, nil
+}
+
+// issue adds the credit note and records it on the timeline of its order.
+func (CreditNoteMapper) issue(txn *sqlx.Tx, note *models.CreditNote, actor string) error {
+	stmt := `INSERT INTO credit_notes (order_id, return_id, amount, currency, reason)
+			 VALUES ($1, $2, $3, $4, $5)
+			 RETURNING id, created_at`
+	err := txn.QueryRowx(stmt, note.OrderID, note.ReturnID, note.Amount, note.Currency, note.Reason).
+		Scan(&note.ID, &note.CreatedAt)
+	if err != nil {
+		return errors.Wrap(err, "insert credit note error")
+	}
+	return OrderEventMapper{}.Record(txn, note.OrderID, models.OrderEventCreditNote, actor,
+		fmt.Sprintf("credit note %d: %v %v, %v", note.ID, note.Amount, note.Currency, note.Reason))
+}
+
+// creditCancelledOrders issues credit notes for the invoiced and not yet credited amounts of cancelled orders,
+// orders cancelled before they were invoiced have never been billed and get none.
+func creditCancelledOrders(txn *sqlx.Tx, orderIDs []int, actor string) error {
+	var notes []*models.CreditNote
+	stmt := `SELECT order_id, amount, currency FROM (
+			     SELECT o.id AS order_id, o.currency,
+			            o.total - COALESCE((SELECT sum(n.amount) FROM credit_notes n WHERE n.order_id = o.id), 0) AS amount
+			     FROM orders o
+			     WHERE o.id = ANY($1) AND o.invoice_id IS NOT NULL
+			 ) billed
+			 WHERE amount > 0
+			 ORDER BY order_id`
+	err := txn.Select(&notes, stmt, pq.Array(orderIDs))
+	if err != nil {
+		return errors.Wrap(err, "find invoiced amounts error")
+	}
+	for _, note := range notes {
+		note.Reason = "order cancelled"
+		err = CreditNoteMapper{}.issue(txn, note, actor)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package mappers
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+type FavoriteMapperInterface interface {
+	FindPets(userID int) ([]*models.Pet, error)
+	Add(userID, petID int) error
+	Remove(userID, petID int) error
+}
+
+type FavoriteMapper struct {
+	DB *sqlx.DB
+}
+
+// FindPets returns pets favorited by the user, the most recently added first. Deleted pets are skipped.
+func (m FavoriteMapper) FindPets(userID int) ([]*models.Pet, error) {
+	stmt := `SELECT ` + petColumns + `
+		FROM favorites fav
+		    INNER JOIN pets p ON fav.pet_id = p.id
+		    LEFT JOIN categories c ON p.category_id = c.id
+		WHERE fav.user_id = $1 AND p.deleted_at IS NULL
+		ORDER BY fav.created_at DESC, p.id`
+	rows, err := m.DB.Queryx(stmt, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "find favorite pets error")
+	}
+	defer rows.Close()
+	pets := []*models.Pet{}
+	for rows.Next() {
+		p, err := scanPet(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "scan pet error")
+		}
+		pets = append(pets, p)
+	}
+	return pets, rows.Err()
+}
+
+// Add favorites the pet for the user, adding it again changes nothing.
+func (m FavoriteMapper) Add(userID, petID int) error {
+	stmt := `INSERT INTO favorites (user_id, pet_id)
+			 SELECT $1, id FROM pets WHERE id = $2 AND deleted_at IS NULL
+			 ON CONFLICT (user_id, pet_id) DO NOTHING
+			 RETURNING pet_id`
+	rows, err := m.DB.Query(stmt, userID, petID)
+	if err != nil {
+		return errors.Wrap(err, "add favorite error")
+	}
+	defer rows.Close()
+	if rows.Next() {
+		invalidateInventory()
+		return nil
+	}
+	if err = rows.Err(); err != nil {
+		return errors.Wrap(err, "add favorite error")
+	}
+	// nothing inserted, either the pet is missing or it is a favorite already
+	var exists bool
+	err = m.DB.Get(&exists, `SELECT EXISTS(SELECT 1 FROM pets WHERE id = $1 AND deleted_at IS NULL)`, petID)
+	if err != nil {
+		return errors.Wrap(err, "find pet error")
+	}
+	if !exists {
+		return NotFoundError(fmt.Sprintf("Pet record have not found by id: %d", petID))
+	}
+	return nil
+}
+
+func (m FavoriteMapper) Remove(userID, petID int) error {
+	result, err := m.DB.Exec(`DELETE FROM favorites WHERE user_id = $1 AND pet_id = $2`, userID, petID)
+	if err != nil {
+		return errors.Wrap(err, "remove favorite error")
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return NotFoundError(fmt.Sprintf("pet %d is not a favorite", petID))
+	}
+	invalidateInventory()
+	return nil
+}
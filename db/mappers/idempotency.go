@@ -0,0 +1,83 @@
+package mappers
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+type IdempotencyMapperInterface interface {
+	Begin(key, scope, requestHash string, ttl time.Duration) (*models.IdempotencyRecord, error)
+	Complete(key, scope string, code int, headers models.ResponseHeaders, body []byte) error
+	Release(key, scope string) error
+	Purge(expiredBefore time.Time) (int64, error)
+}
+
+type IdempotencyMapper struct {
+	DB *sqlx.DB
+}
+
+// Begin claims the key for a request kept for ttl and returns nil, or returns the record of the key
+// claimed before and not expired yet. Expired records are taken over by the new request.
+func (m IdempotencyMapper) Begin(key, scope, requestHash string, ttl time.Duration) (*models.IdempotencyRecord, error) {
+	claim := `INSERT INTO idempotency_keys (key, scope, request_hash, expires_at)
+			  VALUES ($1, $2, $3, now() + $4 * interval '1 millisecond')
+			  ON CONFLICT (scope, key) DO UPDATE
+			      SET request_hash = EXCLUDED.request_hash, response_code = NULL, response_headers = NULL,
+			          response_body = NULL, created_at = now(), expires_at = EXCLUDED.expires_at
+			      WHERE idempotency_keys.expires_at <= now()
+			  RETURNING key`
+	// the record found conflicting may be released before it is read, the claim is retried then
+	for attempt := 0; attempt < 3; attempt++ {
+		var claimed string
+		err := m.DB.Get(&claimed, claim, key, scope, requestHash, int64(ttl/time.Millisecond))
+		if err == nil {
+			return nil, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, errors.Wrap(err, "claim idempotency key error")
+		}
+		record := &models.IdempotencyRecord{}
+		err = m.DB.Get(record, `SELECT * FROM idempotency_keys WHERE scope=$1 AND key=$2`, scope, key)
+		if err == nil {
+			return record, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, errors.Wrap(err, "find idempotency key error")
+		}
+	}
+	return nil, ConflictError("idempotency key is being released, retry the request")
+}
+
+// Complete records the response of the request which has claimed the key.
+func (m IdempotencyMapper) Complete(key, scope string, code int, headers models.ResponseHeaders, body []byte) error {
+	stmt := `UPDATE idempotency_keys SET response_code=$1, response_headers=$2, response_body=$3
+			 WHERE scope=$4 AND key=$5`
+	_, err := m.DB.Exec(stmt, code, headers, body, scope, key)
+	if err != nil {
+		return errors.Wrap(err, "record idempotent response error")
+	}
+	return nil
+}
+
+// Release forgets the key of a request which has not completed, so it can be retried.
+func (m IdempotencyMapper) Release(key, scope string) error {
+	_, err := m.DB.Exec(`DELETE FROM idempotency_keys WHERE scope=$1 AND key=$2 AND response_code IS NULL`, scope, key)
+	if err != nil {
+		return errors.Wrap(err, "release idempotency key error")
+	}
+	return nil
+}
+
+// Purge removes records expired before the given time and returns their count.
+func (m IdempotencyMapper) Purge(expiredBefore time.Time) (int64, error) {
+	result, err := m.DB.Exec(`DELETE FROM idempotency_keys WHERE expires_at < $1`, expiredBefore)
+	if err != nil {
+		return 0, errors.Wrap(err, "purge idempotency keys error")
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,142 @@
+package mappers
+
+import (
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+)
+
+const defaultInventoryCacheTTL = 10 * time.Second
+
+type InventoryConfig struct {
+	// CacheTTL limits how long the report is reused, changes made by other replicas show up after it
+	CacheTTL utils.Duration
+}
+
+type InventoryMapperInterface interface {
+	Get() (*models.InventoryReport, error)
+}
+
+type InventoryMapper struct {
+	DB *sqlx.DB
+}
+
+// favorites count of every pet
+const favoriteCounts = `(SELECT pet_id, count(*) AS favorites FROM favorites GROUP BY pet_id)`
+
+func (m InventoryMapper) Get() (*models.InventoryReport, error) {
+	report := models.NewInventoryReport()
+
+	stmt := `SELECT p.status, COALESCE(c.name, ''), count(*), COALESCE(sum(fav.favorites), 0)
+			 FROM pets p
+			     LEFT JOIN categories c ON p.category_id = c.id
+			     LEFT JOIN ` + favoriteCounts + ` fav ON fav.pet_id = p.id
+			 WHERE p.deleted_at IS NULL
+			 GROUP BY p.status, c.name`
+	err := m.collect(stmt, func(status, category string, pets, favorites int) {
+		report.Total.Pets[status] += pets
+		report.Total.Favorites[status] += favorites
+		counts(report.Categories, category).Pets[status] += pets
+		counts(report.Categories, category).Favorites[status] += favorites
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "inventory by category error")
+	}
+
+	stmt = `SELECT p.status, t.name, count(*), COALESCE(sum(fav.favorites), 0)
+			FROM pets p
+			    INNER JOIN pet_tag pt ON pt.pet_id = p.id
+			    INNER JOIN tags t ON pt.tag_id = t.id
+			    LEFT JOIN ` + favoriteCounts + ` fav ON fav.pet_id = p.id
+			WHERE p.deleted_at IS NULL
+			GROUP BY p.status, t.name`
+	err = m.collect(stmt, func(status, tag string, pets, favorites int) {
+		counts(report.Tags, tag).Pets[status] += pets
+		counts(report.Tags, tag).Favorites[status] += favorites
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "inventory by tag error")
+	}
+	return report, nil
+}
+
+// collect runs a query returning status, group name, pets and favorites counts.
+func (m InventoryMapper) collect(stmt string, fn func(status, group string, pets, favorites int)) error {
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var group sql.NullString
+		var pets, favorites int
+		err = rows.Scan(&status, &group, &pets, &favorites)
+		if err != nil {
+			return err
+		}
+		fn(status, group.String, pets, favorites)
+	}
+	return rows.Err()
+}
+
+func counts(groups map[string]*models.InventoryCounts, name string) *models.InventoryCounts {
+	c, ok := groups[name]
+	if !ok {
+		c = models.NewInventoryCounts()
+		groups[name] = c
+	}
+	return c
+}
+
+// inventoryGeneration changes on every committed pet write,
+// reports computed under an older generation are not reused.
+var inventoryGeneration int64
+
+// invalidateInventory must be called after commits changing pets, their categories or favorites.
+func invalidateInventory() {
+	atomic.AddInt64(&inventoryGeneration, 1)
+}
+
+// CachedInventoryMapper reuses the report until the TTL passes or pets change.
+type CachedInventoryMapper struct {
+	InventoryMapperInterface
+	ttl time.Duration
+
+	mu         *sync.Mutex
+	report     *models.InventoryReport
+	generation int64
+	expiresAt  time.Time
+}
+
+func NewCachedInventoryMapper(mapper InventoryMapperInterface, config InventoryConfig) *CachedInventoryMapper {
+	ttl := config.CacheTTL.Duration
+	if ttl <= 0 {
+		ttl = defaultInventoryCacheTTL
+	}
+	return &CachedInventoryMapper{InventoryMapperInterface: mapper, ttl: ttl, mu: &sync.Mutex{}}
+}
+
+// Get returns the cached report, the returned report is shared and must not be modified.
+func (m *CachedInventoryMapper) Get() (*models.InventoryReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// read before the query, so a write committed meanwhile leaves the result stale
+	generation := atomic.LoadInt64(&inventoryGeneration)
+	if m.report != nil && m.generation == generation && time.Now().Before(m.expiresAt) {
+		return m.report, nil
+	}
+	report, err := m.InventoryMapperInterface.Get()
+	if err != nil {
+		return nil, err
+	}
+	m.report, m.generation, m.expiresAt = report, generation, time.Now().Add(m.ttl)
+	return report, nil
+}
@@ -0,0 +1,48 @@
+package mappers
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+)
+
+// countingInventoryMapper counts how many reports have been computed.
+type countingInventoryMapper struct {
+	computed *int
+}
+
+func (m countingInventoryMapper) Get() (*models.InventoryReport, error) {
+	*m.computed++
+	return models.NewInventoryReport(), nil
+}
+
+func TestCachedInventoryMapper(t *testing.T) {
+	computed := 0
+	mapper := NewCachedInventoryMapper(countingInventoryMapper{&computed}, InventoryConfig{CacheTTL: utils.Duration{Duration: time.Hour}})
+	for i := 0; i < 3; i++ {
+		if _, err := mapper.Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if computed != 1 {
+		t.Fatalf("got %d reports computed within the TTL, want 1", computed)
+	}
+	invalidateInventory()
+	if _, err := mapper.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if computed != 2 {
+		t.Errorf("got %d reports computed after invalidation, want 2", computed)
+	}
+
+	expired := NewCachedInventoryMapper(countingInventoryMapper{&computed}, InventoryConfig{CacheTTL: utils.Duration{Duration: time.Nanosecond}})
+	computed = 0
+	expired.Get()
+	time.Sleep(time.Millisecond)
+	expired.Get()
+	if computed != 2 {
+		t.Errorf("got %d reports computed after the TTL, want 2", computed)
+	}
+}
@@ -2,8 +2,13 @@ package mappers
 
 import (
 	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 
 	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
@@ -12,7 +17,12 @@ import (
 type InvoiceMapperInterface interface {
 	GetLast() (*models.Invoice, error)
 	FindByID(id int) (*models.Invoice, error)
-	Create(*models.Invoice) error
+	Find(limit, offset int) ([]*models.Invoice, error)
+	Count() (int, error)
+	Claim(i *models.Invoice, limit int, lease time.Duration) ([]*models.Order, []*models.CreditNote, error)
+	ClaimPending(limit int, lease time.Duration) ([]*models.Invoice, error)
+	FindItems(i *models.Invoice) ([]*models.Order, []*models.CreditNote, error)
+	Finish(i *models.Invoice) error
 	Update(*models.Invoice) error
 	Delete(id int) error
 }
@@ -23,7 +33,7 @@ type InvoiceMapper struct {
 
 func (m InvoiceMapper) GetLast() (*models.Invoice, error) {
 	invoice := &models.Invoice{}
-	err := m.DB.Get(invoice, "SELECT * FROM invoices ORDER BY id LIMIT 1")
+	err := m.DB.Get(invoice, "SELECT * FROM invoices ORDER BY id DESC LIMIT 1")
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, NotFoundError("invoice not found")
@@ -45,15 +55,203 @@ func (m InvoiceMapper) FindByID(id int) (*models.Invoice, error) {
 	return invoice, nil
 }
 
-func (m InvoiceMapper) Create(i *models.Invoice) error {
-	stmt := `INSERT INTO invoices ( body, created_date ) VALUES ($1, $2) RETURNING id;`
-	var invoiceID int
-	err := m.DB.QueryRowx(stmt, i.Body, i.CreatedDate).Scan(&invoiceID)
+// Find returns a page of invoices, the latest first.
+func (m InvoiceMapper) Find(limit, offset int) ([]*models.Invoice, error) {
+	invoices := []*models.Invoice{}
+	err := m.DB.Select(&invoices, "SELECT * FROM invoices ORDER BY id DESC LIMIT $1 OFFSET $2", limit, offset)
+	if err != nil {
+		return nil, errors.Wrap(err, "find invoices error")
+	}
+	return invoices, nil
+}
+
+func (m InvoiceMapper) Count() (int, error) {
+	var count int
+	err := m.DB.Get(&count, "SELECT count(*) FROM invoices")
+	if err != nil {
+		return 0, errors.Wrap(err, "count invoices error")
+	}
+	return count, nil
+}
+
+// Claim issues the next invoice of up to limit orders and credit notes not invoiced yet and returns them.
+// Approved and delivered orders are invoiced even when deleted, cancelled ones are never billed. Credit notes
+// are invoiced once their order has been, orders cancelled before that are neither billed nor credited.
+// The orders and credit notes are claimed in the transaction, so every one is put on a single invoice,
+// rows locked by other transactions are left for the next invoice. The invoice gets the next number, its period
+// starts where the last invoice ended and ends with the latest claimed row when the batch is full, and it lists
+// the claimed ids. The invoice is committed as pending and leased to the caller, so no lock is held while
+// its documents are stored and Finish issues it. NotFoundError is returned when there is nothing to invoice.
+func (m InvoiceMapper) Claim(i *models.Invoice, limit int, lease time.Duration) ([]*models.Order, []*models.CreditNote, error) {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "transaction open error")
+	}
+	// the counter stays locked until the commit, so invoices are claimed one at a time and numbered without gaps
+	err = txn.Get(&i.Number, `UPDATE invoice_counters SET last_number = last_number + 1 RETURNING last_number`)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invoice number error")
+	}
+	orders := []*models.Order{}
+	err = txn.Select(&orders, `SELECT `+orderColumns+` FROM orders o
+			 WHERE o.invoice_id IS NULL AND o.status IN ($1, $2)
+			 ORDER BY o.created_at, o.id
+			 LIMIT $3
+			 FOR UPDATE OF o SKIP LOCKED`, models.OrderStatusApproved, models.OrderStatusDelivered, limit)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "claim orders error")
+	}
+	creditNotes := []*models.CreditNote{}
+	err = txn.Select(&creditNotes, `SELECT * FROM credit_notes n
+			 WHERE n.invoice_id IS NULL
+			       AND EXISTS (SELECT 1 FROM orders o WHERE o.id = n.order_id AND o.invoice_id IS NOT NULL)
+			 ORDER BY n.id
+			 LIMIT $1
+			 FOR UPDATE OF n SKIP LOCKED`, limit)
 	if err != nil {
-		return errors.Wrap(err, "invoice create have failed")
+		return nil, nil, errors.Wrap(err, "claim credit notes error")
+	}
+	if len(orders) == 0 && len(creditNotes) == 0 {
+		return nil, nil, NotFoundError("nothing to invoice")
 	}
-	i.ID = invoiceID
 
+	if len(orders) == limit || len(creditNotes) == limit {
+		// the rest is left for the next invoice, its period starts after the claimed rows
+		i.PeriodEnd = latestInvoiced(orders, creditNotes)
+	}
+	err = txn.Get(&i.PeriodStart, `SELECT period_end FROM invoices ORDER BY number DESC LIMIT 1`)
+	if err == sql.ErrNoRows {
+		// the first invoice starts with its earliest order or credit note
+		i.PeriodStart = i.PeriodEnd
+		for _, o := range orders {
+			if o.CreatedAt.Before(i.PeriodStart) {
+				i.PeriodStart = o.CreatedAt
+			}
+		}
+		for _, n := range creditNotes {
+			if n.CreatedAt.Before(i.PeriodStart) {
+				i.PeriodStart = n.CreatedAt
+			}
+		}
+	} else if err != nil {
+		return nil, nil, errors.Wrap(err, "last invoice period error")
+	}
+	if i.PeriodEnd.Before(i.PeriodStart) {
+		i.PeriodEnd = i.PeriodStart
+	}
+	i.OrderIDs = pq.Int64Array{}
+	for _, o := range orders {
+		i.OrderIDs = append(i.OrderIDs, int64(o.ID))
+	}
+	i.CreditNoteIDs = pq.Int64Array{}
+	for _, n := range creditNotes {
+		i.CreditNoteIDs = append(i.CreditNoteIDs, int64(n.ID))
+	}
+
+	i.Status = models.InvoiceStatusPending
+	stmt := `INSERT INTO invoices (number, status, body, created_date, period_start, period_end, order_ids, credit_note_ids,
+			                       retry_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, now() + $9 * interval '1 millisecond')
+			 RETURNING id, retry_at`
+	err = txn.QueryRowx(stmt, i.Number, i.Status, i.Body, i.CreatedDate, i.PeriodStart, i.PeriodEnd, i.OrderIDs,
+		i.CreditNoteIDs, int64(lease/time.Millisecond)).Scan(&i.ID, &i.RetryAt)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invoice create have failed")
+	}
+	_, err = txn.Exec(`UPDATE orders SET invoice_id=$1 WHERE id = ANY($2)`, i.ID, i.OrderIDs)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invoice orders update error")
+	}
+	_, err = txn.Exec(`UPDATE credit_notes SET invoice_id=$1 WHERE id = ANY($2)`, i.ID, i.CreditNoteIDs)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invoice credit notes update error")
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Transaction commit fail")
+	}
+	return orders, creditNotes, nil
+}
+
+// latestInvoiced returns when the latest of the orders and credit notes was made.
+func latestInvoiced(orders []*models.Order, creditNotes []*models.CreditNote) time.Time {
+	var latest time.Time
+	for _, o := range orders {
+		if o.CreatedAt.After(latest) {
+			latest = o.CreatedAt
+		}
+	}
+	for _, n := range creditNotes {
+		if n.CreatedAt.After(latest) {
+			latest = n.CreatedAt
+		}
+	}
+	return latest
+}
+
+// ClaimPending takes up to limit pending invoices whose lease has ended, the oldest first, and leases them
+// to the caller. Their documents were not stored, e.g. the storage failed or the worker stopped, so they are
+// stored again, other runs do not take them meanwhile.
+func (m InvoiceMapper) ClaimPending(limit int, lease time.Duration) ([]*models.Invoice, error) {
+	invoices := []*models.Invoice{}
+	stmt := `WITH claimed AS (
+			     UPDATE invoices SET retry_at = now() + $2 * interval '1 millisecond'
+			     WHERE id IN (
+			         SELECT id FROM invoices
+			         WHERE status=$3 AND retry_at <= now()
+			         ORDER BY number
+			         LIMIT $1
+			         FOR UPDATE SKIP LOCKED
+			     )
+			     RETURNING *
+			 )
+			 SELECT * FROM claimed ORDER BY number`
+	err := m.DB.Select(&invoices, stmt, limit, int64(lease/time.Millisecond), models.InvoiceStatusPending)
+	if err != nil {
+		return nil, errors.Wrap(err, "claim pending invoices error")
+	}
+	return invoices, nil
+}
+
+// FindItems returns the orders and credit notes on the invoice.
+func (m InvoiceMapper) FindItems(i *models.Invoice) ([]*models.Order, []*models.CreditNote, error) {
+	orders := []*models.Order{}
+	err := m.DB.Select(&orders, `SELECT `+orderColumns+` FROM orders o WHERE o.invoice_id=$1 ORDER BY o.created_at, o.id`,
+		i.ID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "find invoice orders error")
+	}
+	creditNotes := []*models.CreditNote{}
+	err = m.DB.Select(&creditNotes, `SELECT * FROM credit_notes WHERE invoice_id=$1 ORDER BY id`, i.ID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "find invoice credit notes error")
+	}
+	return orders, creditNotes, nil
+}
+
+// Finish issues the pending invoice with the Totals, Documents, StorageKey and ContentType of its stored documents.
+// ConflictError is returned when the invoice has been issued meanwhile.
+func (m InvoiceMapper) Finish(i *models.Invoice) error {
+	result, err := m.DB.Exec(`UPDATE invoices SET status=$1, totals=$2, storage_key=$3, content_type=$4, documents=$5
+			 WHERE id=$6 AND status=$7`,
+		models.InvoiceStatusIssued, i.Totals, i.StorageKey, i.ContentType, i.Documents, i.ID, models.InvoiceStatusPending)
+	if err != nil {
+		return errors.Wrap(err, "invoice update have failed")
+	}
+	finished, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "invoice update have failed")
+	}
+	if finished == 0 {
+		return ConflictError(fmt.Sprintf("invoice %d is not pending", i.Number))
+	}
+	i.Status = models.InvoiceStatusIssued
 	return nil
 }
 
@@ -14,3 +14,20 @@ type NotFoundError string
 func (e NotFoundError) Error() string {
 	return string(e)
 }
+
+// VersionMismatchError is returned when the record version differs from the one the caller expects.
+type VersionMismatchError string
+
+func (e VersionMismatchError) Error() string {
+	return string(e)
+}
+
+// ConflictError is returned when the record state does not allow the requested change.
+type ConflictError string
+
+func (e ConflictError) Error() string {
+	return string(e)
+}
+
+// pqLockNotAvailable is the code of the error returned by NOWAIT locks of rows held by another transaction
+const pqLockNotAvailable = "55P03"
@@ -2,9 +2,12 @@ package mappers
 
 import (
 	"database/sql"
+	"fmt"
+	"sort"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 
 	"time"
 
@@ -13,18 +16,48 @@ import (
 
 type OrderMapperInterface interface {
 	FindByID(id int) (*models.Order, error)
-	GetOldest(int64) ([]*models.Order, error)
+	Find(filter OrderFilter) ([]*models.Order, error)
+	Export(filter OrderFilter, fn func(*models.Order) error) error
+	Totals(filter OrderFilter) (OrderTotals, error)
 	Create(o *models.Order) error
 	Update(o *models.Order) error
 	Delete(id int) error
+	FindDeleted() ([]*models.Order, error)
+	Restore(id int) error
+	Purge(deletedBefore time.Time) (int64, error)
+	Approve(id int) (*models.Order, error)
+	Deliver(id int) (*models.Order, error)
+	Cancel(id int) (*models.Order, error)
+	WithVersion(version int) OrderMapperInterface
+	WithActor(actor string) OrderMapperInterface
 }
 type OrderMapper struct {
 	DB *sqlx.DB
+	// Version is the version mutated orders are expected to have, zero disables the check
+	Version int
+	// Actor is recorded in the pet history as the author of pet status changes
+	Actor string
 }
 
+func (m OrderMapper) WithActor(actor string) OrderMapperInterface {
+	m.Actor = actor
+	return m
+}
+
+func (m OrderMapper) WithVersion(version int) OrderMapperInterface {
+	m.Version = version
+	return m
+}
+
+// orderColumns select an order with its items aggregated into json, so every order is a single row
+const orderColumns = `o.*,
+		COALESCE((SELECT json_agg(json_build_object('id', i.id, 'petId', i.pet_id, 'quantity', i.quantity,
+		                          'unitPrice', i.unit_price, 'currency', i.currency, 'total', i.total) ORDER BY i.id)
+		          FROM order_items i WHERE i.order_id = o.id), '[]') AS items`
+
 func (m OrderMapper) FindByID(id int) (*models.Order, error) {
 	order := &models.Order{}
-	err := m.DB.Get(order, "SELECT * FROM orders where id=$1", id)
+	err := m.DB.Get(order, "SELECT "+orderColumns+" FROM orders o where o.id=$1 AND o.deleted_at IS NULL", id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, NotFoundError("order not found")
@@ -34,65 +67,490 @@ func (m OrderMapper) FindByID(id int) (*models.Order, error) {
 	return order, nil
 }
 
-func (m OrderMapper) GetOldest(timestamp int64) (orders []*models.Order, err error) {
-	stmt := "SELECT * FROM orders where ship_date > $1"
-	err = m.DB.Select(&orders, stmt, timestamp)
+// Find returns the page of orders matching the filter.
+func (m OrderMapper) Find(filter OrderFilter) ([]*models.Order, error) {
+	orders := []*models.Order{}
+	err := m.Export(filter, func(o *models.Order) error {
+		orders = append(orders, o)
+		return nil
+	})
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, NotFoundError("orders not found")
+		return nil, errors.Wrap(err, "find orders error")
+	}
+	return orders, nil
+}
+
+// Export calls fn for every order of the filter page in the filter order,
+// orders are read one by one, so large exports are never loaded into memory at once.
+func (m OrderMapper) Export(filter OrderFilter, fn func(*models.Order) error) error {
+	where, args := filter.where()
+	orderBy, err := filter.orderBy()
+	if err != nil {
+		return err
+	}
+	stmt := `SELECT ` + orderColumns + ` FROM orders o ` + where + ` ` + orderBy
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit, filter.Offset)
+		stmt += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	}
+	rows, err := m.DB.Queryx(stmt, args...)
+	if err != nil {
+		return errors.Wrap(err, "export orders error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		o := &models.Order{}
+		err = rows.StructScan(o)
+		if err != nil {
+			return errors.Wrap(err, "scan order error")
 		}
+		err = fn(o)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Totals counts orders matching the filter and their quantity, the page is ignored.
+func (m OrderMapper) Totals(filter OrderFilter) (OrderTotals, error) {
+	totals := OrderTotals{}
+	where, args := filter.where()
+	stmt := `SELECT count(*) AS count, COALESCE(sum(o.quantity), 0) AS quantity FROM orders o ` + where
+	err := m.DB.Get(&totals, stmt, args...)
+	if err != nil {
+		return totals, errors.Wrap(err, "count orders error")
 	}
-	return
+	return totals, nil
 }
 
+// Create places the order and moves its available pets to pending in one transaction,
+// the pets stay locked until commit, so a pet cannot be ordered twice. Pets reserved by the customer
+// can be ordered by them, their reservations are fulfilled.
+// Unit prices and currencies of the items are taken from the pets.
 func (m OrderMapper) Create(o *models.Order) error {
-	stmt := `INSERT INTO orders ( pet_id, quantity, ship_date, complete, status) 
-             VALUES (:pet_id, :quantity, :ship_date, :complete, :status)
-             RETURNING id;`
-	timestamp := time.Now().Unix()
-	var orderID int
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
+	err = m.create(txn, o)
+	if err != nil {
+		return err
+	}
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	invalidateInventory()
+	return nil
+}
+
+// create places the order inside the transaction, the caller commits and invalidates the inventory.
+// Pets of the order must share a currency, the coupon of the order, if any, is redeemed.
+func (m OrderMapper) create(txn *sqlx.Tx, o *models.Order) error {
+	stmt := `INSERT INTO orders ( pet_id, user_id, quantity, ship_date, complete, status,
+                                  currency, subtotal, discount, total, coupon_code) 
+             VALUES (:pet_id, :user_id, :quantity, :ship_date, :complete, :status,
+                     :currency, :subtotal, :discount, :total, :coupon_code)
+             RETURNING id, created_at, updated_at;`
+	itemStmt := `INSERT INTO order_items (order_id, pet_id, quantity, unit_price, currency, total)
+                 VALUES ($1, $2, $3, $4, $5, $6)
+                 RETURNING id`
+	err := o.Items.Validate()
+	if err != nil {
+		return err
+	}
+
+	petMapper := PetMapper{DB: m.DB, Actor: m.Actor}
+	petIDs := make([]int, 0, len(o.Items))
+	for _, item := range o.Items {
+		petIDs = append(petIDs, item.PetID)
+	}
+	sort.Ints(petIDs)
+	pets, err := lockPets(txn, petMapper, petIDs, false)
+	if err != nil {
+		return err
+	}
+	// pets reserved by the customer are pending for them, their reservations are fulfilled by the order
+	reserved := map[int]bool{}
+	if o.UserID != nil {
+		reserved, err = ReservationMapper{DB: m.DB}.fulfill(txn, petIDs, *o.UserID)
+		if err != nil {
+			return err
+		}
+	}
+	currency := pets[o.Items[0].PetID].Currency
+	for _, item := range o.Items {
+		pet := pets[item.PetID]
+		if pet.Status != models.PetStatusAvailable && !(pet.Status == models.PetStatusPending && reserved[pet.ID]) {
+			return ConflictError(fmt.Sprintf("pet %d is %v and cannot be ordered", pet.ID, pet.Status))
+		}
+		if pet.Currency != currency {
+			return ConflictError(fmt.Sprintf("pet %d is priced in %v, other pets of the order in %v",
+				pet.ID, pet.Currency, currency))
+		}
+		item.UnitPrice = pet.Price
+		item.Currency = pet.Currency
+		item.Total = item.UnitPrice.Mul(item.Quantity)
+	}
+	o.Currency = currency
+	o.Subtotal = o.Items.Subtotal()
+	o.Discount = 0
+	var coupon *models.Coupon
+	if o.CouponCode != nil && *o.CouponCode == "" {
+		o.CouponCode = nil
+	}
+	if o.CouponCode != nil {
+		coupon, err = CouponMapper{}.redeem(txn, o)
+		if err != nil {
+			return err
+		}
+		o.CouponCode = &coupon.Code
+		o.Discount = coupon.Discount(o.Subtotal)
+	}
+	o.Total = o.Subtotal - o.Discount
+
+	o.Status = models.OrderStatusPlaced
+	o.Complete = false
+	o.PetID = o.Items[0].PetID
+	o.Quantity = o.Items.Quantity()
 	params := map[string]interface{}{
-		"pet_id":    o.PetID,
-		"quantity":  o.Quantity,
-		"ship_date": timestamp,
-		"complete":  o.Complete,
-		"status":    o.Status,
+		"pet_id":      o.PetID,
+		"user_id":     o.UserID,
+		"quantity":    o.Quantity,
+		"ship_date":   o.ShipDate,
+		"complete":    o.Complete,
+		"status":      o.Status,
+		"currency":    o.Currency,
+		"subtotal":    o.Subtotal,
+		"discount":    o.Discount,
+		"total":       o.Total,
+		"coupon_code": o.CouponCode,
 	}
-	rows, err := m.DB.NamedQuery(stmt, params)
+	rows, err := txn.NamedQuery(stmt, params)
 	if err != nil {
 		return errors.Wrap(err, "insert order error")
 	}
 	for rows.Next() {
-		err := rows.Scan(&orderID)
+		err := rows.Scan(&o.ID, &o.CreatedAt, &o.UpdatedAt)
 		if err != nil {
 			return errors.Wrap(err, "scan order id error")
 		}
 	}
-	o.ID = orderID
+	for _, item := range o.Items {
+		item.OrderID = o.ID
+		err = txn.QueryRowx(itemStmt, o.ID, item.PetID, item.Quantity, item.UnitPrice, item.Currency, item.Total).Scan(&item.ID)
+		if err != nil {
+			return errors.Wrap(err, "insert order item error")
+		}
+	}
+	if coupon != nil {
+		_, err = txn.Exec(`INSERT INTO coupon_redemptions (coupon_id, order_id, user_id, discount) VALUES ($1, $2, $3, $4)`,
+			coupon.ID, o.ID, o.UserID, o.Discount)
+		if err != nil {
+			return errors.Wrap(err, "insert coupon redemption error")
+		}
+	}
+	for _, petID := range petIDs {
+		err = petMapper.updateStatus(txn, pets[petID], models.PetStatusPending)
+		if err != nil {
+			return err
+		}
+	}
+	o.Version = 1
+	return OrderEventMapper{}.Record(txn, o.ID, models.OrderEventPlaced, m.Actor, "")
+}
+
+// lockPets locks the pets with the sorted ids one by one, so orders sharing pets cannot deadlock.
+// Deleted pets are nil when allowDeleted is set and a NotFoundError otherwise.
+func lockPets(txn *sqlx.Tx, petMapper PetMapper, ids []int, allowDeleted bool) (map[int]*models.Pet, error) {
+	pets := make(map[int]*models.Pet, len(ids))
+	for _, id := range ids {
+		pet, err := petMapper.snapshot(txn, id)
+		if _, ok := err.(NotFoundError); ok && allowDeleted {
+			pets[id] = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pets[id] = pet
+	}
+	return pets, nil
+}
+
+// Approve confirms a placed order, its pets stay pending.
+func (m OrderMapper) Approve(id int) (*models.Order, error) {
+	return m.transition(id, models.OrderStatusApproved, approvePet)
+}
+
+func approvePet(txn *sqlx.Tx, petMapper PetMapper, pet *models.Pet) error {
+	if pet.Status != models.PetStatusPending {
+		return ConflictError(fmt.Sprintf("pet %d is %v, expected pending", pet.ID, pet.Status))
+	}
 	return nil
 }
 
+// Deliver completes an approved order and sells its pets.
+func (m OrderMapper) Deliver(id int) (*models.Order, error) {
+	return m.transition(id, models.OrderStatusDelivered, func(txn *sqlx.Tx, petMapper PetMapper, pet *models.Pet) error {
+		return petMapper.updateStatus(txn, pet, models.PetStatusSold)
+	})
+}
+
+// Cancel cancels an open order and releases its pets unless they have moved on from pending meanwhile.
+func (m OrderMapper) Cancel(id int) (*models.Order, error) {
+	return m.transition(id, models.OrderStatusCancelled, releasePet)
+}
+
+func releasePet(txn *sqlx.Tx, petMapper PetMapper, pet *models.Pet) error {
+	if pet == nil || pet.Status != models.PetStatusPending {
+		return nil
+	}
+	return petMapper.updateStatus(txn, pet, models.PetStatusAvailable)
+}
+
+// transition moves the order to the status and lets updatePet change every pet of the order in the same transaction.
+// The pets are locked before the order, the same way pet status hooks do, so they cannot deadlock.
+// updatePet gets a nil pet when it has been deleted, approving or delivering such an order is a conflict.
+func (m OrderMapper) transition(id int, status string,
+	updatePet func(txn *sqlx.Tx, petMapper PetMapper, pet *models.Pet) error) (*models.Order, error) {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return nil, errors.Wrap(err, "transaction open error")
+	}
+	order, err := m.transitionTx(txn, id, status, updatePet)
+	if err != nil {
+		return nil, err
+	}
+	err = txn.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "Transaction commit fail")
+	}
+	invalidateInventory()
+	return order, nil
+}
+
+// transitionTx does the transition of the order in a transaction opened by the caller.
+func (m OrderMapper) transitionTx(txn *sqlx.Tx, id int, status string,
+	updatePet func(txn *sqlx.Tx, petMapper PetMapper, pet *models.Pet) error) (*models.Order, error) {
+	var petIDs []int
+	stmt := `SELECT i.pet_id FROM order_items i INNER JOIN orders o ON i.order_id = o.id
+			 WHERE o.id=$1 AND o.deleted_at IS NULL
+			 ORDER BY i.pet_id`
+	err := txn.Select(&petIDs, stmt, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "find order pets error")
+	}
+	if len(petIDs) == 0 {
+		return nil, NotFoundError("order not found")
+	}
+	petMapper := PetMapper{DB: m.DB, Actor: m.Actor}
+	pets, err := lockPets(txn, petMapper, petIDs, status == models.OrderStatusCancelled)
+	if _, ok := err.(NotFoundError); ok {
+		return nil, ConflictError(fmt.Sprintf("order %d: %v", id, err))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	order := &models.Order{}
+	err = txn.Get(order, `SELECT * FROM orders WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError("order not found")
+		}
+		return nil, errors.Wrap(err, "find order error")
+	}
+	if m.Version != 0 && m.Version != order.Version {
+		return nil, VersionMismatchError(fmt.Sprintf("order %d has version %d, expected %d", id, order.Version, m.Version))
+	}
+	err = order.CheckTransition(status)
+	if err != nil {
+		return nil, ConflictError(err.Error())
+	}
+
+	// the order changes first, so the pet status hooks do not see it as open anymore
+	stmt = `UPDATE orders SET status=$1, complete=$2, version=version+1, updated_at=now() WHERE id=$3`
+	_, err = txn.Exec(stmt, status, status == models.OrderStatusDelivered, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "order status update failed")
+	}
+	for _, petID := range petIDs {
+		err = updatePet(txn, petMapper, pets[petID])
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = OrderEventMapper{}.Record(txn, id, status, m.Actor, "")
+	if err != nil {
+		return nil, err
+	}
+	if status == models.OrderStatusCancelled {
+		err = creditCancelledOrders(txn, []int{id}, m.Actor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = txn.Get(order, `SELECT `+orderColumns+` FROM orders o WHERE o.id=$1`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "find order error")
+	}
+	return order, nil
+}
+
 func (m OrderMapper) Update(o *models.Order) error {
 	stmt := `UPDATE orders SET pet_id=:pet_id, quantity=:quantity, ship_date=:ship_date, 
-                  complete=:complete, status=:status WHERE id=:id`
-	timestamp := time.Now().Unix()
+                  complete=:complete, status=:status, version=version+1, updated_at=now()
+             WHERE id=:id AND deleted_at IS NULL AND (:expected_version = 0 OR version=:expected_version)
+             RETURNING version, updated_at`
 	params := map[string]interface{}{
-		"pet_id":    o.PetID,
-		"quantity":  o.Quantity,
-		"ship_date": timestamp,
-		"complete":  o.Complete,
-		"status":    o.Status,
-		"id":        o.ID,
+		"pet_id":           o.PetID,
+		"quantity":         o.Quantity,
+		"ship_date":        o.ShipDate,
+		"complete":         o.Complete,
+		"status":           o.Status,
+		"id":               o.ID,
+		"expected_version": m.Version,
 	}
-	_, err := m.DB.NamedExec(stmt, params)
+	rows, err := m.DB.NamedQuery(stmt, params)
 	if err != nil {
 		return errors.Wrap(err, "order update failed")
 	}
+	defer rows.Close()
+	if !rows.Next() {
+		return m.notAffectedError(o.ID, false)
+	}
+	err = rows.Scan(&o.Version, &o.UpdatedAt)
+	if err != nil {
+		return errors.Wrap(err, "scan order version error")
+	}
 
 	return nil
 }
 
+// Delete moves the order to the trash. Open orders are cancelled first in the same transaction,
+// so their pets are released and what has been paid is credited.
 func (m OrderMapper) Delete(id int) error {
-	_, err := m.DB.Exec(`DELETE FROM orders where id=$1`, id)
-	return err
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
+
+	order := &models.Order{}
+	err = txn.Get(order, `SELECT * FROM orders WHERE id=$1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return NotFoundError("order not found")
+		}
+		return errors.Wrap(err, "find order error")
+	}
+	versioned := m.Version
+	if order.IsOpen() {
+		// the cancellation checks the version of the locked order
+		_, err = m.transitionTx(txn, id, models.OrderStatusCancelled, releasePet)
+		if err != nil {
+			return err
+		}
+		versioned = 0
+	}
+	stmt := `UPDATE orders SET deleted_at=now(), version=version+1, updated_at=now()
+			 WHERE id=$1 AND deleted_at IS NULL AND ($2 = 0 OR version=$2)`
+	result, err := txn.Exec(stmt, id, versioned)
+	if err != nil {
+		return errors.Wrap(err, "order delete failed")
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return m.notAffectedError(id, false)
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	invalidateInventory()
+	return nil
+}
+
+// FindDeleted returns orders in the trash, the most recently deleted first.
+func (m OrderMapper) FindDeleted() ([]*models.Order, error) {
+	orders := []*models.Order{}
+	err := m.DB.Select(&orders, "SELECT "+orderColumns+" FROM orders o WHERE o.deleted_at IS NOT NULL ORDER BY o.deleted_at DESC, o.id")
+	if err != nil {
+		return nil, errors.Wrap(err, "find deleted orders error")
+	}
+	return orders, nil
+}
+
+// Restore takes the order out of the trash.
+func (m OrderMapper) Restore(id int) error {
+	stmt := `UPDATE orders SET deleted_at=NULL, version=version+1, updated_at=now()
+			 WHERE id=$1 AND deleted_at IS NOT NULL AND ($2 = 0 OR version=$2)`
+	return m.exec(stmt, id, true)
+}
+
+// Purge permanently removes orders deleted before the given time and returns their count.
+// Orders with financial records, payments, returns, credit notes or an invoice, stay in the trash,
+// and so do approved and delivered orders which are still to be invoiced.
+func (m OrderMapper) Purge(deletedBefore time.Time) (int64, error) {
+	stmt := `DELETE FROM orders o
+			 WHERE o.deleted_at < $1 AND o.invoice_id IS NULL AND o.status NOT IN ($2, $3)
+			       AND NOT EXISTS (SELECT 1 FROM payments p WHERE p.order_id = o.id)
+			       AND NOT EXISTS (SELECT 1 FROM returns r WHERE r.order_id = o.id)
+			       AND NOT EXISTS (SELECT 1 FROM credit_notes n WHERE n.order_id = o.id)`
+	result, err := m.DB.Exec(stmt, deletedBefore, models.OrderStatusApproved, models.OrderStatusDelivered)
+	if err != nil {
+		return 0, errors.Wrap(err, "purge orders error")
+	}
+	return result.RowsAffected()
+}
+
+// exec runs a versioned statement changing a single order with the id and expected version as arguments.
+func (m OrderMapper) exec(stmt string, id int, deleted bool) error {
+	result, err := m.DB.Exec(stmt, id, m.Version)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return m.notAffectedError(id, deleted)
+	}
+	return nil
+}
+
+// notAffectedError tells apart a missing order from one with another version,
+// deleted chooses whether orders in the trash or the live ones are looked for.
+func (m OrderMapper) notAffectedError(id int, deleted bool) error {
+	var version int
+	stmt := `SELECT version FROM orders WHERE id=$1 AND (deleted_at IS NOT NULL) = $2`
+	err := m.DB.Get(&version, stmt, id, deleted)
+	if err == sql.ErrNoRows {
+		return NotFoundError("order not found")
+	}
+	if err != nil {
+		return errors.Wrap(err, "find order version error")
+	}
+	return VersionMismatchError(fmt.Sprintf("order %d has version %d, expected %d", id, version, m.Version))
 }
@@ -0,0 +1,49 @@
+package mappers
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+type OrderEventMapperInterface interface {
+	FindByOrderID(orderID int) ([]*models.OrderEvent, error)
+}
+
+type OrderEventMapper struct {
+	DB *sqlx.DB
+}
+
+// Record adds the event to the timeline of the order inside the transaction which performs the step
+// and publishes it to webhooks with the order as it is after the step.
+func (OrderEventMapper) Record(txn *sqlx.Tx, orderID int, event, actor, note string) error {
+	stmt := `INSERT INTO order_events (order_id, event, actor, note) VALUES ($1, $2, $3, $4)
+			 RETURNING created_at`
+	var createdAt time.Time
+	err := txn.Get(&createdAt, stmt, orderID, event, actor, note)
+	if err != nil {
+		return errors.Wrap(err, "order event insert error")
+	}
+	order := &models.Order{}
+	err = txn.Get(order, `SELECT `+orderColumns+` FROM orders o WHERE o.id=$1`, orderID)
+	if err != nil {
+		return errors.Wrap(err, "find order error")
+	}
+	return publish(txn, models.EventOrderPrefix+event, map[string]interface{}{
+		"order":      order,
+		"note":       note,
+		"occurredAt": createdAt,
+	})
+}
+
+func (m OrderEventMapper) FindByOrderID(orderID int) ([]*models.OrderEvent, error) {
+	events := []*models.OrderEvent{}
+	err := m.DB.Select(&events, `SELECT * FROM order_events WHERE order_id=$1 ORDER BY created_at, id`, orderID)
+	if err != nil {
+		return nil, errors.Wrap(err, "find order events error")
+	}
+	return events, nil
+}
@@ -0,0 +1,110 @@
+package mappers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OrderSorts maps sort keys accepted by order listings to their columns,
+// a key prefixed with "-" sorts descending.
+var OrderSorts = map[string]string{
+	"id":        "o.id",
+	"createdAt": "o.created_at",
+	"updatedAt": "o.updated_at",
+	"shipDate":  "o.ship_date",
+	"status":    "o.status",
+	"quantity":  "o.quantity",
+	"petId":     "o.pet_id",
+}
+
+const DefaultOrderSort = "-createdAt"
+
+// OrderFilter narrows order listings, empty fields match every order which is not deleted.
+// Conditions refer to the orders table as o.
+type OrderFilter struct {
+	Status   string
+	Complete *bool
+	// PetID matches orders having a line of the pet
+	PetID  int
+	UserID int
+	// Customer is the username of the user who placed the order
+	Customer string
+	// CreatedFrom and ShipFrom bounds are inclusive, CreatedTo and ShipTo are exclusive
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	ShipFrom    *time.Time
+	ShipTo      *time.Time
+
+	// Sort is one of OrderSorts keys, DefaultOrderSort when empty
+	Sort string
+	// Limit is the page size, zero returns every matching order
+	Limit  int
+	Offset int
+}
+
+// OrderTotals summarizes all orders matching a filter regardless of the page.
+type OrderTotals struct {
+	Count    int `db:"count"`
+	Quantity int `db:"quantity"`
+}
+
+func (f OrderFilter) where() (string, []interface{}) {
+	conditions := []string{"o.deleted_at IS NULL"}
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.Status != "" {
+		add("o.status = $%d", f.Status)
+	}
+	if f.Complete != nil {
+		add("o.complete = $%d", *f.Complete)
+	}
+	if f.PetID != 0 {
+		add("EXISTS (SELECT 1 FROM order_items i WHERE i.order_id = o.id AND i.pet_id = $%d)", f.PetID)
+	}
+	if f.UserID != 0 {
+		add("o.user_id = $%d", f.UserID)
+	}
+	if f.Customer != "" {
+		add("o.user_id = (SELECT id FROM users WHERE username = $%d)", f.Customer)
+	}
+	if f.CreatedFrom != nil {
+		add("o.created_at >= $%d", *f.CreatedFrom)
+	}
+	if f.CreatedTo != nil {
+		add("o.created_at < $%d", *f.CreatedTo)
+	}
+	if f.ShipFrom != nil {
+		add("o.ship_date >= $%d", *f.ShipFrom)
+	}
+	if f.ShipTo != nil {
+		add("o.ship_date < $%d", *f.ShipTo)
+	}
+
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// orderBy returns the ORDER BY clause of the sort, ids break ties so pages are stable.
+func (f OrderFilter) orderBy() (string, error) {
+	sort := f.Sort
+	if sort == "" {
+		sort = DefaultOrderSort
+	}
+	direction := "ASC"
+	if strings.HasPrefix(sort, "-") {
+		direction = "DESC"
+		sort = sort[1:]
+	}
+	column, ok := OrderSorts[sort]
+	if !ok {
+		return "", fmt.Errorf("unknown order sort %v", f.Sort)
+	}
+	if column == "o.id" {
+		return "ORDER BY o.id " + direction, nil
+	}
+	return fmt.Sprintf("ORDER BY %v %v NULLS LAST, o.id %v", column, direction, direction), nil
+}
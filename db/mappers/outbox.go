@@ -0,0 +1,22 @@
+package mappers
+
+import (
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// publish writes the event to the outbox inside the transaction making the change,
+// so webhooks learn about the change exactly when it is committed.
+func publish(txn *sqlx.Tx, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "event payload marshal error")
+	}
+	_, err = txn.Exec(`INSERT INTO outbox_events (event_type, payload) VALUES ($1, $2)`, eventType, string(payload))
+	if err != nil {
+		return errors.Wrap(err, "outbox event insert error")
+	}
+	return nil
+}
@@ -0,0 +1,427 @@
+package mappers
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+type PaymentMapperInterface interface {
+	FindByID(id int) (*models.Payment, error)
+	FindByOrderID(orderID int) ([]*models.Payment, error)
+	Create(p *models.Payment) error
+	Update(p *models.Payment) error
+	Succeed(provider, reference string) (*models.Payment, error)
+	Fail(provider, reference, message string) (*models.Payment, error)
+	BeginRefund(id int, amount models.Decimal, returnID *int) (*models.Refund, error)
+	CompleteRefund(refundID int) (*models.Payment, error)
+	FailRefund(refundID int, message string) error
+	WithActor(actor string) PaymentMapperInterface
+}
+
+type PaymentMapper struct {
+	DB *sqlx.DB
+	// Actor is recorded on the order timeline and in the pet history when a payment approves an order
+	Actor string
+}
+
+func (m PaymentMapper) WithActor(actor string) PaymentMapperInterface {
+	m.Actor = actor
+	return m
+}
+
+func (m PaymentMapper) FindByID(id int) (*models.Payment, error) {
+	payment := &models.Payment{}
+	err := m.DB.Get(payment, `SELECT * FROM payments WHERE id=$1`, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError(fmt.Sprintf("payment %d not found", id))
+		}
+		return nil, errors.Wrap(err, "find payment error")
+	}
+	return payment, nil
+}
+
+func (m PaymentMapper) FindByOrderID(orderID int) ([]*models.Payment, error) {
+	payments := []*models.Payment{}
+	err := m.DB.Select(&payments, `SELECT * FROM payments WHERE order_id=$1 ORDER BY id`, orderID)
+	if err != nil {
+		return nil, errors.Wrap(err, "find payments error")
+	}
+	return payments, nil
+}
+
+// Create starts a pending payment of the total of a placed order. The order is locked,
+// so it cannot get a second payment while another one is open.
+func (m PaymentMapper) Create(p *models.Payment) error {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
+
+	order := &models.Order{}
+	err = txn.Get(order, `SELECT * FROM orders WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, p.OrderID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return NotFoundError(fmt.Sprintf("order %d not found", p.OrderID))
+		}
+		return errors.Wrap(err, "find order error")
+	}
+	if order.Status != models.OrderStatusPlaced {
+		return ConflictError(fmt.Sprintf("order %d is %v, only placed orders can be paid", order.ID, order.Status))
+	}
+	if order.Total <= 0 {
+		return ConflictError(fmt.Sprintf("order %d has nothing to pay", order.ID))
+	}
+	var open int
+	stmt := `SELECT count(*) FROM payments WHERE order_id=$1 AND status IN ($2, $3, $4, $5)`
+	err = txn.Get(&open, stmt, order.ID, models.PaymentStatusPending, models.PaymentStatusAuthorized,
+		models.PaymentStatusCaptured, models.PaymentStatusSucceeded)
+	if err != nil {
+		return errors.Wrap(err, "count payments error")
+	}
+	if open > 0 {
+		return ConflictError(fmt.Sprintf("order %d already has a payment in progress", order.ID))
+	}
+
+	p.Status = models.PaymentStatusPending
+	p.Amount = order.Total
+	p.Currency = order.Currency
+	stmt = `INSERT INTO payments (order_id, provider, status, amount, currency)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, created_at, updated_at`
+	err = txn.QueryRowx(stmt, p.OrderID, p.Provider, p.Status, p.Amount, p.Currency).
+		Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return errors.Wrap(err, "insert payment error")
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	return nil
+}
+
+// Update stores the answer of the provider, the reference, status and error of the payment.
+// Payments already settled by the webhook are left as they are.
+func (m PaymentMapper) Update(p *models.Payment) error {
+	stmt := `UPDATE payments SET reference=$1, status=$2, error=$3, updated_at=now()
+			 WHERE id=$4 AND status NOT IN ($5, $6, $7)
+			 RETURNING updated_at`
+	err := m.DB.QueryRowx(stmt, p.Reference, p.Status, p.Error, p.ID,
+		models.PaymentStatusSucceeded, models.PaymentStatusFailed, models.PaymentStatusRefunded).Scan(&p.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			current, err := m.FindByID(p.ID)
+			if err != nil {
+				return err
+			}
+			*p = *current
+			return nil
+		}
+		return errors.Wrap(err, "update payment error")
+	}
+	return nil
+}
+
+// Succeed settles the payment confirmed by the provider and approves its order when it is still placed,
+// an order cancelled meanwhile gets a credit note. Repeated confirmations are ignored.
+// A failed approval, e.g. of an order whose pet has been sold meanwhile, is logged
+// and leaves the order for the staff, the payment is recorded anyway.
+func (m PaymentMapper) Succeed(provider, reference string) (*models.Payment, error) {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return nil, errors.Wrap(err, "transaction open error")
+	}
+
+	payment, err := m.lock(txn, provider, reference)
+	if err != nil {
+		return nil, err
+	}
+	switch payment.Status {
+	case models.PaymentStatusSucceeded, models.PaymentStatusRefunded:
+		return payment, nil
+	case models.PaymentStatusFailed:
+		return nil, ConflictError(fmt.Sprintf("payment %v has failed", reference))
+	}
+	stmt := `UPDATE payments SET status=$1, error='', updated_at=now() WHERE id=$2 RETURNING status, error, updated_at`
+	err = txn.QueryRowx(stmt, models.PaymentStatusSucceeded, payment.ID).
+		Scan(&payment.Status, &payment.Error, &payment.UpdatedAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "update payment error")
+	}
+	err = OrderEventMapper{}.Record(txn, payment.OrderID, models.OrderEventPaymentSucceeded, m.Actor, paymentNote(payment))
+	if err != nil {
+		return nil, err
+	}
+
+	var status string
+	err = txn.Get(&status, `SELECT status FROM orders WHERE id=$1 AND deleted_at IS NULL`, payment.OrderID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "find order error")
+	}
+	approved := false
+	switch status {
+	case models.OrderStatusPlaced:
+		_, err = txn.Exec(`SAVEPOINT approve_order`)
+		if err != nil {
+			return nil, errors.Wrap(err, "savepoint error")
+		}
+		orderMapper := OrderMapper{DB: m.DB, Actor: m.Actor}
+		_, err = orderMapper.transitionTx(txn, payment.OrderID, models.OrderStatusApproved, approvePet)
+		if err != nil {
+			logrus.Errorf("paid order %d has not been approved: %v", payment.OrderID, err)
+			_, err = txn.Exec(`ROLLBACK TO SAVEPOINT approve_order`)
+			if err != nil {
+				return nil, errors.Wrap(err, "savepoint rollback error")
+			}
+		} else {
+			approved = true
+		}
+	case models.OrderStatusCancelled:
+		logrus.Warnf("payment %v succeeded for cancelled order %d", reference, payment.OrderID)
+		err = creditCancelledOrders(txn, []int{payment.OrderID}, m.Actor)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		logrus.Warnf("payment %v succeeded for order %d which is %v", reference, payment.OrderID, status)
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "Transaction commit fail")
+	}
+	if approved {
+		invalidateInventory()
+	}
+	return payment, nil
+}
+
+// Fail records the failure reported by the provider, settled payments are not changed.
+func (m PaymentMapper) Fail(provider, reference, message string) (*models.Payment, error) {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return nil, errors.Wrap(err, "transaction open error")
+	}
+
+	payment, err := m.lock(txn, provider, reference)
+	if err != nil {
+		return nil, err
+	}
+	switch payment.Status {
+	case models.PaymentStatusFailed:
+		return payment, nil
+	case models.PaymentStatusSucceeded, models.PaymentStatusRefunded:
+		return nil, ConflictError(fmt.Sprintf("payment %v has succeeded", reference))
+	}
+	stmt := `UPDATE payments SET status=$1, error=$2, updated_at=now() WHERE id=$3 RETURNING status, error, updated_at`
+	err = txn.QueryRowx(stmt, models.PaymentStatusFailed, message, payment.ID).
+		Scan(&payment.Status, &payment.Error, &payment.UpdatedAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "update payment error")
+	}
+	err = OrderEventMapper{}.Record(txn, payment.OrderID, models.OrderEventPaymentFailed, m.Actor,
+		paymentNote(payment)+", "+message)
+	if err != nil {
+		return nil, err
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "Transaction commit fail")
+	}
+	return payment, nil
+}
+
+// BeginRefund records a pending refund of the succeeded payment before its provider is asked,
+// the amount has to fit into what is left of the payment after refunds made and pending.
+// returnID is the approved return the refund completes, a return gets a single refund which has not failed.
+func (m PaymentMapper) BeginRefund(id int, amount models.Decimal, returnID *int) (*models.Refund, error) {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return nil, errors.Wrap(err, "transaction open error")
+	}
+
+	payment := &models.Payment{}
+	err = txn.Get(payment, `SELECT * FROM payments WHERE id=$1 FOR UPDATE`, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError(fmt.Sprintf("payment %d not found", id))
+		}
+		return nil, errors.Wrap(err, "find payment error")
+	}
+	var pending models.Decimal
+	stmt := `SELECT COALESCE(sum(amount), 0) FROM refunds WHERE payment_id=$1 AND status=$2`
+	err = txn.Get(&pending, stmt, id, models.RefundStatusPending)
+	if err != nil {
+		return nil, errors.Wrap(err, "sum pending refunds error")
+	}
+	if payment.Status != models.PaymentStatusSucceeded || amount <= 0 || payment.Refunded+pending+amount > payment.Amount {
+		return nil, ConflictError(fmt.Sprintf("payment %d is %v with %v of %v refunded and %v pending",
+			id, payment.Status, payment.Refunded, payment.Amount, pending))
+	}
+	if returnID != nil {
+		_, err = ReturnMapper{}.lock(txn, *returnID, models.ReturnStatusApproved)
+		if err != nil {
+			return nil, err
+		}
+		var refunds int
+		stmt = `SELECT count(*) FROM refunds WHERE return_id=$1 AND status<>$2`
+		err = txn.Get(&refunds, stmt, *returnID, models.RefundStatusFailed)
+		if err != nil {
+			return nil, errors.Wrap(err, "count refunds error")
+		}
+		if refunds > 0 {
+			return nil, ConflictError(fmt.Sprintf("return %d is being refunded already", *returnID))
+		}
+	}
+
+	refund := &models.Refund{}
+	stmt = `INSERT INTO refunds (payment_id, return_id, amount, status) VALUES ($1, $2, $3, $4) RETURNING *`
+	err = txn.Get(refund, stmt, id, returnID, amount, models.RefundStatusPending)
+	if err != nil {
+		return nil, errors.Wrap(err, "insert refund error")
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "Transaction commit fail")
+	}
+	return refund, nil
+}
+
+// CompleteRefund records the pending refund made by the provider, the payment is refunded once nothing is left of it
+// and the return of the refund is completed. A refund completed before returns its payment as it is.
+func (m PaymentMapper) CompleteRefund(refundID int) (*models.Payment, error) {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return nil, errors.Wrap(err, "transaction open error")
+	}
+
+	refund := &models.Refund{}
+	stmt := `UPDATE refunds SET status=$1, error='', updated_at=now() WHERE id=$2 AND status=$3 RETURNING *`
+	err = txn.Get(refund, stmt, models.RefundStatusSucceeded, refundID, models.RefundStatusPending)
+	if err == sql.ErrNoRows {
+		err = txn.Get(refund, `SELECT * FROM refunds WHERE id=$1`, refundID)
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError(fmt.Sprintf("refund %d not found", refundID))
+		}
+		if err == nil && refund.Status == models.RefundStatusSucceeded {
+			return m.FindByID(refund.PaymentID)
+		}
+		if err == nil {
+			return nil, ConflictError(fmt.Sprintf("refund %d is %v", refundID, refund.Status))
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "complete refund error")
+	}
+
+	// the amount has been held by the pending refund, so it fits
+	payment := &models.Payment{}
+	stmt = `UPDATE payments SET refunded = refunded + $1,
+			       status = CASE WHEN refunded + $1 >= amount THEN $2 ELSE status END, updated_at=now()
+			WHERE id=$3
+			RETURNING *`
+	err = txn.Get(payment, stmt, refund.Amount, models.PaymentStatusRefunded, refund.PaymentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "refund payment error")
+	}
+	note := fmt.Sprintf("payment %d: %v %v refunded", payment.ID, refund.Amount, payment.Currency)
+	err = OrderEventMapper{}.Record(txn, payment.OrderID, models.OrderEventRefunded, m.Actor, note)
+	if err != nil {
+		return nil, err
+	}
+	if refund.ReturnID != nil {
+		returnMapper := ReturnMapper{Actor: m.Actor}
+		r, err := returnMapper.lock(txn, *refund.ReturnID, models.ReturnStatusApproved)
+		if _, ok := err.(ConflictError); ok {
+			// the money is back with the customer anyway, the return is left to the staff
+			logrus.Warnf("refund %d has been made for return %d: %v", refund.ID, *refund.ReturnID, err)
+		} else if err != nil {
+			return nil, err
+		} else {
+			err = returnMapper.markRefunded(txn, r, &payment.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "Transaction commit fail")
+	}
+	return payment, nil
+}
+
+// FailRefund records the refund declined by the provider, its amount can be refunded again.
+func (m PaymentMapper) FailRefund(refundID int, message string) error {
+	stmt := `UPDATE refunds SET status=$1, error=$2, updated_at=now() WHERE id=$3 AND status=$4`
+	_, err := m.DB.Exec(stmt, models.RefundStatusFailed, message, refundID, models.RefundStatusPending)
+	if err != nil {
+		return errors.Wrap(err, "fail refund error")
+	}
+	return nil
+}
+
+// FindPendingRefunds returns refunds pending since before the given time, the oldest first.
+func (m PaymentMapper) FindPendingRefunds(createdBefore time.Time) ([]*models.Refund, error) {
+	refunds := []*models.Refund{}
+	stmt := `SELECT * FROM refunds WHERE status=$1 AND created_at < $2 ORDER BY created_at, id`
+	err := m.DB.Select(&refunds, stmt, models.RefundStatusPending, createdBefore)
+	if err != nil {
+		return nil, errors.Wrap(err, "find pending refunds error")
+	}
+	return refunds, nil
+}
+
+func (m PaymentMapper) lock(txn *sqlx.Tx, provider, reference string) (*models.Payment, error) {
+	payment := &models.Payment{}
+	stmt := `SELECT * FROM payments WHERE provider=$1 AND reference=$2 FOR UPDATE`
+	err := txn.Get(payment, stmt, provider, reference)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError(fmt.Sprintf("payment %v not found", reference))
+		}
+		return nil, errors.Wrap(err, "find payment error")
+	}
+	return payment, nil
+}
+
+func paymentNote(p *models.Payment) string {
+	return fmt.Sprintf("payment %d: %v %v", p.ID, p.Amount, p.Currency)
+}
@@ -2,8 +2,10 @@ package mappers
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -17,60 +19,282 @@ import (
 
 type PetMapperInterface interface {
 	FindByID(id int) (*models.Pet, error)
-	FindByStatus(status string) ([]*models.Pet, error)
-	FindByTags(tags []string) ([]*models.Pet, error)
+	Find(filter PetFilter) ([]*models.Pet, error)
 	Create(*models.Pet) error
+	CreateMany(pets []*models.Pet) error
+	Export(filter PetFilter, fn func(*models.Pet) error) error
 	Update(*models.Pet) error
+	FindAllImages() ([]models.PetImages, error)
+	UpdateImages(petID int, update func(models.PetImages) (models.PetImages, error)) (models.PetImages, error)
 	Delete(id int) error
+	FindDeleted() ([]*models.Pet, error)
+	Restore(id int) error
+	Purge(deletedBefore time.Time) ([]*models.Pet, error)
+	WithActor(actor string) PetMapperInterface
+	WithVersion(version int) PetMapperInterface
+	WithRole(role string) PetMapperInterface
 }
 
 type PetMapper struct {
 	DB *sqlx.DB
+	// Actor is recorded in the pet history as the author of mutations
+	Actor string
+	// Version is the version mutated pets are expected to have, zero disables the check
+	Version int
+	// Role decides which status transitions are allowed, empty means models.PetRoleSystem
+	Role string
 }
 
-func (m PetMapper) FindByID(id int) (*models.Pet, error) {
-	stmt := `
-		SELECT p.id, p.name, p.status, p.photo_urls, c.id, c.id, c.name, t.id, t.name  FROM pets p
-		    LEFT JOIN categories c ON p.category_id = c.id
-		    LEFT JOIN pet_tag pt ON p.id = pt.pet_id
-		    INNER JOIN tags t ON pt.tag_id = t.id
-		WHERE p.id = $1`
+func (m PetMapper) WithActor(actor string) PetMapperInterface {
+	m.Actor = actor
+	return m
+}
+
+func (m PetMapper) WithVersion(version int) PetMapperInterface {
+	m.Version = version
+	return m
+}
+
+func (m PetMapper) WithRole(role string) PetMapperInterface {
+	m.Role = role
+	return m
+}
+
+func (m PetMapper) role() string {
+	if m.Role == "" {
+		return models.PetRoleSystem
+	}
+	return m.Role
+}
+
+// checkTransition rejects status changes not allowed for the role of the mapper.
+func (m PetMapper) checkTransition(old *models.Pet, status string) error {
+	err := models.Pet{}.CheckTransition(old.Status, status, m.role())
+	if err != nil {
+		return ConflictError(fmt.Sprintf("pet %d: %v", old.ID, err))
+	}
+	return nil
+}
+
+// transitioned runs the status hooks after the locked pet status has been changed inside the transaction.
+func (m PetMapper) transitioned(txn *sqlx.Tx, old *models.Pet, status string) error {
+	if old.Status == status {
+		return nil
+	}
+	return runPetStatusHooks(txn, PetTransition{Pet: old, From: old.Status, To: status, Actor: m.Actor, Role: m.role()})
+}
 
+func (m PetMapper) checkVersion(p *models.Pet) error {
+	if m.Version != 0 && m.Version != p.Version {
+		return VersionMismatchError(fmt.Sprintf("pet %d has version %d, expected %d", p.ID, p.Version, m.Version))
+	}
+	return nil
+}
+
+// petColumns select a pet with its category, favorites count and tags aggregated into json,
+// so every pet is a single row
+const petColumns = `
+		p.id, p.name, p.status, p.species, p.breed, p.birth_date, p.sex, p.description, p.weight, p.price,
+		p.currency, p.photo_urls, p.images, p.version, p.deleted_at, COALESCE(c.id, 0), COALESCE(c.name, ''),
+		(SELECT count(*) FROM favorites f WHERE f.pet_id = p.id),
+		COALESCE((SELECT json_agg(json_build_object('id', t.id, 'name', t.name) ORDER BY t.id)
+		          FROM pet_tag pt INNER JOIN tags t ON pt.tag_id = t.id
+		          WHERE pt.pet_id = p.id), '[]')`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPet(row rowScanner) (*models.Pet, error) {
 	p := &models.Pet{}
-	rows, err := m.DB.Queryx(stmt, id)
+	var tags []byte
+	err := row.Scan(
+		&p.ID,
+		&p.Name,
+		&p.Status,
+		&p.Species,
+		&p.Breed,
+		&p.BirthDate,
+		&p.Sex,
+		&p.Description,
+		&p.Weight,
+		&p.Price,
+		&p.Currency,
+		pq.Array(&p.PhotoURLs),
+		&p.Images,
+		&p.Version,
+		&p.DeletedAt,
+		&p.Category.ID,
+		&p.Category.Name,
+		&p.Favorites,
+		&tags,
+	)
 	if err != nil {
-		return nil, errors.Wrap(err, "find pet by id error ")
+		return nil, err
 	}
-	tag := models.Tag{}
+	p.CategoryID = p.Category.ID
+	err = json.Unmarshal(tags, &p.Tags)
+	if err != nil {
+		return nil, errors.Wrap(err, "pet tags unmarshal error")
+	}
+	return p, nil
+}
+
+const insertPetStmt = `INSERT INTO pets ( name, status, species, breed, birth_date, sex, description, weight, price,
+                         currency, photo_urls, category_id) 
+			 VALUES (:name, :status, :species, :breed, :birth_date, :sex, :description, :weight, :price,
+			         :currency, :photo_urls, :category_id) RETURNING id;`
+
+// petParams are the named parameters of the pet columns written by Create and Update.
+func petParams(p *models.Pet) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        p.Name,
+		"status":      p.Status,
+		"species":     p.Species,
+		"breed":       p.Breed,
+		"birth_date":  p.BirthDate,
+		"sex":         p.Sex,
+		"description": p.Description,
+		"weight":      p.Weight,
+		"price":       p.Price,
+		"currency":    p.Currency,
+		"photo_urls":  pq.Array(p.PhotoURLs),
+	}
+}
+
+func insertPet(txn *sqlx.Tx, stmt string, params map[string]interface{}, p *models.Pet) error {
+	rows, err := txn.NamedQuery(stmt, params)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 	for rows.Next() {
-		err = rows.Scan(
-			&p.ID,
-			&p.Name,
-			&p.Status,
-			pq.Array(&p.PhotoURLs),
-			&p.CategoryID,
-			&p.Category.ID,
-			&p.Category.Name,
-			&tag.ID,
-			&tag.Name,
-		)
-		p.Tags = append(p.Tags, tag)
+		err = rows.Scan(&p.ID)
 		if err != nil {
-			return nil, errors.Wrap(err, "scan pet error ")
+			return err
 		}
 	}
-	logrus.Info(p)
+	return rows.Err()
+}
 
-	if p.ID == 0 {
-		return p, NotFoundError(fmt.Sprintf("Pet record have not found by id: %d", id))
+// snapshot loads and locks the pet for the rest of the transaction, deleted pets are not found.
+func (m PetMapper) snapshot(txn *sqlx.Tx, id int) (*models.Pet, error) {
+	return m.lock(txn, id, false)
+}
+
+// petLockStmt selects and locks either a live or a deleted pet
+const petLockStmt = `SELECT ` + petColumns + `
+		FROM pets p
+		    LEFT JOIN categories c ON p.category_id = c.id
+		WHERE p.id = $1 AND (p.deleted_at IS NOT NULL) = $2
+		FOR UPDATE OF p`
+
+// lock loads and locks either a live or a deleted pet.
+func (PetMapper) lock(txn *sqlx.Tx, id int, deleted bool) (*models.Pet, error) {
+	p, err := scanPet(txn.QueryRowx(petLockStmt, id, deleted))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError(fmt.Sprintf("Pet record have not found by id: %d", id))
+		}
+		return nil, errors.Wrap(err, "pet snapshot error")
 	}
+	return p, nil
+}
 
+// tryLock loads and locks a live pet like snapshot without waiting for it,
+// a ConflictError is returned when another transaction holds the pet.
+func (PetMapper) tryLock(txn *sqlx.Tx, id int) (*models.Pet, error) {
+	p, err := scanPet(txn.QueryRowx(petLockStmt+` NOWAIT`, id, false))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError(fmt.Sprintf("Pet record have not found by id: %d", id))
+		}
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pqLockNotAvailable {
+			return nil, ConflictError(fmt.Sprintf("pet %d is being changed, try again", id))
+		}
+		return nil, errors.Wrap(err, "pet snapshot error")
+	}
 	return p, nil
 }
 
+// recordHistory stores the change of the pet made inside the transaction, old is nil for created pets.
+// The change is published to webhooks as well.
+func (m PetMapper) recordHistory(txn *sqlx.Tx, petID int, action string, old *models.Pet) error {
+	var current *models.Pet
+	if action != models.PetActionDelete {
+		var err error
+		current, err = m.snapshot(txn, petID)
+		if err != nil {
+			return err
+		}
+	}
+	err := PetHistoryMapper{}.Record(txn, petID, action, old, current, m.Actor)
+	if err != nil {
+		return err
+	}
+	return publishPetChange(txn, action, old, current)
+}
+
+// petEvents are the webhook events of pet history actions
+var petEvents = map[string]string{
+	models.PetActionCreate:  models.EventPetCreated,
+	models.PetActionUpdate:  models.EventPetUpdated,
+	models.PetActionImages:  models.EventPetUpdated,
+	models.PetActionDelete:  models.EventPetDeleted,
+	models.PetActionRestore: models.EventPetRestored,
+}
+
+// publishPetChange publishes the pet as it is after the change, deleted pets as they were,
+// and a pet.status_changed event when the status has changed.
+func publishPetChange(txn *sqlx.Tx, action string, old, current *models.Pet) error {
+	pet := current
+	if pet == nil {
+		pet = old
+	}
+	err := publish(txn, petEvents[action], map[string]interface{}{"pet": pet})
+	if err != nil {
+		return err
+	}
+	if old == nil || current == nil || old.Status == current.Status {
+		return nil
+	}
+	return publish(txn, models.EventPetStatusChanged, map[string]interface{}{
+		"pet":  current,
+		"from": old.Status,
+		"to":   current.Status,
+	})
+}
+
+func (m PetMapper) FindByID(id int) (*models.Pet, error) {
+	stmt := `SELECT ` + petColumns + `
+		FROM pets p
+		    LEFT JOIN categories c ON p.category_id = c.id
+		WHERE p.id = $1 AND p.deleted_at IS NULL`
+	p, err := scanPet(m.DB.QueryRowx(stmt, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError(fmt.Sprintf("Pet record have not found by id: %d", id))
+		}
+		return nil, errors.Wrap(err, "find pet by id error ")
+	}
+	return p, nil
+}
+
+// Find returns pets matching the filter ordered by id.
+func (m PetMapper) Find(filter PetFilter) ([]*models.Pet, error) {
+	pets := []*models.Pet{}
+	err := m.Export(filter, func(p *models.Pet) error {
+		pets = append(pets, p)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "find pets error")
+	}
+	return pets, nil
+}
+
 func (m PetMapper) Create(p *models.Pet) error {
-	stmt := `INSERT INTO pets ( name, status, photo_urls, category_id) 
-			 VALUES (:name, :status, :photo_urls, :category_id) RETURNING id;`
+	stmt := insertPetStmt
 	txn, err := m.DB.Beginx()
 	defer func() {
 		if err = txn.Rollback(); err != sql.ErrTxDone {
@@ -92,12 +316,8 @@ func (m PetMapper) Create(p *models.Pet) error {
 
 	//main query
 	var petID int
-	params := map[string]interface{}{
-		"name":        p.Name,
-		"status":      p.Status,
-		"photo_urls":  pq.Array(p.PhotoURLs),
-		"category_id": category.ID,
-	}
+	params := petParams(p)
+	params["category_id"] = category.ID
 	rows, err := txn.NamedQuery(stmt, params)
 	if err != nil {
 		return errors.Wrap(err, "insert pet error")
@@ -109,6 +329,8 @@ func (m PetMapper) Create(p *models.Pet) error {
 		}
 	}
 	logrus.Infof("petID:%v", petID)
+	p.ID = petID
+	p.Version = 1
 
 	err = m.DissociateAllTags(txn, petID)
 	if err != nil {
@@ -120,15 +342,134 @@ func (m PetMapper) Create(p *models.Pet) error {
 		return errors.Wrap(err, "tag associate fail")
 	}
 
+	err = m.recordHistory(txn, petID, models.PetActionCreate, nil)
+	if err != nil {
+		return errors.Wrap(err, "pet history fail")
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	invalidateInventory()
+	return nil
+}
+
+// CreateMany inserts pets in a single transaction,
+// categories and tags of the whole batch are resolved with one query each.
+func (m PetMapper) CreateMany(pets []*models.Pet) error {
+	if len(pets) < 1 {
+		return nil
+	}
+	stmt := insertPetStmt
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
+
+	var categoryNames []string
+	var tags []models.Tag
+	seenCategories := map[string]bool{}
+	seenTags := map[string]bool{}
+	for _, p := range pets {
+		if !seenCategories[p.Category.Name] {
+			seenCategories[p.Category.Name] = true
+			categoryNames = append(categoryNames, p.Category.Name)
+		}
+		for _, tag := range p.Tags {
+			if !seenTags[tag.Name] {
+				seenTags[tag.Name] = true
+				tags = append(tags, models.Tag{Name: tag.Name})
+			}
+		}
+	}
+	categories, err := CategoryMapper{Tx: txn}.FindOrCreateMany(categoryNames)
+	if err != nil {
+		return errors.Wrap(err, "problem with categories")
+	}
+	categoryIDs := map[string]int{}
+	for _, category := range categories {
+		categoryIDs[category.Name] = category.ID
+	}
+	dbTags, err := TagMapper{Tx: txn}.FindOrCreateMany(tags)
+	if err != nil {
+		return errors.Wrap(err, "problem with tags")
+	}
+	tagsByName := map[string]models.Tag{}
+	for _, tag := range dbTags {
+		tagsByName[tag.Name] = tag
+	}
+
+	for _, p := range pets {
+		params := petParams(p)
+		params["category_id"] = categoryIDs[p.Category.Name]
+		err = insertPet(txn, stmt, params, p)
+		if err != nil {
+			return errors.Wrap(err, "insert pet error")
+		}
+		var petTags []models.Tag
+		associated := map[string]bool{}
+		for _, tag := range p.Tags {
+			if !associated[tag.Name] {
+				associated[tag.Name] = true
+				petTags = append(petTags, tagsByName[tag.Name])
+			}
+		}
+		err = m.AssociateTags(txn, p.ID, petTags)
+		if err != nil {
+			return errors.Wrap(err, "tag associate fail")
+		}
+		err = m.recordHistory(txn, p.ID, models.PetActionCreate, nil)
+		if err != nil {
+			return errors.Wrap(err, "pet history fail")
+		}
+		p.Version = 1
+	}
+
 	err = txn.Commit()
 	if err != nil {
 		return errors.Wrap(err, "Transaction commit fail")
 	}
+	invalidateInventory()
 	return nil
 }
 
+// Export calls fn for every pet matching the filter, ordered by id.
+// Pets are read one by one, so the catalog is never loaded into memory at once.
+func (m PetMapper) Export(filter PetFilter, fn func(*models.Pet) error) error {
+	where, args := filter.where()
+	stmt := `SELECT ` + petColumns + `
+		FROM pets p
+		    LEFT JOIN categories c ON p.category_id = c.id
+		` + where + `
+		ORDER BY p.id`
+	rows, err := m.DB.Queryx(stmt, args...)
+	if err != nil {
+		return errors.Wrap(err, "export pets error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p, err := scanPet(rows)
+		if err != nil {
+			return errors.Wrap(err, "scan pet error")
+		}
+		err = fn(p)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 func (m PetMapper) Update(p *models.Pet) error {
-	stmt := `UPDATE pets SET name=:name, status=:status, photo_urls=:photo_urls, category_id=:category_id
+	stmt := `UPDATE pets SET name=:name, status=:status, species=:species, breed=:breed, birth_date=:birth_date,
+                    sex=:sex, description=:description, weight=:weight, price=:price, currency=:currency,
+                    photo_urls=:photo_urls, category_id=:category_id, version=version+1
              WHERE id=:id`
 	txn, err := m.DB.Beginx()
 	defer func() {
@@ -140,6 +481,19 @@ func (m PetMapper) Update(p *models.Pet) error {
 		return errors.Wrap(err, "transaction open error")
 	}
 
+	old, err := m.snapshot(txn, p.ID)
+	if err != nil {
+		return err
+	}
+	err = m.checkVersion(old)
+	if err != nil {
+		return err
+	}
+	err = m.checkTransition(old, p.Status)
+	if err != nil {
+		return err
+	}
+
 	category, err := CategoryMapper{Tx: txn}.FindOrCreate(p.Category.Name)
 	if err != nil {
 		return errors.Wrap(err, "can not find category")
@@ -151,13 +505,9 @@ func (m PetMapper) Update(p *models.Pet) error {
 	}
 
 	// main query
-	params := map[string]interface{}{
-		"name":        p.Name,
-		"status":      p.Status,
-		"photo_urls":  pq.Array(p.PhotoURLs),
-		"category_id": category.ID,
-		"id":          p.ID,
-	}
+	params := petParams(p)
+	params["category_id"] = category.ID
+	params["id"] = p.ID
 	_, err = txn.NamedExec(stmt, params)
 	if err != nil {
 		return errors.Wrap(err, "db exec fail")
@@ -172,53 +522,206 @@ func (m PetMapper) Update(p *models.Pet) error {
 		return errors.Wrap(err, "tag associate fail")
 	}
 
+	err = m.transitioned(txn, old, p.Status)
+	if err != nil {
+		return err
+	}
+
+	err = m.recordHistory(txn, p.ID, models.PetActionUpdate, old)
+	if err != nil {
+		return errors.Wrap(err, "pet history fail")
+	}
+
 	err = txn.Commit()
 	if err != nil {
 		return errors.Wrap(err, "Transaction commit fail")
 	}
+	invalidateInventory()
+	p.Version = old.Version + 1
 
 	return nil
 }
 
-func (m PetMapper) Delete(id int) error {
-	stmt := `DELETE from pets WHERE id=$1`
-	_, err := m.DB.Exec(stmt, id)
-	return err
+// updateStatus changes the status of the pet locked by snapshot inside the transaction.
+func (m PetMapper) updateStatus(txn *sqlx.Tx, old *models.Pet, status string) error {
+	err := m.checkTransition(old, status)
+	if err != nil {
+		return err
+	}
+	_, err = txn.Exec(`UPDATE pets SET status=$1, version=version+1 WHERE id=$2`, status, old.ID)
+	if err != nil {
+		return errors.Wrap(err, "pet status update failed")
+	}
+	err = m.transitioned(txn, old, status)
+	if err != nil {
+		return err
+	}
+	return m.recordHistory(txn, old.ID, models.PetActionUpdate, old)
 }
 
-func (m PetMapper) FindByStatus(status string) ([]*models.Pet, error) {
-	stmt := `
-		 SELECT p.id, p.name, p.status, p.photo_urls, c.id, c.id, c.name, t.id, t.name  
-		 	FROM pets p
-		 	LEFT JOIN categories c ON p.category_id = c.id
-		 	LEFT JOIN pet_tag pt ON p.id = pt.pet_id
-		 	INNER JOIN tags t ON pt.tag_id = t.id
-		 WHERE status = $1`
+// FindAllImages returns images of every pet including the deleted ones, which can still be restored.
+func (m PetMapper) FindAllImages() ([]models.PetImages, error) {
+	var images []models.PetImages
+	err := m.DB.Select(&images, `SELECT images FROM pets`)
+	if err != nil {
+		return nil, errors.Wrap(err, "find all pet images error")
+	}
+	return images, nil
+}
 
-	rows, err := m.DB.Queryx(stmt, status)
+// UpdateImages replaces the images of the pet with the ones returned by update, which is called with the images
+// of the locked pet, so concurrent changes are not lost. Errors of update are returned as they are.
+func (m PetMapper) UpdateImages(petID int, update func(models.PetImages) (models.PetImages, error)) (models.PetImages, error) {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return nil, errors.Wrap(err, "transaction open error")
+	}
+
+	old, err := m.snapshot(txn, petID)
+	if err != nil {
+		return nil, err
+	}
+	err = m.checkVersion(old)
+	if err != nil {
+		return nil, err
+	}
+	images, err := update(old.Images)
 	if err != nil {
-		return nil, errors.Wrap(err, "find by tags error ")
+		return nil, err
 	}
-	return m.MapsRows(rows)
+	_, err = txn.Exec(`UPDATE pets SET images=$1, version=version+1 WHERE id=$2`, images, petID)
+	if err != nil {
+		return nil, errors.Wrap(err, "pet images update failed")
+	}
+	err = m.recordHistory(txn, petID, models.PetActionImages, old)
+	if err != nil {
+		return nil, errors.Wrap(err, "pet history fail")
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "Transaction commit fail")
+	}
+	return images, nil
 }
-func (m PetMapper) FindByTags(tags []string) ([]*models.Pet, error) {
-	stmt := `
-		 SELECT p.id, p.name, p.status, p.photo_urls, c.id, c.id, c.name, t.id, t.name  
-		 	FROM pets p
-		 	LEFT JOIN categories c ON p.category_id = c.id
-		 	LEFT JOIN pet_tag pt ON p.id = pt.pet_id
-		 	INNER JOIN tags t ON pt.tag_id = t.id
-		 WHERE $1 <@  array(SELECT t.name 
-		 						FROM pets  
-		     					LEFT JOIN pet_tag pt ON p.id = pt.pet_id
-		     					INNER JOIN tags t ON pt.tag_id = t.id)`
 
-	rows, err := m.DB.Queryx(stmt, pq.Array(tags))
+// Delete moves the pet to the trash, it is kept with its tags and images until Purge.
+func (m PetMapper) Delete(id int) error {
+	stmt := `UPDATE pets SET deleted_at=now(), version=version+1 WHERE id=$1`
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
 	if err != nil {
-		return nil, errors.Wrap(err, "find by tags error ")
+		return errors.Wrap(err, "transaction open error")
 	}
-	return m.MapsRows(rows)
 
+	old, err := m.snapshot(txn, id)
+	if err != nil {
+		return err
+	}
+	err = m.checkVersion(old)
+	if err != nil {
+		return err
+	}
+	_, err = txn.Exec(stmt, id)
+	if err != nil {
+		return errors.Wrap(err, "pet delete failed")
+	}
+	err = m.recordHistory(txn, id, models.PetActionDelete, old)
+	if err != nil {
+		return errors.Wrap(err, "pet history fail")
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	invalidateInventory()
+	return nil
+}
+
+// FindDeleted returns pets in the trash, the most recently deleted first.
+func (m PetMapper) FindDeleted() ([]*models.Pet, error) {
+	stmt := `SELECT ` + petColumns + `
+		FROM pets p
+		    LEFT JOIN categories c ON p.category_id = c.id
+		WHERE p.deleted_at IS NOT NULL
+		ORDER BY p.deleted_at DESC, p.id`
+	rows, err := m.DB.Queryx(stmt)
+	if err != nil {
+		return nil, errors.Wrap(err, "find deleted pets error")
+	}
+	defer rows.Close()
+	pets := []*models.Pet{}
+	for rows.Next() {
+		p, err := scanPet(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "scan pet error")
+		}
+		pets = append(pets, p)
+	}
+	return pets, rows.Err()
+}
+
+// Restore takes the pet out of the trash.
+func (m PetMapper) Restore(id int) error {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
+
+	old, err := m.lock(txn, id, true)
+	if err != nil {
+		return err
+	}
+	err = m.checkVersion(old)
+	if err != nil {
+		return err
+	}
+	_, err = txn.Exec(`UPDATE pets SET deleted_at=NULL, version=version+1 WHERE id=$1`, id)
+	if err != nil {
+		return errors.Wrap(err, "pet restore failed")
+	}
+	err = m.recordHistory(txn, id, models.PetActionRestore, old)
+	if err != nil {
+		return errors.Wrap(err, "pet history fail")
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	invalidateInventory()
+	return nil
+}
+
+// Purge permanently removes pets deleted before the given time and returns them, so their images can be removed.
+// Pets still referenced by orders stay in the trash to keep the sales history.
+func (m PetMapper) Purge(deletedBefore time.Time) ([]*models.Pet, error) {
+	stmt := `DELETE FROM pets p
+			 WHERE p.deleted_at < $1 AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.pet_id = p.id)
+			       AND NOT EXISTS (SELECT 1 FROM order_items i WHERE i.pet_id = p.id)
+			 RETURNING p.id, p.images`
+	pets := []*models.Pet{}
+	err := m.DB.Select(&pets, stmt, deletedBefore)
+	if err != nil {
+		return nil, errors.Wrap(err, "purge pets error")
+	}
+	invalidateInventory()
+	return pets, nil
 }
 
 func (m PetMapper) AssociateTags(txn *sqlx.Tx, petID int, tags []models.Tag) error {
@@ -244,38 +747,3 @@ func (m PetMapper) DissociateAllTags(txn *sqlx.Tx, petID int) error {
 	_, err := txn.Exec(`DELETE FROM pet_tag where pet_id=$1`, petID)
 	return err
 }
-
-func (PetMapper) MapsRows(rows *sqlx.Rows) ([]*models.Pet, error) {
-	p := &models.Pet{}
-	tag := models.Tag{}
-	petsMap := map[int]*models.Pet{}
-	for rows.Next() {
-		err := rows.Scan(
-			&p.ID,
-			&p.Name,
-			&p.Status,
-			pq.Array(&p.PhotoURLs),
-			&p.CategoryID,
-			&p.Category.ID,
-			&p.Category.Name,
-			&tag.ID,
-			&tag.Name,
-		)
-		if err != nil {
-			return nil, errors.Wrap(err, "scan pet error ")
-		}
-
-		_, present := petsMap[p.ID]
-		if !present {
-			pet := *p
-			petsMap[p.ID] = &pet
-		}
-		petsMap[p.ID].Tags = append(petsMap[p.ID].Tags, tag)
-
-	}
-	var pets = make([]*models.Pet, 0, len(petsMap))
-	for _, pet := range petsMap {
-		pets = append(pets, pet)
-	}
-	return pets, nil
-}
@@ -0,0 +1,77 @@
+package mappers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+// PetFilter narrows pet listings, empty fields match every pet which is not deleted.
+// Conditions refer to the pets table as p and categories as c.
+type PetFilter struct {
+	Status   string
+	Category string
+	// Tags pets must have all of
+	Tags     []string
+	Species  string
+	Breed    string
+	Sex      string
+	Currency string
+	MinPrice *models.Decimal
+	MaxPrice *models.Decimal
+	// MinAge and MaxAge are inclusive bounds in whole years, pets without a birth date never match them
+	MinAge *int
+	MaxAge *int
+}
+
+func (f PetFilter) where() (string, []interface{}) {
+	conditions := []string{"p.deleted_at IS NULL"}
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.Status != "" {
+		add("p.status = $%d", f.Status)
+	}
+	if f.Category != "" {
+		add("c.name = $%d", f.Category)
+	}
+	if len(f.Tags) > 0 {
+		add(`$%d <@ array(SELECT t.name FROM pet_tag pt INNER JOIN tags t ON pt.tag_id = t.id
+		                  WHERE pt.pet_id = p.id)`, pq.Array(f.Tags))
+	}
+
+	if f.Species != "" {
+		add("p.species = $%d", f.Species)
+	}
+	if f.Breed != "" {
+		add("p.breed = $%d", f.Breed)
+	}
+	if f.Sex != "" {
+		add("p.sex = $%d", f.Sex)
+	}
+	if f.Currency != "" {
+		add("p.currency = $%d", f.Currency)
+	}
+	if f.MinPrice != nil {
+		add("p.price >= $%d", *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		add("p.price <= $%d", *f.MaxPrice)
+	}
+	today := time.Now().UTC()
+	if f.MinAge != nil {
+		add("p.birth_date <= $%d", models.NewDate(today.Year()-*f.MinAge, today.Month(), today.Day()))
+	}
+	if f.MaxAge != nil {
+		add("p.birth_date > $%d", models.NewDate(today.Year()-*f.MaxAge-1, today.Month(), today.Day()))
+	}
+
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
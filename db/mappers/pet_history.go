@@ -0,0 +1,142 @@
+package mappers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+type PetHistoryMapperInterface interface {
+	FindByPetID(petID int) ([]*models.PetHistory, error)
+	FindPetAsOf(petID int, asOf time.Time) (*models.Pet, error)
+}
+
+type PetHistoryMapper struct {
+	DB *sqlx.DB
+}
+
+type petHistoryRow struct {
+	ID        int       `db:"id"`
+	PetID     int       `db:"pet_id"`
+	Action    string    `db:"action"`
+	OldValues []byte    `db:"old_values"`
+	NewValues []byte    `db:"new_values"`
+	Actor     string    `db:"actor"`
+	ChangedAt time.Time `db:"changed_at"`
+}
+
+func (row petHistoryRow) toModel() (*models.PetHistory, error) {
+	h := &models.PetHistory{
+		ID:        row.ID,
+		PetID:     row.PetID,
+		Action:    row.Action,
+		Actor:     row.Actor,
+		ChangedAt: row.ChangedAt,
+	}
+	var err error
+	h.OldValues, err = unmarshalPetSnapshot(row.OldValues)
+	if err != nil {
+		return nil, err
+	}
+	h.NewValues, err = unmarshalPetSnapshot(row.NewValues)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func unmarshalPetSnapshot(data []byte) (*models.Pet, error) {
+	if data == nil {
+		return nil, nil
+	}
+	pet := &models.Pet{}
+	err := json.Unmarshal(data, pet)
+	if err != nil {
+		return nil, errors.Wrap(err, "pet snapshot unmarshal error")
+	}
+	return pet, nil
+}
+
+func marshalPetSnapshot(pet *models.Pet) ([]byte, error) {
+	if pet == nil {
+		return nil, nil
+	}
+	return json.Marshal(pet)
+}
+
+// Record stores a pet mutation inside the transaction which performs it.
+func (PetHistoryMapper) Record(txn *sqlx.Tx, petID int, action string, oldPet, newPet *models.Pet, actor string) error {
+	oldValues, err := marshalPetSnapshot(oldPet)
+	if err != nil {
+		return errors.Wrap(err, "pet snapshot marshal error")
+	}
+	newValues, err := marshalPetSnapshot(newPet)
+	if err != nil {
+		return errors.Wrap(err, "pet snapshot marshal error")
+	}
+	stmt := `INSERT INTO pet_history (pet_id, action, old_values, new_values, actor)
+			 VALUES ($1, $2, $3, $4, $5)`
+	_, err = txn.Exec(stmt, petID, action, oldValues, newValues, actor)
+	if err != nil {
+		return errors.Wrap(err, "pet history insert error")
+	}
+	return nil
+}
+
+func (m PetHistoryMapper) FindByPetID(petID int) ([]*models.PetHistory, error) {
+	var rows []petHistoryRow
+	stmt := `SELECT * FROM pet_history WHERE pet_id=$1 ORDER BY changed_at, id`
+	err := m.DB.Select(&rows, stmt, petID)
+	if err != nil {
+		return nil, errors.Wrap(err, "find pet history error")
+	}
+	history := make([]*models.PetHistory, 0, len(rows))
+	for _, row := range rows {
+		h, err := row.toModel()
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, h)
+	}
+	return history, nil
+}
+
+// FindPetAsOf rebuilds the pet as it was at the given time.
+// The last change made until then holds the state, without one the state before the first later change is used.
+// A pet without any recorded change is returned as it is now.
+func (m PetHistoryMapper) FindPetAsOf(petID int, asOf time.Time) (*models.Pet, error) {
+	notFound := NotFoundError(fmt.Sprintf("Pet record have not existed by id: %d at %v", petID, asOf))
+
+	row := petHistoryRow{}
+	stmt := `SELECT * FROM pet_history WHERE pet_id=$1 AND changed_at <= $2 ORDER BY changed_at DESC, id DESC LIMIT 1`
+	err := m.DB.Get(&row, stmt, petID, asOf)
+	if err == nil {
+		if row.Action == models.PetActionDelete {
+			return nil, notFound
+		}
+		return unmarshalPetSnapshot(row.NewValues)
+	}
+	if err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "find pet history error")
+	}
+
+	stmt = `SELECT * FROM pet_history WHERE pet_id=$1 AND changed_at > $2 ORDER BY changed_at, id LIMIT 1`
+	err = m.DB.Get(&row, stmt, petID, asOf)
+	if err == nil {
+		if row.Action == models.PetActionCreate || row.Action == models.PetActionRestore {
+			return nil, notFound
+		}
+		return unmarshalPetSnapshot(row.OldValues)
+	}
+	if err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "find pet history error")
+	}
+
+	return PetMapper{DB: m.DB}.FindByID(petID)
+}
@@ -0,0 +1,169 @@
+package mappers
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+// PetTransition is a pet status change made inside a transaction.
+type PetTransition struct {
+	// Pet is the state before the change
+	Pet   *models.Pet
+	From  string
+	To    string
+	Actor string
+	Role  string
+}
+
+// PetStatusHook runs inside the transaction changing the status,
+// returned errors roll the whole change back.
+type PetStatusHook func(txn *sqlx.Tx, t PetTransition) error
+
+type petStatusHook struct {
+	from string
+	to   string
+	hook PetStatusHook
+}
+
+var petStatusHooks []petStatusHook
+
+// OnPetStatus registers the hook for transitions from one status to another,
+// an empty status matches any. Hooks are registered on start up and run in registration order.
+func OnPetStatus(from, to string, hook PetStatusHook) {
+	petStatusHooks = append(petStatusHooks, petStatusHook{from: from, to: to, hook: hook})
+}
+
+func runPetStatusHooks(txn *sqlx.Tx, t PetTransition) error {
+	for _, h := range petStatusHooks {
+		if (h.from == "" || h.from == t.From) && (h.to == "" || h.to == t.To) {
+			err := h.hook(txn, t)
+			if _, ok := err.(ConflictError); ok {
+				return err
+			}
+			if err != nil {
+				return errors.Wrapf(err, "pet status hook %v -> %v failed", t.From, t.To)
+			}
+		}
+	}
+	return nil
+}
+
+// CancelOpenOrders cancels placed and approved orders having a line of the pet
+// and returns their other pending pets to sale, paid orders get a credit note.
+// Other pets of the orders are locked before the orders, the same order OrderMapper takes. The changed pet
+// is already locked by the caller, so pets of lower ids, which OrderMapper locks before it, are not waited for,
+// a ConflictError is returned when one of them is held and the change can be tried again.
+func CancelOpenOrders(txn *sqlx.Tx, t PetTransition) error {
+	var petIDs []int
+	stmt := `SELECT DISTINCT i.pet_id FROM order_items i
+			 WHERE i.order_id IN (SELECT o.id FROM orders o
+			                      INNER JOIN order_items oi ON oi.order_id = o.id
+			                      WHERE oi.pet_id = $1 AND o.status IN ($2, $3) AND o.deleted_at IS NULL)
+			 ORDER BY i.pet_id`
+	err := txn.Select(&petIDs, stmt, t.Pet.ID, models.OrderStatusPlaced, models.OrderStatusApproved)
+	if err != nil {
+		return errors.Wrap(err, "find pets of open orders error")
+	}
+	if len(petIDs) == 0 {
+		return nil
+	}
+	petMapper := PetMapper{Actor: t.Actor}
+	pets := make(map[int]*models.Pet, len(petIDs))
+	for _, petID := range petIDs {
+		if petID == t.Pet.ID {
+			continue
+		}
+		var pet *models.Pet
+		if petID < t.Pet.ID {
+			pet, err = petMapper.tryLock(txn, petID)
+		} else {
+			pet, err = petMapper.snapshot(txn, petID)
+		}
+		if _, ok := err.(NotFoundError); ok {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		pets[petID] = pet
+	}
+
+	var orderIDs []int
+	stmt = `UPDATE orders SET status=$1, version=version+1, updated_at=now()
+			WHERE status IN ($3, $4) AND deleted_at IS NULL
+			      AND id IN (SELECT order_id FROM order_items WHERE pet_id=$2)
+			RETURNING id`
+	err = txn.Select(&orderIDs, stmt, models.OrderStatusCancelled, t.Pet.ID, models.OrderStatusPlaced, models.OrderStatusApproved)
+	if err != nil {
+		return errors.Wrap(err, "cancel open orders error")
+	}
+	if len(orderIDs) == 0 {
+		return nil
+	}
+	logrus.Infof("%d open orders of pet %d cancelled on %v -> %v", len(orderIDs), t.Pet.ID, t.From, t.To)
+	note := fmt.Sprintf("pet %d became %v", t.Pet.ID, t.To)
+	for _, orderID := range orderIDs {
+		err = OrderEventMapper{}.Record(txn, orderID, models.OrderEventCancelled, t.Actor, note)
+		if err != nil {
+			return err
+		}
+	}
+	err = creditCancelledOrders(txn, orderIDs, t.Actor)
+	if err != nil {
+		return err
+	}
+
+	// pets of orders which have been closed meanwhile are locked, but stay as they are
+	var releasedIDs []int
+	stmt = `SELECT DISTINCT pet_id FROM order_items WHERE order_id = ANY($1) AND pet_id <> $2 ORDER BY pet_id`
+	err = txn.Select(&releasedIDs, stmt, pq.Array(orderIDs), t.Pet.ID)
+	if err != nil {
+		return errors.Wrap(err, "find pets of cancelled orders error")
+	}
+	for _, petID := range releasedIDs {
+		pet := pets[petID]
+		if pet == nil || pet.Status != models.PetStatusPending {
+			continue
+		}
+		err = petMapper.updateStatus(txn, pet, models.PetStatusAvailable)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseReservations returns a hook cancelling the active reservation of the pet
+// and notifying its holder. Reservations release themselves before changing the pet,
+// so the hook only acts on changes made outside of them.
+func ReleaseReservations(notify func(user *models.User, subject, message string) error) PetStatusHook {
+	return func(txn *sqlx.Tx, t PetTransition) error {
+		var reservations []*models.Reservation
+		stmt := `UPDATE reservations SET status=$1, released_at=now()
+				 WHERE pet_id=$2 AND status=$3
+				 RETURNING *`
+		err := txn.Select(&reservations, stmt, models.ReservationCancelled, t.Pet.ID, models.ReservationActive)
+		if err != nil {
+			return errors.Wrap(err, "release reservations error")
+		}
+		for _, reservation := range reservations {
+			user := &models.User{}
+			err = txn.Get(user, "SELECT * FROM users WHERE id=$1", reservation.UserID)
+			if err != nil {
+				return errors.Wrap(err, "find reservation holder error")
+			}
+			message := fmt.Sprintf("Your reservation of pet %v was cancelled because the pet became %v.", t.Pet.Name, t.To)
+			err = notify(user, "Reservation cancelled", message)
+			if err != nil {
+				logrus.Error("reservation holder notification failed: ", err)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,219 @@
+package mappers
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+// reservationActor is recorded in the pet history for changes made by the expiry worker
+const reservationActor = "reservation-expiry"
+
+type ReservationMapperInterface interface {
+	FindByID(id int) (*models.Reservation, error)
+	FindByUserID(userID int) ([]*models.Reservation, error)
+	Create(r *models.Reservation, holdTime time.Duration) error
+	Cancel(id int) (*models.Reservation, error)
+	ReleaseExpired() ([]*models.Reservation, error)
+	WithActor(actor string) ReservationMapperInterface
+}
+
+type ReservationMapper struct {
+	DB *sqlx.DB
+	// Actor is recorded in the pet history as the author of pet status changes
+	Actor string
+}
+
+func (m ReservationMapper) WithActor(actor string) ReservationMapperInterface {
+	m.Actor = actor
+	return m
+}
+
+func (m ReservationMapper) FindByID(id int) (*models.Reservation, error) {
+	reservation := &models.Reservation{}
+	err := m.DB.Get(reservation, "SELECT * FROM reservations WHERE id=$1", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError("reservation not found")
+		}
+		return nil, err
+	}
+	return reservation, nil
+}
+
+func (m ReservationMapper) FindByUserID(userID int) ([]*models.Reservation, error) {
+	reservations := []*models.Reservation{}
+	err := m.DB.Select(&reservations, "SELECT * FROM reservations WHERE user_id=$1 ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "find reservations error")
+	}
+	return reservations, nil
+}
+
+// Create holds an available pet for holdTime and moves it to pending.
+// The pet row stays locked until commit, so two customers cannot reserve the same pet.
+func (m ReservationMapper) Create(r *models.Reservation, holdTime time.Duration) error {
+	stmt := `INSERT INTO reservations (pet_id, user_id, status, expires_at)
+			 VALUES ($1, $2, $3, now() + $4 * interval '1 second')
+			 RETURNING id, created_at, expires_at`
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
+
+	petMapper := PetMapper{DB: m.DB, Actor: m.Actor}
+	pet, err := petMapper.snapshot(txn, r.PetID)
+	if err != nil {
+		return err
+	}
+	if pet.Status != models.PetStatusAvailable {
+		return ConflictError(fmt.Sprintf("pet %d is %v and cannot be reserved", pet.ID, pet.Status))
+	}
+
+	r.Status = models.ReservationActive
+	err = txn.QueryRowx(stmt, r.PetID, r.UserID, r.Status, int64(holdTime.Seconds())).
+		Scan(&r.ID, &r.CreatedAt, &r.ExpiresAt)
+	if err != nil {
+		return errors.Wrap(err, "insert reservation error")
+	}
+	err = petMapper.updateStatus(txn, pet, models.PetStatusPending)
+	if err != nil {
+		return err
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	invalidateInventory()
+	return nil
+}
+
+// Cancel releases an active reservation and makes its pet available again.
+func (m ReservationMapper) Cancel(id int) (*models.Reservation, error) {
+	reservation, err := m.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if reservation.Status != models.ReservationActive {
+		return nil, ConflictError(fmt.Sprintf("reservation %d is already %v", id, reservation.Status))
+	}
+	err = m.release(reservation, models.ReservationCancelled, m.Actor, false)
+	if err != nil {
+		return nil, err
+	}
+	invalidateInventory()
+	return reservation, nil
+}
+
+// ReleaseExpired marks every active reservation past its expiry as expired and returns their pets to available.
+// Every reservation is released in its own transaction, so a failing one does not keep the others,
+// reservations released meanwhile, e.g. by other replicas, are skipped.
+func (m ReservationMapper) ReleaseExpired() ([]*models.Reservation, error) {
+	var reservations []*models.Reservation
+	stmt := `SELECT * FROM reservations WHERE status=$1 AND expires_at <= now() ORDER BY id`
+	err := m.DB.Select(&reservations, stmt, models.ReservationActive)
+	if err != nil {
+		return nil, errors.Wrap(err, "find expired reservations error")
+	}
+	released := []*models.Reservation{}
+	for _, reservation := range reservations {
+		err = m.release(reservation, models.ReservationExpired, reservationActor, true)
+		if _, ok := err.(ConflictError); ok {
+			continue
+		}
+		if err != nil {
+			logrus.Errorf("expired reservation %d release error: %v", reservation.ID, err)
+			continue
+		}
+		released = append(released, reservation)
+	}
+	if len(released) > 0 {
+		invalidateInventory()
+	}
+	return released, nil
+}
+
+// release closes the reservation with the given status if it is still active, and expired when expired is set,
+// otherwise a ConflictError is returned. Its pet goes back to available unless it has moved on from pending
+// or has been deleted meanwhile. The pet is locked before the reservation, the same way reservations, orders
+// and pet status hooks do, so they cannot deadlock. The status the pet is left in is set to the PetStatus
+// of the reservation.
+func (m ReservationMapper) release(r *models.Reservation, status, actor string, expired bool) error {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
+
+	petMapper := PetMapper{DB: m.DB, Actor: actor}
+	pet, err := petMapper.snapshot(txn, r.PetID)
+	if _, ok := err.(NotFoundError); ok {
+		// the pet has been deleted meanwhile
+		pet = nil
+	} else if err != nil {
+		return err
+	}
+	stmt := `UPDATE reservations SET status=$1, released_at=now()
+			 WHERE id=$2 AND status=$3 AND (NOT $4 OR expires_at <= now())
+			 RETURNING *`
+	err = txn.Get(r, stmt, status, r.ID, models.ReservationActive, expired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ConflictError(fmt.Sprintf("reservation %d is no longer active", r.ID))
+		}
+		return errors.Wrap(err, "reservation release error")
+	}
+
+	r.PetStatus = ""
+	if pet != nil {
+		r.PetStatus = pet.Status
+		if pet.Status == models.PetStatusPending {
+			r.PetStatus = models.PetStatusAvailable
+			err = petMapper.updateStatus(txn, pet, models.PetStatusAvailable)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	return nil
+}
+
+// fulfill closes the active reservations the user holds on the locked pets and returns the ids of their pets,
+// the pets stay pending for the order placed by the holder in the transaction.
+func (m ReservationMapper) fulfill(txn *sqlx.Tx, petIDs []int, userID int) (map[int]bool, error) {
+	var reserved []int
+	stmt := `UPDATE reservations SET status=$1, released_at=now()
+			 WHERE pet_id = ANY($2) AND user_id=$3 AND status=$4 AND expires_at > now()
+			 RETURNING pet_id`
+	err := txn.Select(&reserved, stmt, models.ReservationFulfilled, pq.Array(petIDs), userID, models.ReservationActive)
+	if err != nil {
+		return nil, errors.Wrap(err, "fulfill reservations error")
+	}
+	pets := make(map[int]bool, len(reserved))
+	for _, petID := range reserved {
+		pets[petID] = true
+	}
+	return pets, nil
+}
@@ -0,0 +1,341 @@
+package mappers
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+type ReturnMapperInterface interface {
+	FindByID(id int) (*models.Return, error)
+	Find(status string, userID *int) ([]*models.Return, error)
+	FindByOrderID(orderID int) ([]*models.Return, error)
+	Create(r *models.Return) error
+	Approve(id int, resolution string) (*models.Return, error)
+	Reject(id int, resolution string) (*models.Return, error)
+	MarkRefunded(id int, paymentID *int) (*models.Return, error)
+	WithActor(actor string) ReturnMapperInterface
+}
+
+type ReturnMapper struct {
+	DB *sqlx.DB
+	// Actor is recorded on the order timeline and in the history of restocked pets
+	Actor string
+}
+
+func (m ReturnMapper) WithActor(actor string) ReturnMapperInterface {
+	m.Actor = actor
+	return m
+}
+
+// returnColumns select a return with its lines aggregated into json and the id of its credit note
+const returnColumns = `r.*,
+		(SELECT c.id FROM credit_notes c WHERE c.return_id = r.id) AS credit_note_id,
+		COALESCE((SELECT json_agg(json_build_object('petId', i.pet_id, 'quantity', i.quantity, 'amount', i.amount)
+		                          ORDER BY i.pet_id)
+		          FROM return_items i WHERE i.return_id = r.id), '[]') AS items`
+
+func (m ReturnMapper) FindByID(id int) (*models.Return, error) {
+	r := &models.Return{}
+	err := m.DB.Get(r, `SELECT `+returnColumns+` FROM returns r WHERE r.id=$1`, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError(fmt.Sprintf("return %d not found", id))
+		}
+		return nil, errors.Wrap(err, "find return error")
+	}
+	return r, nil
+}
+
+// Find returns returns with the status, of the user when userID is set, the latest first.
+// An empty status matches any.
+func (m ReturnMapper) Find(status string, userID *int) ([]*models.Return, error) {
+	returns := []*models.Return{}
+	stmt := `SELECT ` + returnColumns + ` FROM returns r
+			 WHERE ($1 = '' OR r.status = $1) AND ($2::int IS NULL OR r.user_id = $2)
+			 ORDER BY r.created_at DESC, r.id DESC`
+	err := m.DB.Select(&returns, stmt, status, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "find returns error")
+	}
+	return returns, nil
+}
+
+func (m ReturnMapper) FindByOrderID(orderID int) ([]*models.Return, error) {
+	returns := []*models.Return{}
+	err := m.DB.Select(&returns, `SELECT `+returnColumns+` FROM returns r WHERE r.order_id=$1 ORDER BY r.id`, orderID)
+	if err != nil {
+		return nil, errors.Wrap(err, "find returns error")
+	}
+	return returns, nil
+}
+
+// Create requests the return of lines of a delivered order, no items mean every line not returned yet.
+// The refund due shares the order discount among the lines, the last return gets what is left of the total.
+func (m ReturnMapper) Create(r *models.Return) error {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
+
+	order := &models.Order{}
+	err = txn.Get(order, `SELECT * FROM orders WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`, r.OrderID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return NotFoundError(fmt.Sprintf("order %d not found", r.OrderID))
+		}
+		return errors.Wrap(err, "find order error")
+	}
+	if order.Status != models.OrderStatusDelivered {
+		return ConflictError(fmt.Sprintf("order %d is %v, only delivered orders can be returned", order.ID, order.Status))
+	}
+	var lines []*models.OrderItem
+	err = txn.Select(&lines, `SELECT * FROM order_items WHERE order_id=$1 ORDER BY id`, order.ID)
+	if err != nil {
+		return errors.Wrap(err, "find order items error")
+	}
+	var returnedPets []int64
+	var returnedAmount models.Decimal
+	stmt := `SELECT COALESCE(array_agg(i.pet_id), '{}') AS pet_ids, COALESCE(sum(i.amount), 0) AS amount
+			 FROM return_items i INNER JOIN returns r ON i.return_id = r.id
+			 WHERE r.order_id=$1 AND r.status <> $2`
+	err = txn.QueryRowx(stmt, order.ID, models.ReturnStatusRejected).Scan(pq.Array(&returnedPets), &returnedAmount)
+	if err != nil {
+		return errors.Wrap(err, "find returned items error")
+	}
+	isReturned := make(map[int]bool, len(returnedPets))
+	for _, id := range returnedPets {
+		isReturned[int(id)] = true
+	}
+	remaining := make(map[int]*models.OrderItem, len(lines))
+	for _, line := range lines {
+		if !isReturned[line.PetID] {
+			remaining[line.PetID] = line
+		}
+	}
+
+	if len(r.Items) == 0 {
+		for _, line := range lines {
+			if remaining[line.PetID] != nil {
+				r.Items = append(r.Items, &models.ReturnItem{PetID: line.PetID})
+			}
+		}
+		if len(r.Items) == 0 {
+			return ConflictError(fmt.Sprintf("every pet of order %d has been returned", order.ID))
+		}
+	}
+	sort.Slice(r.Items, func(i, j int) bool {
+		return r.Items[i].PetID < r.Items[j].PetID
+	})
+	returnedLines := make([]*models.OrderItem, len(r.Items))
+	for i, item := range r.Items {
+		line, ok := remaining[item.PetID]
+		if !ok {
+			if isReturned[item.PetID] {
+				return ConflictError(fmt.Sprintf("pet %d of order %d has been returned", item.PetID, order.ID))
+			}
+			return models.ValidationError(fmt.Sprintf("order %d has no pet %d", order.ID, item.PetID))
+		}
+		item.Quantity = line.Quantity
+		returnedLines[i] = line
+	}
+	r.Price(order, returnedLines, len(r.Items) == len(remaining), returnedAmount)
+
+	r.Status = models.ReturnStatusRequested
+	r.Currency = order.Currency
+	stmt = `INSERT INTO returns (order_id, user_id, status, reason, amount, currency)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at, updated_at`
+	err = txn.QueryRowx(stmt, r.OrderID, r.UserID, r.Status, r.Reason, r.Amount, r.Currency).
+		Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return errors.Wrap(err, "insert return error")
+	}
+	for _, item := range r.Items {
+		_, err = txn.Exec(`INSERT INTO return_items (return_id, pet_id, quantity, amount) VALUES ($1, $2, $3, $4)`,
+			r.ID, item.PetID, item.Quantity, item.Amount)
+		if err != nil {
+			return errors.Wrap(err, "insert return item error")
+		}
+	}
+	err = OrderEventMapper{}.Record(txn, r.OrderID, models.OrderEventReturnRequested, m.Actor,
+		fmt.Sprintf("return %d of %v %v: %v", r.ID, r.Amount, r.Currency, r.Reason))
+	if err != nil {
+		return err
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	return nil
+}
+
+// Approve accepts the requested return, its sold pets go back to sale and a credit note of the amount is issued.
+// Pets deleted or put on sale meanwhile are left as they are.
+func (m ReturnMapper) Approve(id int, resolution string) (*models.Return, error) {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return nil, errors.Wrap(err, "transaction open error")
+	}
+
+	// pets are locked before the return, the same way orders do
+	var petIDs []int
+	err = txn.Select(&petIDs, `SELECT pet_id FROM return_items WHERE return_id=$1 ORDER BY pet_id`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "find return pets error")
+	}
+	petMapper := PetMapper{DB: m.DB, Actor: m.Actor, Role: models.PetRoleAdmin}
+	pets, err := lockPets(txn, petMapper, petIDs, true)
+	if err != nil {
+		return nil, err
+	}
+	r, err := m.lock(txn, id, models.ReturnStatusRequested)
+	if err != nil {
+		return nil, err
+	}
+	for _, petID := range petIDs {
+		pet := pets[petID]
+		if pet == nil || pet.Status != models.PetStatusSold {
+			logrus.Warnf("returned pet %d is not restocked, it is deleted or not sold", petID)
+			continue
+		}
+		err = petMapper.updateStatus(txn, pet, models.PetStatusAvailable)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stmt := `UPDATE returns SET status=$1, resolution=$2, resolved_at=now(), updated_at=now() WHERE id=$3`
+	_, err = txn.Exec(stmt, models.ReturnStatusApproved, resolution, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "update return error")
+	}
+	err = OrderEventMapper{}.Record(txn, r.OrderID, models.OrderEventReturnApproved, m.Actor,
+		fmt.Sprintf("return %d: %v", id, resolution))
+	if err != nil {
+		return nil, err
+	}
+	note := &models.CreditNote{
+		OrderID:  r.OrderID,
+		ReturnID: &r.ID,
+		Amount:   r.Amount,
+		Currency: r.Currency,
+		Reason:   fmt.Sprintf("return %d", r.ID),
+	}
+	err = CreditNoteMapper{}.issue(txn, note, m.Actor)
+	if err != nil {
+		return nil, err
+	}
+	err = txn.Get(r, `SELECT `+returnColumns+` FROM returns r WHERE r.id=$1`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "find return error")
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "Transaction commit fail")
+	}
+	invalidateInventory()
+	return r, nil
+}
+
+// Reject refuses the requested return, its pets may be returned again later.
+func (m ReturnMapper) Reject(id int, resolution string) (*models.Return, error) {
+	return m.resolve(id, models.ReturnStatusRequested, func(txn *sqlx.Tx, r *models.Return) error {
+		stmt := `UPDATE returns SET status=$1, resolution=$2, resolved_at=now(), updated_at=now() WHERE id=$3`
+		_, err := txn.Exec(stmt, models.ReturnStatusRejected, resolution, id)
+		if err != nil {
+			return errors.Wrap(err, "update return error")
+		}
+		return OrderEventMapper{}.Record(txn, r.OrderID, models.OrderEventReturnRejected, m.Actor,
+			fmt.Sprintf("return %d: %v", id, resolution))
+	})
+}
+
+// MarkRefunded completes the approved return once its amount is back with the customer,
+// paymentID is the refunded payment, nil for refunds made outside of the payment provider.
+func (m ReturnMapper) MarkRefunded(id int, paymentID *int) (*models.Return, error) {
+	return m.resolve(id, models.ReturnStatusApproved, func(txn *sqlx.Tx, r *models.Return) error {
+		return m.markRefunded(txn, r, paymentID)
+	})
+}
+
+// markRefunded completes the approved return locked inside the transaction.
+func (m ReturnMapper) markRefunded(txn *sqlx.Tx, r *models.Return, paymentID *int) error {
+	stmt := `UPDATE returns SET status=$1, payment_id=$2, updated_at=now() WHERE id=$3`
+	_, err := txn.Exec(stmt, models.ReturnStatusRefunded, paymentID, r.ID)
+	if err != nil {
+		return errors.Wrap(err, "update return error")
+	}
+	note := fmt.Sprintf("return %d: %v %v refunded outside of the payment provider", r.ID, r.Amount, r.Currency)
+	if paymentID != nil {
+		note = fmt.Sprintf("return %d: %v %v refunded to payment %d", r.ID, r.Amount, r.Currency, *paymentID)
+	}
+	return OrderEventMapper{}.Record(txn, r.OrderID, models.OrderEventReturnRefunded, m.Actor, note)
+}
+
+// resolve locks the return in the status and lets fn change it in the same transaction.
+func (m ReturnMapper) resolve(id int, status string, fn func(txn *sqlx.Tx, r *models.Return) error) (*models.Return, error) {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return nil, errors.Wrap(err, "transaction open error")
+	}
+
+	r, err := m.lock(txn, id, status)
+	if err != nil {
+		return nil, err
+	}
+	err = fn(txn, r)
+	if err != nil {
+		return nil, err
+	}
+	err = txn.Get(r, `SELECT `+returnColumns+` FROM returns r WHERE r.id=$1`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "find return error")
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return nil, errors.Wrap(err, "Transaction commit fail")
+	}
+	return r, nil
+}
+
+// lock locks the return and checks it has the status.
+func (m ReturnMapper) lock(txn *sqlx.Tx, id int, status string) (*models.Return, error) {
+	r := &models.Return{}
+	err := txn.Get(r, `SELECT * FROM returns WHERE id=$1 FOR UPDATE`, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError(fmt.Sprintf("return %d not found", id))
+		}
+		return nil, errors.Wrap(err, "find return error")
+	}
+	if r.Status != status {
+		return nil, ConflictError(fmt.Sprintf("return %d is %v, expected %v", id, r.Status, status))
+	}
+	return r, nil
+}
@@ -14,16 +14,37 @@ import (
 )
 
 type UserMapperInterface interface {
+	FindByID(id int) (*models.User, error)
 	FindByUsername(username string) (*models.User, error)
 	FindByEmail(email string) (*models.User, error)
 	Create(*models.User) error
 	UpdateByUsername(u *models.User, username string) error
 	CreateMany(users []models.User) error
 	DeleteByUsername(username string) error
+	WithVersion(version int) UserMapperInterface
 }
 
 type UserMapper struct {
 	DB *sqlx.DB
+	// Version is the version mutated users are expected to have, zero disables the check
+	Version int
+}
+
+func (m UserMapper) WithVersion(version int) UserMapperInterface {
+	m.Version = version
+	return m
+}
+
+func (m UserMapper) FindByID(id int) (*models.User, error) {
+	user := &models.User{}
+	err := m.DB.Get(user, "SELECT * FROM users where id=$1", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError("user not found")
+		}
+		return nil, err
+	}
+	return user, nil
 }
 
 func (m UserMapper) FindByUsername(username string) (*models.User, error) {
@@ -52,6 +73,15 @@ func (m UserMapper) FindByEmail(email string) (*models.User, error) {
 }
 
 func (m UserMapper) Create(u *models.User) error {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
 	stmt := `INSERT INTO users (username, first_name, last_name, email, password, phone, user_status )
              VALUES (:username, :first_name, :last_name, :email, :password, :phone, :user_status) RETURNING id;`
 	var userID int
@@ -64,7 +94,7 @@ func (m UserMapper) Create(u *models.User) error {
 		"phone":       u.Phone,
 		"user_status": u.UserStatus,
 	}
-	rows, err := m.DB.NamedQuery(stmt, params)
+	rows, err := txn.NamedQuery(stmt, params)
 	if err != nil {
 		return errors.Wrap(err, "insert user error")
 	}
@@ -76,25 +106,67 @@ func (m UserMapper) Create(u *models.User) error {
 	}
 	u.ID = userID
 
+	err = publishUser(txn, models.EventUserCreated, *u)
+	if err != nil {
+		return err
+	}
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
 	return nil
 }
 
 func (m UserMapper) UpdateByUsername(u *models.User, username string) error {
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
 	stmt := `UPDATE users SET username=:username, first_name=:first_name, last_name=:last_name, email=:email, 
-                              password=:password, phone=:phone, user_status=:user_status WHERE username=:username`
+                              password=:password, phone=:phone, user_status=:user_status, version=version+1
+             WHERE username=:old_username AND (:expected_version = 0 OR version=:expected_version)
+             RETURNING id, version`
 	params := map[string]interface{}{
-		"username":    u.Username,
-		"first_name":  u.FirstName,
-		"last_name":   u.LastName,
-		"email":       u.Email,
-		"password":    u.Password,
-		"phone":       u.Phone,
-		"user_status": u.UserStatus,
+		"username":         u.Username,
+		"first_name":       u.FirstName,
+		"last_name":        u.LastName,
+		"email":            u.Email,
+		"password":         u.Password,
+		"phone":            u.Phone,
+		"user_status":      u.UserStatus,
+		"old_username":     username,
+		"expected_version": m.Version,
 	}
-	_, err := m.DB.NamedExec(stmt, params)
+	rows, err := txn.NamedQuery(stmt, params)
 	if err != nil {
 		return errors.Wrap(err, "user update have failed")
 	}
+	if !rows.Next() {
+		rows.Close()
+		if m.Version != 0 {
+			return VersionMismatchError(fmt.Sprintf("user %v does not have version %d", username, m.Version))
+		}
+		return NotFoundError("user not found")
+	}
+	err = rows.Scan(&u.ID, &u.Version)
+	rows.Close()
+	if err != nil {
+		return errors.Wrap(err, "scan user version error")
+	}
+
+	err = publishUser(txn, models.EventUserUpdated, *u)
+	if err != nil {
+		return err
+	}
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
 	return nil
 }
 
@@ -128,13 +200,72 @@ func (m UserMapper) CreateMany(users []models.User) error {
 		i++
 	}
 
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
 	stmt := fmt.Sprintf(`INSERT INTO users (username, first_name, last_name, email, password, phone, user_status )
-      VALUES %s`, strings.Join(markStrings, ","))
-	_, err := m.DB.Exec(stmt, valueArgs...)
-	return err
+      VALUES %s RETURNING id`, strings.Join(markStrings, ","))
+	var ids []int
+	err = txn.Select(&ids, stmt, valueArgs...)
+	if err != nil {
+		return err
+	}
+	// rows of a multi-row insert are returned in the order of the values
+	for i, id := range ids {
+		u := users[i]
+		u.ID = id
+		err = publishUser(txn, models.EventUserCreated, u)
+		if err != nil {
+			return err
+		}
+	}
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	return nil
 }
 
 func (m UserMapper) DeleteByUsername(username string) error {
-	_, err := m.DB.Exec(`DELETE FROM users where username=$1`, username)
-	return err
+	txn, err := m.DB.Beginx()
+	defer func() {
+		if err = txn.Rollback(); err != sql.ErrTxDone {
+			logrus.Error("rollback error", err)
+		}
+	}()
+	if err != nil {
+		return errors.Wrap(err, "transaction open error")
+	}
+	var userID int
+	err = txn.Get(&userID, `DELETE FROM users where username=$1 AND ($2 = 0 OR version=$2) RETURNING id`, username, m.Version)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return err
+		}
+		if m.Version != 0 {
+			return VersionMismatchError(fmt.Sprintf("user %v does not have version %d", username, m.Version))
+		}
+		return NotFoundError("user not found")
+	}
+
+	err = publish(txn, models.EventUserDeleted, map[string]interface{}{"id": userID, "username": username})
+	if err != nil {
+		return err
+	}
+	err = txn.Commit()
+	if err != nil {
+		return errors.Wrap(err, "Transaction commit fail")
+	}
+	return nil
+}
+
+// publishUser publishes the user without the password, it takes a copy as marshalling clears the password.
+func publishUser(txn *sqlx.Tx, eventType string, u models.User) error {
+	return publish(txn, eventType, map[string]interface{}{"user": &u})
 }
@@ -0,0 +1,211 @@
+package mappers
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+type WebhookMapperInterface interface {
+	FindAll() ([]*models.WebhookSubscription, error)
+	FindByID(id int) (*models.WebhookSubscription, error)
+	Create(s *models.WebhookSubscription) error
+	Update(s *models.WebhookSubscription) error
+	Delete(id int) error
+	FindDeliveries(subscriptionID int, status string) ([]*models.WebhookDelivery, error)
+	Redeliver(deliveryID int) (*models.WebhookDelivery, error)
+}
+
+type WebhookMapper struct {
+	DB *sqlx.DB
+}
+
+// webhookDeliveryColumns select a delivery of the webhook_deliveries d with its event of the outbox_events e
+const webhookDeliveryColumns = `d.*, e.event_type, e.payload, e.created_at AS event_created_at`
+
+func (m WebhookMapper) FindAll() ([]*models.WebhookSubscription, error) {
+	subscriptions := []*models.WebhookSubscription{}
+	err := m.DB.Select(&subscriptions, `SELECT * FROM webhook_subscriptions ORDER BY id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "find webhook subscriptions error")
+	}
+	return subscriptions, nil
+}
+
+func (m WebhookMapper) FindByID(id int) (*models.WebhookSubscription, error) {
+	subscription := &models.WebhookSubscription{}
+	err := m.DB.Get(subscription, `SELECT * FROM webhook_subscriptions WHERE id=$1`, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError(fmt.Sprintf("webhook %d not found", id))
+		}
+		return nil, errors.Wrap(err, "find webhook subscription error")
+	}
+	return subscription, nil
+}
+
+// Create adds the subscription, it receives events published from now on.
+func (m WebhookMapper) Create(s *models.WebhookSubscription) error {
+	stmt := `INSERT INTO webhook_subscriptions (url, secret, event_types, description, active)
+			 VALUES ($1, $2, $3, $4, $5)
+			 RETURNING id, created_at, updated_at`
+	err := m.DB.QueryRowx(stmt, s.URL, s.Secret, s.EventTypes, s.Description, s.Active).
+		Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return errors.Wrap(err, "insert webhook subscription error")
+	}
+	return nil
+}
+
+// Update changes the URL, event types, description and activity of the subscription, the secret is kept.
+// Deliveries of an inactive subscription wait until it is activated again.
+func (m WebhookMapper) Update(s *models.WebhookSubscription) error {
+	stmt := `UPDATE webhook_subscriptions SET url=$1, event_types=$2, description=$3, active=$4, updated_at=now()
+			 WHERE id=$5
+			 RETURNING *`
+	err := m.DB.Get(s, stmt, s.URL, s.EventTypes, s.Description, s.Active, s.ID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return NotFoundError(fmt.Sprintf("webhook %d not found", s.ID))
+		}
+		return errors.Wrap(err, "update webhook subscription error")
+	}
+	return nil
+}
+
+// Delete removes the subscription with its deliveries.
+func (m WebhookMapper) Delete(id int) error {
+	result, err := m.DB.Exec(`DELETE FROM webhook_subscriptions WHERE id=$1`, id)
+	if err != nil {
+		return errors.Wrap(err, "delete webhook subscription error")
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return NotFoundError(fmt.Sprintf("webhook %d not found", id))
+	}
+	return nil
+}
+
+// FindDeliveries returns the latest deliveries of the subscription first, an empty status matches any.
+func (m WebhookMapper) FindDeliveries(subscriptionID int, status string) ([]*models.WebhookDelivery, error) {
+	deliveries := []*models.WebhookDelivery{}
+	stmt := `SELECT ` + webhookDeliveryColumns + `
+			 FROM webhook_deliveries d
+			     INNER JOIN outbox_events e ON d.event_id = e.id
+			 WHERE d.subscription_id=$1 AND ($2 = '' OR d.status=$2)
+			 ORDER BY d.id DESC
+			 LIMIT 1000`
+	err := m.DB.Select(&deliveries, stmt, subscriptionID, status)
+	if err != nil {
+		return nil, errors.Wrap(err, "find webhook deliveries error")
+	}
+	return deliveries, nil
+}
+
+// Redeliver sends the delivery again as soon as possible with a fresh count of attempts,
+// it is meant for dead deliveries but delivered ones may be repeated too.
+func (m WebhookMapper) Redeliver(deliveryID int) (*models.WebhookDelivery, error) {
+	delivery := &models.WebhookDelivery{}
+	stmt := `WITH redelivered AS (
+			     UPDATE webhook_deliveries SET status=$1, attempts=0, next_attempt_at=now(), updated_at=now()
+			     WHERE id=$2
+			     RETURNING *
+			 )
+			 SELECT ` + webhookDeliveryColumns + ` FROM redelivered d INNER JOIN outbox_events e ON d.event_id = e.id`
+	err := m.DB.Get(delivery, stmt, models.DeliveryPending, deliveryID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFoundError(fmt.Sprintf("webhook delivery %d not found", deliveryID))
+		}
+		return nil, errors.Wrap(err, "redeliver webhook delivery error")
+	}
+	return delivery, nil
+}
+
+// Dispatch turns up to limit events of the outbox into deliveries to the active subscriptions
+// of their types and returns the count of the events dispatched. Concurrent dispatchers skip each other's events.
+func (m WebhookMapper) Dispatch(limit int) (int, error) {
+	stmt := `WITH events AS (
+			     SELECT id, event_type FROM outbox_events
+			     WHERE dispatched_at IS NULL
+			     ORDER BY id
+			     LIMIT $1
+			     FOR UPDATE SKIP LOCKED
+			 ), dispatched AS (
+			     UPDATE outbox_events o SET dispatched_at=now() FROM events WHERE o.id = events.id
+			     RETURNING o.id
+			 ), deliveries AS (
+			     INSERT INTO webhook_deliveries (event_id, subscription_id, status)
+			     SELECT events.id, s.id, $2
+			     FROM events
+			         INNER JOIN webhook_subscriptions s
+			             ON s.active AND (cardinality(s.event_types) = 0 OR events.event_type = ANY(s.event_types))
+			     ON CONFLICT (event_id, subscription_id) DO NOTHING
+			 )
+			 SELECT count(*) FROM dispatched`
+	var dispatched int
+	err := m.DB.Get(&dispatched, stmt, limit, models.DeliveryPending)
+	if err != nil {
+		return 0, errors.Wrap(err, "dispatch outbox events error")
+	}
+	return dispatched, nil
+}
+
+// ClaimDue takes up to limit pending deliveries of active subscriptions which are due and counts an attempt of each.
+// They are not due again for the lease, so other workers do not send them meanwhile.
+func (m WebhookMapper) ClaimDue(limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	deliveries := []*models.WebhookDelivery{}
+	stmt := `WITH claimed AS (
+			     UPDATE webhook_deliveries
+			     SET attempts=attempts+1, next_attempt_at=now() + $2 * interval '1 millisecond', updated_at=now()
+			     WHERE id IN (
+			         SELECT id FROM webhook_deliveries
+			         WHERE status=$3 AND next_attempt_at <= now()
+			               AND subscription_id IN (SELECT id FROM webhook_subscriptions WHERE active)
+			         ORDER BY next_attempt_at, id
+			         LIMIT $1
+			         FOR UPDATE SKIP LOCKED
+			     )
+			     RETURNING *
+			 )
+			 SELECT ` + webhookDeliveryColumns + ` FROM claimed d INNER JOIN outbox_events e ON d.event_id = e.id
+			 ORDER BY d.id`
+	err := m.DB.Select(&deliveries, stmt, limit, int64(lease/time.Millisecond), models.DeliveryPending)
+	if err != nil {
+		return nil, errors.Wrap(err, "claim webhook deliveries error")
+	}
+	return deliveries, nil
+}
+
+// SaveAttempt stores the outcome of the attempt, the status, response code, error and the time of the next attempt.
+func (m WebhookMapper) SaveAttempt(d *models.WebhookDelivery) error {
+	stmt := `UPDATE webhook_deliveries
+			 SET status=$1, response_code=$2, last_error=$3, next_attempt_at=$4, delivered_at=$5, updated_at=now()
+			 WHERE id=$6`
+	_, err := m.DB.Exec(stmt, d.Status, d.ResponseCode, d.LastError, d.NextAttemptAt, d.DeliveredAt, d.ID)
+	if err != nil {
+		return errors.Wrap(err, "save webhook delivery attempt error")
+	}
+	return nil
+}
+
+// Purge removes events of the outbox dispatched before the given time together with their deliveries,
+// events with deliveries still pending are kept until those are delivered or dead. It returns the count of the events.
+func (m WebhookMapper) Purge(dispatchedBefore time.Time) (int64, error) {
+	stmt := `DELETE FROM outbox_events e
+			 WHERE e.dispatched_at < $1
+			       AND NOT EXISTS (SELECT 1 FROM webhook_deliveries d WHERE d.event_id = e.id AND d.status = $2)`
+	result, err := m.DB.Exec(stmt, dispatchedBefore, models.DeliveryPending)
+	if err != nil {
+		return 0, errors.Wrap(err, "purge outbox events error")
+	}
+	return result.RowsAffected()
+}
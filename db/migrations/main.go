@@ -36,6 +36,102 @@ func Run(db *sqlx.DB) error {
 	if err != nil {
 		return err
 	}
+	err = addPetImagesColumn(db)
+	if err != nil {
+		return err
+	}
+	err = createPetHistoryTable(db)
+	if err != nil {
+		return err
+	}
+	err = addVersionColumns(db)
+	if err != nil {
+		return err
+	}
+	err = createReservationsTable(db)
+	if err != nil {
+		return err
+	}
+	err = addPetDetailsColumns(db)
+	if err != nil {
+		return err
+	}
+	err = addDeletedAtColumns(db)
+	if err != nil {
+		return err
+	}
+	err = createFavoritesTable(db)
+	if err != nil {
+		return err
+	}
+	err = migrateOrderTimestamps(db)
+	if err != nil {
+		return err
+	}
+	err = addOrderUserColumn(db)
+	if err != nil {
+		return err
+	}
+	err = createOrderItemsTable(db)
+	if err != nil {
+		return err
+	}
+	err = createCartsTables(db)
+	if err != nil {
+		return err
+	}
+	err = addOrderAmountColumns(db)
+	if err != nil {
+		return err
+	}
+	err = createCouponsTables(db)
+	if err != nil {
+		return err
+	}
+	err = createPaymentsTable(db)
+	if err != nil {
+		return err
+	}
+	err = createOrderEventsTable(db)
+	if err != nil {
+		return err
+	}
+	err = createReturnsTables(db)
+	if err != nil {
+		return err
+	}
+	err = createIdempotencyKeysTable(db)
+	if err != nil {
+		return err
+	}
+	err = createWebhooksTables(db)
+	if err != nil {
+		return err
+	}
+	err = addInvoiceMetadataColumns(db)
+	if err != nil {
+		return err
+	}
+	err = addInvoiceDocumentsColumn(db)
+	if err != nil {
+		return err
+	}
+	err = addInvoiceNumbers(db)
+	if err != nil {
+		return err
+	}
+	err = createRefundsTable(db)
+	if err != nil {
+		return err
+	}
+	err = addInvoiceStatusColumn(db)
+	if err != nil {
+		return err
+	}
+	err = addInvoiceRetryColumn(db)
+	if err != nil {
+		return err
+	}
 	logrus.Info("Successfully migrated")
 
 	return nil
@@ -139,3 +235,534 @@ func createInvoicesTable(db *sqlx.DB) error {
 	}
 	return nil
 }
+
+func addPetImagesColumn(db *sqlx.DB) error {
+	stmt := `ALTER TABLE pets ADD COLUMN IF NOT EXISTS images JSONB NOT NULL DEFAULT '[]';`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func createPetHistoryTable(db *sqlx.DB) error {
+	stmt := `CREATE TABLE IF NOT EXISTS pet_history (
+			    id SERIAL PRIMARY KEY,
+			    pet_id INT NOT NULL,
+			    action VARCHAR(255) NOT NULL,
+			    old_values JSONB,
+			    new_values JSONB,
+			    actor VARCHAR(255) NOT NULL DEFAULT '',
+			    changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			 );
+			 CREATE INDEX IF NOT EXISTS pet_history_pet_id_changed_at_idx ON pet_history (pet_id, changed_at);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func addVersionColumns(db *sqlx.DB) error {
+	stmt := `ALTER TABLE pets ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+			 ALTER TABLE users ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+			 ALTER TABLE orders ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func createReservationsTable(db *sqlx.DB) error {
+	stmt := `CREATE TABLE IF NOT EXISTS reservations (
+			    id SERIAL PRIMARY KEY,
+			    pet_id INT NOT NULL references pets(id) ON DELETE CASCADE,
+			    user_id INT NOT NULL references users(id) ON DELETE CASCADE,
+			    status VARCHAR(255) NOT NULL,
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    expires_at TIMESTAMPTZ NOT NULL,
+			    released_at TIMESTAMPTZ
+			 );
+			 CREATE UNIQUE INDEX IF NOT EXISTS reservations_active_pet_idx ON reservations (pet_id)
+			     WHERE status = 'active';
+			 CREATE INDEX IF NOT EXISTS reservations_expires_at_idx ON reservations (expires_at)
+			     WHERE status = 'active';`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// addPetDetailsColumns fills the details of existing pets with defaults,
+// the optional ones are left NULL.
+func addPetDetailsColumns(db *sqlx.DB) error {
+	stmt := `ALTER TABLE pets ADD COLUMN IF NOT EXISTS species VARCHAR(255) NOT NULL DEFAULT '';
+			 ALTER TABLE pets ADD COLUMN IF NOT EXISTS breed VARCHAR(255) NOT NULL DEFAULT '';
+			 ALTER TABLE pets ADD COLUMN IF NOT EXISTS birth_date DATE;
+			 ALTER TABLE pets ADD COLUMN IF NOT EXISTS sex VARCHAR(16) NOT NULL DEFAULT 'unknown';
+			 ALTER TABLE pets ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+			 ALTER TABLE pets ADD COLUMN IF NOT EXISTS weight NUMERIC(8, 3);
+			 ALTER TABLE pets ADD COLUMN IF NOT EXISTS price NUMERIC(12, 2) NOT NULL DEFAULT 0;
+			 ALTER TABLE pets ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+			 CREATE INDEX IF NOT EXISTS pets_species_idx ON pets (species);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func addDeletedAtColumns(db *sqlx.DB) error {
+	stmt := `ALTER TABLE pets ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+			 ALTER TABLE orders ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+			 CREATE INDEX IF NOT EXISTS pets_deleted_at_idx ON pets (deleted_at) WHERE deleted_at IS NOT NULL;
+			 CREATE INDEX IF NOT EXISTS orders_deleted_at_idx ON orders (deleted_at) WHERE deleted_at IS NOT NULL;`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func createFavoritesTable(db *sqlx.DB) error {
+	stmt := `CREATE TABLE IF NOT EXISTS favorites (
+			    user_id INT NOT NULL references users(id) ON DELETE CASCADE,
+			    pet_id INT NOT NULL references pets(id) ON DELETE CASCADE,
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    PRIMARY KEY (user_id, pet_id)
+			 );
+			 CREATE INDEX IF NOT EXISTS favorites_pet_id_idx ON favorites (pet_id);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// migrateOrderTimestamps moves the creation time kept in ship_date as unix seconds to created_at,
+// ship_date becomes the requested delivery date, unknown for existing orders.
+func migrateOrderTimestamps(db *sqlx.DB) error {
+	stmt := `ALTER TABLE orders ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ;
+			 ALTER TABLE orders ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+			 DO $$
+			 BEGIN
+			    IF (SELECT data_type FROM information_schema.columns
+			        WHERE table_schema = current_schema() AND table_name = 'orders' AND column_name = 'ship_date') = 'bigint' THEN
+			        UPDATE orders SET created_at = to_timestamp(ship_date) WHERE created_at IS NULL;
+			        ALTER TABLE orders ALTER COLUMN ship_date TYPE TIMESTAMPTZ USING NULL;
+			    END IF;
+			 END $$;
+			 UPDATE orders SET created_at = now() WHERE created_at IS NULL;
+			 UPDATE orders SET updated_at = created_at WHERE updated_at IS NULL;
+			 ALTER TABLE orders ALTER COLUMN created_at SET DEFAULT now(),
+			                    ALTER COLUMN created_at SET NOT NULL,
+			                    ALTER COLUMN updated_at SET DEFAULT now(),
+			                    ALTER COLUMN updated_at SET NOT NULL;
+			 CREATE INDEX IF NOT EXISTS orders_created_at_idx ON orders (created_at);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func addOrderUserColumn(db *sqlx.DB) error {
+	stmt := `ALTER TABLE orders ADD COLUMN IF NOT EXISTS user_id INT references users(id) ON DELETE SET NULL;
+			 CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id);
+			 CREATE INDEX IF NOT EXISTS orders_status_idx ON orders (status);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// createOrderItemsTable adds the lines of orders, orders placed before get a line of their single pet.
+func createOrderItemsTable(db *sqlx.DB) error {
+	stmt := `CREATE TABLE IF NOT EXISTS order_items (
+			    id SERIAL PRIMARY KEY,
+			    order_id INT NOT NULL references orders(id) ON DELETE CASCADE,
+			    pet_id INT NOT NULL references pets(id) ON DELETE CASCADE,
+			    quantity INT NOT NULL,
+			    unit_price NUMERIC(12, 2) NOT NULL DEFAULT 0,
+			    currency CHAR(3) NOT NULL DEFAULT 'USD'
+			 );
+			 CREATE INDEX IF NOT EXISTS order_items_order_id_idx ON order_items (order_id);
+			 CREATE INDEX IF NOT EXISTS order_items_pet_id_idx ON order_items (pet_id);
+			 INSERT INTO order_items (order_id, pet_id, quantity, unit_price, currency)
+			 SELECT o.id, o.pet_id, COALESCE(o.quantity, 1), p.price, p.currency
+			 FROM orders o INNER JOIN pets p ON o.pet_id = p.id
+			 WHERE NOT EXISTS (SELECT 1 FROM order_items i WHERE i.order_id = o.id);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// createCartsTables adds carts of users and of anonymous sessions identified by a token.
+func createCartsTables(db *sqlx.DB) error {
+	stmt := `CREATE TABLE IF NOT EXISTS carts (
+			    id SERIAL PRIMARY KEY,
+			    user_id INT UNIQUE references users(id) ON DELETE CASCADE,
+			    token VARCHAR(64) UNIQUE,
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    CHECK (user_id IS NOT NULL OR token IS NOT NULL)
+			 );
+			 CREATE TABLE IF NOT EXISTS cart_items (
+			    cart_id INT NOT NULL references carts(id) ON DELETE CASCADE,
+			    pet_id INT NOT NULL references pets(id) ON DELETE CASCADE,
+			    quantity INT NOT NULL,
+			    added_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    PRIMARY KEY (cart_id, pet_id)
+			 );
+			 CREATE INDEX IF NOT EXISTS cart_items_pet_id_idx ON cart_items (pet_id);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// addOrderAmountColumns adds line totals and order amounts, orders placed before are priced from their items.
+func addOrderAmountColumns(db *sqlx.DB) error {
+	stmt := `ALTER TABLE order_items ADD COLUMN IF NOT EXISTS total NUMERIC(12, 2);
+			 UPDATE order_items SET total = unit_price * quantity WHERE total IS NULL;
+			 ALTER TABLE order_items ALTER COLUMN total SET DEFAULT 0, ALTER COLUMN total SET NOT NULL;
+			 ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency CHAR(3);
+			 ALTER TABLE orders ADD COLUMN IF NOT EXISTS subtotal NUMERIC(12, 2);
+			 ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount NUMERIC(12, 2) NOT NULL DEFAULT 0;
+			 ALTER TABLE orders ADD COLUMN IF NOT EXISTS total NUMERIC(12, 2);
+			 ALTER TABLE orders ADD COLUMN IF NOT EXISTS coupon_code VARCHAR(64);
+			 UPDATE orders o SET subtotal = i.subtotal, total = i.subtotal - o.discount, currency = i.currency
+			 FROM (SELECT order_id, sum(total) AS subtotal, min(currency) AS currency
+			       FROM order_items GROUP BY order_id) i
+			 WHERE i.order_id = o.id AND o.subtotal IS NULL;
+			 UPDATE orders SET subtotal = 0, total = 0 WHERE subtotal IS NULL;
+			 UPDATE orders SET currency = 'USD' WHERE currency IS NULL;
+			 ALTER TABLE orders ALTER COLUMN currency SET DEFAULT 'USD', ALTER COLUMN currency SET NOT NULL,
+			                    ALTER COLUMN subtotal SET DEFAULT 0, ALTER COLUMN subtotal SET NOT NULL,
+			                    ALTER COLUMN total SET DEFAULT 0, ALTER COLUMN total SET NOT NULL;`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// createCouponsTables adds coupons and the orders which have used them.
+func createCouponsTables(db *sqlx.DB) error {
+	stmt := `CREATE TABLE IF NOT EXISTS coupons (
+			    id SERIAL PRIMARY KEY,
+			    code VARCHAR(64) NOT NULL UNIQUE,
+			    kind VARCHAR(16) NOT NULL,
+			    value NUMERIC(12, 2) NOT NULL,
+			    currency VARCHAR(3) NOT NULL DEFAULT '',
+			    valid_from TIMESTAMPTZ,
+			    valid_until TIMESTAMPTZ,
+			    usage_limit INT,
+			    per_user_limit INT,
+			    active BOOLEAN NOT NULL DEFAULT TRUE,
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			 );
+			 CREATE TABLE IF NOT EXISTS coupon_redemptions (
+			    coupon_id INT NOT NULL references coupons(id) ON DELETE CASCADE,
+			    order_id INT NOT NULL references orders(id) ON DELETE CASCADE,
+			    user_id INT references users(id) ON DELETE SET NULL,
+			    discount NUMERIC(12, 2) NOT NULL,
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    PRIMARY KEY (coupon_id, order_id)
+			 );
+			 CREATE INDEX IF NOT EXISTS coupon_redemptions_user_id_idx ON coupon_redemptions (coupon_id, user_id);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// createPaymentsTable adds the payment attempts of orders, reference is the id of the payment at the provider.
+func createPaymentsTable(db *sqlx.DB) error {
+	stmt := `CREATE TABLE IF NOT EXISTS payments (
+			    id SERIAL PRIMARY KEY,
+			    order_id INT NOT NULL references orders(id) ON DELETE CASCADE,
+			    provider VARCHAR(32) NOT NULL,
+			    reference VARCHAR(128),
+			    status VARCHAR(16) NOT NULL,
+			    amount NUMERIC(12, 2) NOT NULL,
+			    refunded NUMERIC(12, 2) NOT NULL DEFAULT 0,
+			    currency CHAR(3) NOT NULL,
+			    error TEXT NOT NULL DEFAULT '',
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			 );
+			 CREATE INDEX IF NOT EXISTS payments_order_id_idx ON payments (order_id);
+			 CREATE UNIQUE INDEX IF NOT EXISTS payments_reference_idx ON payments (provider, reference);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// createOrderEventsTable adds the timeline of orders, orders placed before start with their creation.
+func createOrderEventsTable(db *sqlx.DB) error {
+	stmt := `CREATE TABLE IF NOT EXISTS order_events (
+			    id SERIAL PRIMARY KEY,
+			    order_id INT NOT NULL references orders(id) ON DELETE CASCADE,
+			    event VARCHAR(32) NOT NULL,
+			    actor VARCHAR(255) NOT NULL DEFAULT '',
+			    note TEXT NOT NULL DEFAULT '',
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			 );
+			 CREATE INDEX IF NOT EXISTS order_events_order_id_idx ON order_events (order_id, created_at);
+			 INSERT INTO order_events (order_id, event, created_at)
+			 SELECT o.id, 'placed', o.created_at FROM orders o
+			 WHERE NOT EXISTS (SELECT 1 FROM order_events e WHERE e.order_id = o.id);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// createReturnsTables adds returns of delivered orders with their lines and the credit notes refunding them.
+func createReturnsTables(db *sqlx.DB) error {
+	stmt := `CREATE TABLE IF NOT EXISTS returns (
+			    id SERIAL PRIMARY KEY,
+			    order_id INT NOT NULL references orders(id) ON DELETE CASCADE,
+			    user_id INT references users(id) ON DELETE SET NULL,
+			    status VARCHAR(16) NOT NULL,
+			    reason TEXT NOT NULL,
+			    resolution TEXT NOT NULL DEFAULT '',
+			    amount NUMERIC(12, 2) NOT NULL,
+			    currency CHAR(3) NOT NULL,
+			    payment_id INT references payments(id) ON DELETE SET NULL,
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    resolved_at TIMESTAMPTZ
+			 );
+			 CREATE INDEX IF NOT EXISTS returns_order_id_idx ON returns (order_id);
+			 CREATE INDEX IF NOT EXISTS returns_status_idx ON returns (status);
+			 CREATE TABLE IF NOT EXISTS return_items (
+			    return_id INT NOT NULL references returns(id) ON DELETE CASCADE,
+			    pet_id INT NOT NULL,
+			    quantity INT NOT NULL,
+			    amount NUMERIC(12, 2) NOT NULL,
+			    PRIMARY KEY (return_id, pet_id)
+			 );
+			 CREATE TABLE IF NOT EXISTS credit_notes (
+			    id SERIAL PRIMARY KEY,
+			    order_id INT NOT NULL references orders(id) ON DELETE CASCADE,
+			    return_id INT UNIQUE references returns(id) ON DELETE SET NULL,
+			    amount NUMERIC(12, 2) NOT NULL,
+			    currency CHAR(3) NOT NULL,
+			    reason TEXT NOT NULL DEFAULT '',
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			 );
+			 CREATE INDEX IF NOT EXISTS credit_notes_order_id_idx ON credit_notes (order_id);
+			 CREATE INDEX IF NOT EXISTS credit_notes_created_at_idx ON credit_notes (created_at);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// createIdempotencyKeysTable adds the keys of retried requests with the responses to replay.
+func createIdempotencyKeysTable(db *sqlx.DB) error {
+	stmt := `CREATE TABLE IF NOT EXISTS idempotency_keys (
+			    key VARCHAR(255) NOT NULL,
+			    scope VARCHAR(255) NOT NULL DEFAULT '',
+			    request_hash CHAR(64) NOT NULL,
+			    response_code INT,
+			    response_headers JSONB,
+			    response_body BYTEA,
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    expires_at TIMESTAMPTZ NOT NULL,
+			    PRIMARY KEY (scope, key)
+			 );
+			 CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// createWebhooksTables adds webhook subscriptions, the outbox of events written with the changes
+// and the deliveries of the events to the subscriptions.
+func createWebhooksTables(db *sqlx.DB) error {
+	stmt := `CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+			    id SERIAL PRIMARY KEY,
+			    url TEXT NOT NULL,
+			    secret VARCHAR(128) NOT NULL,
+			    event_types TEXT[] NOT NULL DEFAULT '{}',
+			    description TEXT NOT NULL DEFAULT '',
+			    active BOOLEAN NOT NULL DEFAULT true,
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			 );
+			 CREATE TABLE IF NOT EXISTS outbox_events (
+			    id BIGSERIAL PRIMARY KEY,
+			    event_type VARCHAR(64) NOT NULL,
+			    payload JSONB NOT NULL,
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    dispatched_at TIMESTAMPTZ
+			 );
+			 CREATE INDEX IF NOT EXISTS outbox_events_undispatched_idx ON outbox_events (id) WHERE dispatched_at IS NULL;
+			 CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			    id SERIAL PRIMARY KEY,
+			    event_id BIGINT NOT NULL references outbox_events(id) ON DELETE CASCADE,
+			    subscription_id INT NOT NULL references webhook_subscriptions(id) ON DELETE CASCADE,
+			    status VARCHAR(16) NOT NULL,
+			    attempts INT NOT NULL DEFAULT 0,
+			    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    response_code INT,
+			    last_error TEXT NOT NULL DEFAULT '',
+			    delivered_at TIMESTAMPTZ,
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    UNIQUE (event_id, subscription_id)
+			 );
+			 CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+			 CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_id_idx ON webhook_deliveries (subscription_id, status);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// addInvoiceMetadataColumns describes stored invoices, earlier invoices get the period ending when they were made.
+func addInvoiceMetadataColumns(db *sqlx.DB) error {
+	stmt := `ALTER TABLE invoices ALTER COLUMN body SET DEFAULT '';
+			 ALTER TABLE invoices ADD COLUMN IF NOT EXISTS period_start TIMESTAMPTZ;
+			 ALTER TABLE invoices ADD COLUMN IF NOT EXISTS period_end TIMESTAMPTZ;
+			 UPDATE invoices SET period_start = to_timestamp(created_date), period_end = to_timestamp(created_date)
+			 WHERE period_start IS NULL;
+			 ALTER TABLE invoices ALTER COLUMN period_start SET NOT NULL;
+			 ALTER TABLE invoices ALTER COLUMN period_end SET NOT NULL;
+			 ALTER TABLE invoices ADD COLUMN IF NOT EXISTS order_ids BIGINT[] NOT NULL DEFAULT '{}';
+			 ALTER TABLE invoices ADD COLUMN IF NOT EXISTS credit_note_ids BIGINT[] NOT NULL DEFAULT '{}';
+			 ALTER TABLE invoices ADD COLUMN IF NOT EXISTS totals JSONB NOT NULL DEFAULT '[]';
+			 ALTER TABLE invoices ADD COLUMN IF NOT EXISTS storage_key TEXT NOT NULL DEFAULT '';
+			 ALTER TABLE invoices ADD COLUMN IF NOT EXISTS content_type VARCHAR(128) NOT NULL DEFAULT '';
+			 CREATE INDEX IF NOT EXISTS invoices_period_end_idx ON invoices (period_end);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// addInvoiceDocumentsColumn lists the formats invoices are stored in, earlier invoices have their markdown document.
+func addInvoiceDocumentsColumn(db *sqlx.DB) error {
+	stmt := `ALTER TABLE invoices ADD COLUMN IF NOT EXISTS documents JSONB NOT NULL DEFAULT '[]';
+			 UPDATE invoices
+			 SET documents = jsonb_build_array(jsonb_build_object(
+			         'format', 'markdown', 'storageKey', storage_key, 'contentType', content_type))
+			 WHERE storage_key <> '' AND documents = '[]';`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// addInvoiceNumbers numbers invoices in sequence and links orders and credit notes to the invoice they are on.
+// Invoices made before kept only their orders and credit notes ids or their period, their orders are linked
+// to the first invoice ending after they were made, so they are not invoiced again.
+func addInvoiceNumbers(db *sqlx.DB) error {
+	stmt := `ALTER TABLE invoices ADD COLUMN IF NOT EXISTS number INT;
+			 UPDATE invoices i SET number = n.number
+			 FROM (SELECT id, row_number() OVER (ORDER BY id) AS number FROM invoices) n
+			 WHERE i.id = n.id AND i.number IS NULL;
+			 ALTER TABLE invoices ALTER COLUMN number SET NOT NULL;
+			 CREATE UNIQUE INDEX IF NOT EXISTS invoices_number_idx ON invoices (number);
+			 CREATE TABLE IF NOT EXISTS invoice_counters (
+			    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+			    last_number INT NOT NULL
+			 );
+			 INSERT INTO invoice_counters (last_number) SELECT COALESCE(max(number), 0) FROM invoices
+			 ON CONFLICT (id) DO NOTHING;
+			 DO $$
+			 BEGIN
+			    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+			        WHERE table_schema = current_schema() AND table_name = 'orders' AND column_name = 'invoice_id') THEN
+			        ALTER TABLE orders ADD COLUMN invoice_id INT references invoices(id) ON DELETE SET NULL;
+			        ALTER TABLE credit_notes ADD COLUMN IF NOT EXISTS invoice_id INT references invoices(id) ON DELETE SET NULL;
+			        UPDATE orders o SET invoice_id = i.id FROM invoices i WHERE o.id = ANY(i.order_ids);
+			        UPDATE credit_notes n SET invoice_id = i.id FROM invoices i WHERE n.id = ANY(i.credit_note_ids);
+			        UPDATE orders o
+			        SET invoice_id = (SELECT i.id FROM invoices i WHERE i.period_end >= o.created_at
+			                          ORDER BY i.period_end, i.id LIMIT 1)
+			        WHERE o.invoice_id IS NULL;
+			        UPDATE credit_notes n
+			        SET invoice_id = (SELECT i.id FROM invoices i WHERE i.period_end >= n.created_at
+			                          ORDER BY i.period_end, i.id LIMIT 1)
+			        WHERE n.invoice_id IS NULL;
+			    END IF;
+			 END $$;
+			 CREATE INDEX IF NOT EXISTS orders_invoice_id_idx ON orders (invoice_id);
+			 CREATE INDEX IF NOT EXISTS orders_uninvoiced_idx ON orders (created_at, id) WHERE invoice_id IS NULL;
+			 CREATE INDEX IF NOT EXISTS credit_notes_invoice_id_idx ON credit_notes (invoice_id);
+			 CREATE INDEX IF NOT EXISTS credit_notes_uninvoiced_idx ON credit_notes (id) WHERE invoice_id IS NULL;`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// createRefundsTable adds refunds of payments, which are recorded as pending before the provider is asked.
+// Refunds made before are recorded from the refunded amounts of their payments.
+func createRefundsTable(db *sqlx.DB) error {
+	stmt := `CREATE TABLE IF NOT EXISTS refunds (
+			    id SERIAL PRIMARY KEY,
+			    payment_id INT NOT NULL references payments(id) ON DELETE CASCADE,
+			    return_id INT references returns(id) ON DELETE SET NULL,
+			    amount NUMERIC(12, 2) NOT NULL,
+			    status VARCHAR(16) NOT NULL,
+			    error TEXT NOT NULL DEFAULT '',
+			    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			 );
+			 CREATE INDEX IF NOT EXISTS refunds_payment_id_idx ON refunds (payment_id);
+			 CREATE INDEX IF NOT EXISTS refunds_pending_idx ON refunds (created_at) WHERE status = 'pending';
+			 CREATE UNIQUE INDEX IF NOT EXISTS refunds_return_id_idx ON refunds (return_id) WHERE status <> 'failed';
+			 INSERT INTO refunds (payment_id, amount, status, created_at, updated_at)
+			 SELECT p.id, p.refunded, 'succeeded', p.updated_at, p.updated_at FROM payments p
+			 WHERE p.refunded > 0 AND NOT EXISTS (SELECT 1 FROM refunds r WHERE r.payment_id = p.id);`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// addInvoiceStatusColumn tells invoices whose documents are not stored yet, invoices made before are all issued.
+func addInvoiceStatusColumn(db *sqlx.DB) error {
+	stmt := `ALTER TABLE invoices ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'issued';
+			 CREATE INDEX IF NOT EXISTS invoices_pending_idx ON invoices (id) WHERE status = 'pending';`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// addInvoiceRetryColumn leases pending invoices to the run storing their documents until retry_at.
+func addInvoiceRetryColumn(db *sqlx.DB) error {
+	stmt := `ALTER TABLE invoices ADD COLUMN IF NOT EXISTS retry_at TIMESTAMPTZ NOT NULL DEFAULT now();
+			 DROP INDEX IF EXISTS invoices_pending_idx;
+			 CREATE INDEX IF NOT EXISTS invoices_pending_retry_at_idx ON invoices (retry_at) WHERE status = 'pending';`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
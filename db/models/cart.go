@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Cart holds pets a customer is going to order, availability is checked on every read.
+type Cart struct {
+	Items []*CartItem `json:"items"`
+	// Available is false when any of the pets cannot be ordered anymore
+	Available bool `json:"available"`
+}
+
+// CartItem is a line of the cart with the current state of its pet.
+type CartItem struct {
+	PetID     int       `json:"petId" db:"pet_id"`
+	Name      string    `json:"name" db:"name"`
+	Quantity  int       `json:"quantity" db:"quantity"`
+	UnitPrice Decimal   `json:"unitPrice" db:"unit_price"`
+	Currency  string    `json:"currency" db:"currency"`
+	Status    string    `json:"status" db:"status"`
+	Available bool      `json:"available" db:"available"`
+	AddedAt   time.Time `json:"addedAt" db:"added_at"`
+}
+
+// NewCart returns the cart of the items, it is available when every item is.
+func NewCart(items []*CartItem) *Cart {
+	cart := &Cart{Items: items, Available: true}
+	if cart.Items == nil {
+		cart.Items = []*CartItem{}
+	}
+	for _, item := range cart.Items {
+		if !item.Available {
+			cart.Available = false
+		}
+	}
+	return cart
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Coupon kinds
+const (
+	// CouponPercentage takes Value percent off the subtotal
+	CouponPercentage = "percentage"
+	// CouponFixed takes Value in the coupon currency off the subtotal, never more than the subtotal
+	CouponFixed = "fixed"
+)
+
+var couponCode = regexp.MustCompile(`^[A-Z0-9_-]{3,64}$`)
+
+// Coupon is a discount code, codes are case insensitive and kept in upper case.
+type Coupon struct {
+	ID       int     `json:"id"`
+	Code     string  `json:"code"`
+	Kind     string  `json:"kind"`
+	Value    Decimal `json:"value"`
+	Currency string  `json:"currency,omitempty"`
+	// ValidFrom and ValidUntil bound the validity window, nil bounds are open
+	ValidFrom  *time.Time `json:"validFrom,omitempty" db:"valid_from"`
+	ValidUntil *time.Time `json:"validUntil,omitempty" db:"valid_until"`
+	// UsageLimit and PerUserLimit cap the orders using the coupon, nil means unlimited
+	UsageLimit   *int      `json:"usageLimit,omitempty" db:"usage_limit"`
+	PerUserLimit *int      `json:"perUserLimit,omitempty" db:"per_user_limit"`
+	Active       bool      `json:"active"`
+	Used         int       `json:"used" db:"used"`
+	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+}
+
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (c *Coupon) Validate() error {
+	c.Code = NormalizeCouponCode(c.Code)
+	if !couponCode.MatchString(c.Code) {
+		return ValidationError("coupon code must be 3 to 64 letters, digits, dashes or underscores")
+	}
+	switch c.Kind {
+	case CouponPercentage:
+		if c.Value <= 0 || c.Value > 100*decimalUnit {
+			return ValidationError("percentage coupon value must be above 0 and at most 100")
+		}
+		c.Currency = ""
+	case CouponFixed:
+		if c.Value <= 0 {
+			return ValidationError("fixed coupon value must be positive")
+		}
+		if c.Currency == "" {
+			c.Currency = DefaultCurrency
+		}
+		if !currencyCode.MatchString(c.Currency) {
+			return ValidationError("currency must be an ISO 4217 code like USD")
+		}
+	default:
+		return ValidationError(fmt.Sprintf("coupon kind must be %v or %v", CouponPercentage, CouponFixed))
+	}
+	if c.ValidFrom != nil && c.ValidUntil != nil && !c.ValidUntil.After(*c.ValidFrom) {
+		return ValidationError("validUntil must be after validFrom")
+	}
+	if c.UsageLimit != nil && *c.UsageLimit < 1 {
+		return ValidationError("usageLimit must be positive")
+	}
+	if c.PerUserLimit != nil && *c.PerUserLimit < 1 {
+		return ValidationError("perUserLimit must be positive")
+	}
+	return nil
+}
+
+// CheckApplicable returns a ValidationError describing why the coupon cannot be used at the moment
+// for an order in the currency, usage limits are checked by the caller.
+func (c *Coupon) CheckApplicable(at time.Time, currency string) error {
+	if !c.Active {
+		return ValidationError(fmt.Sprintf("coupon %v is not active", c.Code))
+	}
+	if c.ValidFrom != nil && at.Before(*c.ValidFrom) {
+		return ValidationError(fmt.Sprintf("coupon %v is valid from %v", c.Code, c.ValidFrom.UTC().Format(time.RFC3339)))
+	}
+	if c.ValidUntil != nil && !at.Before(*c.ValidUntil) {
+		return ValidationError(fmt.Sprintf("coupon %v has expired", c.Code))
+	}
+	if c.Kind == CouponFixed && c.Currency != currency {
+		return ValidationError(fmt.Sprintf("coupon %v applies to %v orders only", c.Code, c.Currency))
+	}
+	return nil
+}
+
+// Discount returns the amount the coupon takes off the subtotal.
+func (c *Coupon) Discount(subtotal Decimal) Decimal {
+	var discount Decimal
+	switch c.Kind {
+	case CouponPercentage:
+		discount = subtotal.Percent(c.Value)
+	case CouponFixed:
+		discount = c.Value
+	}
+	if discount > subtotal {
+		return subtotal
+	}
+	return discount
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCouponValidate(t *testing.T) {
+	valid := []Coupon{
+		{Code: " spring-10 ", Kind: CouponPercentage, Value: 1000},
+		{Code: "FULL", Kind: CouponPercentage, Value: 10000},
+		{Code: "FIVE", Kind: CouponFixed, Value: 500},
+	}
+	for _, c := range valid {
+		if err := c.Validate(); err != nil {
+			t.Errorf("%v: %v", c.Code, err)
+		}
+	}
+	c := valid[0]
+	c.Validate()
+	if c.Code != "SPRING-10" {
+		t.Errorf("code normalized to %q, want SPRING-10", c.Code)
+	}
+	c = valid[2]
+	c.Validate()
+	if c.Currency != DefaultCurrency {
+		t.Errorf("fixed coupon currency defaults to %q, want %v", c.Currency, DefaultCurrency)
+	}
+
+	zero := 0
+	now := time.Now()
+	invalid := []Coupon{
+		{Code: "AB", Kind: CouponPercentage, Value: 1000},
+		{Code: "SPRING 10", Kind: CouponPercentage, Value: 1000},
+		{Code: "OVER", Kind: CouponPercentage, Value: 10001},
+		{Code: "ZERO", Kind: CouponPercentage, Value: 0},
+		{Code: "NEGATIVE", Kind: CouponFixed, Value: -100},
+		{Code: "MONEY", Kind: CouponFixed, Value: 100, Currency: "dollars"},
+		{Code: "KIND", Kind: "gift", Value: 100},
+		{Code: "WINDOW", Kind: CouponFixed, Value: 100, ValidFrom: &now, ValidUntil: &now},
+		{Code: "USAGE", Kind: CouponFixed, Value: 100, UsageLimit: &zero},
+		{Code: "PERUSER", Kind: CouponFixed, Value: 100, PerUserLimit: &zero},
+	}
+	for _, c := range invalid {
+		if _, ok := c.Validate().(ValidationError); !ok {
+			t.Errorf("%v: invalid coupon has been accepted", c.Code)
+		}
+	}
+}
+
+func TestCouponCheckApplicable(t *testing.T) {
+	now := time.Now()
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name       string
+		coupon     Coupon
+		applicable bool
+	}{
+		{"active", Coupon{Active: true, Kind: CouponPercentage}, true},
+		{"inactive", Coupon{Kind: CouponPercentage}, false},
+		{"within window", Coupon{Active: true, Kind: CouponPercentage, ValidFrom: &before, ValidUntil: &after}, true},
+		{"not started", Coupon{Active: true, Kind: CouponPercentage, ValidFrom: &after}, false},
+		{"expired", Coupon{Active: true, Kind: CouponPercentage, ValidUntil: &before}, false},
+		{"ends now", Coupon{Active: true, Kind: CouponPercentage, ValidUntil: &now}, false},
+		{"other currency", Coupon{Active: true, Kind: CouponFixed, Currency: "EUR"}, false},
+		{"same currency", Coupon{Active: true, Kind: CouponFixed, Currency: "USD"}, true},
+	}
+	for _, test := range tests {
+		err := test.coupon.CheckApplicable(now, "USD")
+		if (err == nil) != test.applicable {
+			t.Errorf("%v: got %v", test.name, err)
+		}
+	}
+}
+
+func TestCouponDiscount(t *testing.T) {
+	tests := []struct {
+		coupon   Coupon
+		subtotal Decimal
+		want     Decimal
+	}{
+		{Coupon{Kind: CouponPercentage, Value: 1000}, 1999, 200},
+		{Coupon{Kind: CouponPercentage, Value: 1500}, 1999, 300},
+		{Coupon{Kind: CouponPercentage, Value: 10000}, 1999, 1999},
+		{Coupon{Kind: CouponFixed, Value: 500}, 1999, 500},
+		// fixed discounts never exceed the subtotal
+		{Coupon{Kind: CouponFixed, Value: 500}, 300, 300},
+		{Coupon{Kind: CouponFixed, Value: 500}, 0, 0},
+	}
+	for _, test := range tests {
+		if got := test.coupon.Discount(test.subtotal); got != test.want {
+			t.Errorf("%v %v coupon on %v: got %v, want %v", test.coupon.Kind, test.coupon.Value, test.subtotal, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// CreditNote records money owed back to the customer of an order, for a return or a cancelled paid order.
+type CreditNote struct {
+	ID        int       `json:"id"`
+	OrderID   int       `json:"orderId" db:"order_id"`
+	ReturnID  *int      `json:"returnId,omitempty" db:"return_id"`
+	Amount    Decimal   `json:"amount"`
+	Currency  string    `json:"currency"`
+	Reason    string    `json:"reason"`
+	InvoiceID *int      `json:"invoiceId,omitempty" db:"invoice_id"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"time"
+)
+
+const DateFormat = "2006-01-02"
+
+// Date is a calendar day without time of day, encoded in json as "2006-01-02".
+type Date struct {
+	time.Time
+}
+
+func NewDate(year int, month time.Month, day int) Date {
+	return Date{time.Date(year, month, day, 0, 0, 0, 0, time.UTC)}
+}
+
+// Age is the whole years and months passed since a date.
+type Age struct {
+	Years  int `json:"years"`
+	Months int `json:"months"`
+}
+
+// AgeAt returns the age reached at the given moment, zero for moments before the date.
+func (d Date) AgeAt(at time.Time) Age {
+	months := (at.Year()-d.Year())*12 + int(at.Month()) - int(d.Month())
+	if at.Day() < d.Day() {
+		months--
+	}
+	if months < 0 {
+		months = 0
+	}
+	return Age{Years: months / 12, Months: months % 12}
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + d.Format(DateFormat) + `"`), nil
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	t, err := time.Parse(DateFormat, strings.Trim(s, `"`))
+	if err != nil {
+		return ValidationError("date must be formatted as " + DateFormat)
+	}
+	d.Time = t
+	return nil
+}
+
+func (d Date) Value() (driver.Value, error) {
+	return d.Format(DateFormat), nil
+}
+
+func (d *Date) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case time.Time:
+		*d = NewDate(v.Year(), v.Month(), v.Day())
+	case []byte:
+		return d.parse(string(v))
+	case string:
+		return d.parse(v)
+	default:
+		return errors.New("incompatible type for Date")
+	}
+	return nil
+}
+
+func (d *Date) parse(s string) error {
+	t, err := time.Parse(DateFormat, s)
+	if err != nil {
+		return err
+	}
+	d.Time = t
+	return nil
+}
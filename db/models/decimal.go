@@ -0,0 +1,130 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DecimalScale is the count of fractional digits kept by Decimal
+const DecimalScale = 2
+
+const decimalUnit = 100
+
+// Decimal is a fixed point number with two fractional digits stored as hundredths,
+// so money never goes through floating point. It is encoded as a json string, e.g. "12.50".
+type Decimal int64
+
+// ParseDecimal parses numbers like "12", "-3.5" or "0.99", digits beyond the scale must be zeros.
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	parts := strings.SplitN(s, ".", 2)
+	if parts[0] == "" && (len(parts) == 1 || parts[1] == "") {
+		return 0, fmt.Errorf("invalid decimal %q", s)
+	}
+	var whole int64
+	if parts[0] != "" {
+		var err error
+		whole, err = strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || strings.ContainsAny(parts[0], "+-") || whole > math.MaxInt64/decimalUnit {
+			return 0, fmt.Errorf("invalid decimal %q", s)
+		}
+	}
+	var fraction int64
+	if len(parts) == 2 {
+		digits := strings.TrimRight(parts[1], "0")
+		if len(digits) > DecimalScale {
+			return 0, fmt.Errorf("decimal %q has more than %d fractional digits", s, DecimalScale)
+		}
+		digits += strings.Repeat("0", DecimalScale-len(digits))
+		var err error
+		fraction, err = strconv.ParseInt(digits, 10, 64)
+		if err != nil || strings.ContainsAny(parts[1], "+-") {
+			return 0, fmt.Errorf("invalid decimal %q", s)
+		}
+	}
+	d := Decimal(whole*decimalUnit + fraction)
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
+
+func (d Decimal) String() string {
+	sign := ""
+	v := int64(d)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/decimalUnit, v%decimalUnit)
+}
+
+// Mul multiplies the amount by a whole quantity.
+func (d Decimal) Mul(quantity int) Decimal {
+	return d * Decimal(quantity)
+}
+
+// Percent returns percent of the amount rounded half away from zero to the scale.
+func (d Decimal) Percent(percent Decimal) Decimal {
+	product := int64(d) * int64(percent)
+	const divisor = 100 * decimalUnit
+	if product < 0 {
+		return Decimal((product - divisor/2) / divisor)
+	}
+	return Decimal((product + divisor/2) / divisor)
+}
+
+// Share returns the part of the amount falling on the line total out of the subtotal, rounded half up.
+func (d Decimal) Share(lineTotal, subtotal Decimal) Decimal {
+	if subtotal <= 0 {
+		return 0
+	}
+	return Decimal((int64(d)*int64(lineTotal) + int64(subtotal)/2) / int64(subtotal))
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + d.String() + `"`), nil
+}
+
+// UnmarshalJSON accepts both json strings and numbers, numbers are parsed from their text.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	value, err := ParseDecimal(strings.Trim(s, `"`))
+	if err != nil {
+		return ValidationError(err.Error())
+	}
+	*d = value
+	return nil
+}
+
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+func (d *Decimal) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*d = 0
+	case []byte:
+		*d, err = ParseDecimal(string(v))
+	case string:
+		*d, err = ParseDecimal(v)
+	case int64:
+		*d = Decimal(v * decimalUnit)
+	case float64:
+		*d = Decimal(math.Round(v * decimalUnit))
+	default:
+		return errors.New("incompatible type for Decimal")
+	}
+	return err
+}
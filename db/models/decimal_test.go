@@ -0,0 +1,114 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	valid := map[string]Decimal{
+		"12":     1200,
+		"-3.5":   -350,
+		"0.99":   99,
+		".5":     50,
+		"+1.10":  110,
+		"7.000":  700,
+		" 2.01 ": 201,
+	}
+	for s, want := range valid {
+		d, err := ParseDecimal(s)
+		if err != nil || d != want {
+			t.Errorf("%q: got %v, %v, want %v", s, d, err, want)
+		}
+	}
+	for _, s := range []string{"", ".", "-", "1.234", "1.2.3", "abc", "1e3", "--1", "1.-5", "92233720368547759"} {
+		if d, err := ParseDecimal(s); err == nil {
+			t.Errorf("%q: got %v, want an error", s, d)
+		}
+	}
+}
+
+func TestDecimalString(t *testing.T) {
+	tests := map[Decimal]string{0: "0.00", 5: "0.05", -5: "-0.05", 1250: "12.50", -100: "-1.00"}
+	for d, want := range tests {
+		if s := d.String(); s != want {
+			t.Errorf("%d: got %v, want %v", int64(d), s, want)
+		}
+	}
+}
+
+func TestDecimalPercent(t *testing.T) {
+	tests := []struct {
+		amount, percent, want Decimal
+	}{
+		{1000, 1000, 100},   // 10% of 10.00
+		{1999, 1500, 300},   // 2.9985 rounds up
+		{1990, 1250, 249},   // 2.4875 rounds up
+		{1, 5000, 1},        // 0.005 rounds half away from zero
+		{1, 4900, 0},        // 0.0049 rounds down
+		{-1, 5000, -1},      // negative halves round away from zero too
+		{3333, 10000, 3333}, // 100%
+		{1234, 0, 0},
+	}
+	for _, test := range tests {
+		if got := test.amount.Percent(test.percent); got != test.want {
+			t.Errorf("%v%% of %v: got %v, want %v", test.percent, test.amount, got, test.want)
+		}
+	}
+}
+
+func TestDecimalShare(t *testing.T) {
+	tests := []struct {
+		amount, line, subtotal, want Decimal
+	}{
+		{900, 1000, 2000, 450},
+		{1000, 1000, 3000, 333}, // 3.333 rounds down
+		{1000, 2000, 3000, 667}, // 6.667 rounds up
+		{5, 100, 200, 3},        // 0.025 rounds half up
+		{1000, 1000, 0, 0},      // nothing to share out of
+		{1000, 1000, -1, 0},
+	}
+	for _, test := range tests {
+		if got := test.amount.Share(test.line, test.subtotal); got != test.want {
+			t.Errorf("share of %v for %v out of %v: got %v, want %v", test.amount, test.line, test.subtotal, got, test.want)
+		}
+	}
+}
+
+func TestDecimalJSON(t *testing.T) {
+	var values struct {
+		A, B Decimal
+		C    *Decimal
+	}
+	err := json.Unmarshal([]byte(`{"A": "12.5", "B": 3.25, "C": null}`), &values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values.A != 1250 || values.B != 325 || values.C != nil {
+		t.Errorf("got %v %v %v", values.A, values.B, values.C)
+	}
+	output, err := json.Marshal(values.A)
+	if err != nil || string(output) != `"12.50"` {
+		t.Errorf("got %s, %v, want \"12.50\"", output, err)
+	}
+	err = json.Unmarshal([]byte(`{"A": "1.001"}`), &values)
+	if _, ok := err.(ValidationError); !ok {
+		t.Errorf("three fractional digits: got %v, want ValidationError", err)
+	}
+}
+
+func TestDecimalScan(t *testing.T) {
+	sources := []interface{}{[]byte("12.50"), "12.50", 12.5, nil, int64(3)}
+	wants := []Decimal{1250, 1250, 1250, 0, 300}
+	for i, src := range sources {
+		var d Decimal = 1
+		err := d.Scan(src)
+		if err != nil || d != wants[i] {
+			t.Errorf("%#v: got %v, %v, want %v", src, d, err, wants[i])
+		}
+	}
+	var d Decimal
+	if err := d.Scan(true); err == nil {
+		t.Error("bool has been scanned")
+	}
+}
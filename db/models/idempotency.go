@@ -0,0 +1,54 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// IdempotencyRecord is a request made with an Idempotency-Key and the response it got,
+// ResponseCode is nil while the request is being handled.
+type IdempotencyRecord struct {
+	Key             string          `db:"key"`
+	Scope           string          `db:"scope"` // username of the client, or "cart:" and the hashed cart token of anonymous ones
+	RequestHash     string          `db:"request_hash"`
+	ResponseCode    *int            `db:"response_code"`
+	ResponseHeaders ResponseHeaders `db:"response_headers"`
+	ResponseBody    []byte          `db:"response_body"`
+	CreatedAt       time.Time       `db:"created_at"`
+	ExpiresAt       time.Time       `db:"expires_at"`
+}
+
+// ResponseHeaders are the recorded response headers stored as json.
+type ResponseHeaders http.Header
+
+func (h ResponseHeaders) Value() (driver.Value, error) {
+	if h == nil {
+		return nil, nil
+	}
+	return json.Marshal(h)
+}
+
+func (h *ResponseHeaders) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("incompatible type for ResponseHeaders")
+	}
+	headers := ResponseHeaders{}
+	err := json.Unmarshal(data, &headers)
+	if err != nil {
+		return err
+	}
+	*h = headers
+	return nil
+}
@@ -0,0 +1,39 @@
+package models
+
+// Inventory maps a pet status to a count, every status is present.
+type Inventory map[string]int
+
+func NewInventory() Inventory {
+	inventory := Inventory{}
+	for _, status := range allowedPetStatuses {
+		inventory[status] = 0
+	}
+	return inventory
+}
+
+// InventoryCounts are counts of pets which are not deleted
+// and how many times pets of every status were favorited.
+type InventoryCounts struct {
+	Pets      Inventory
+	Favorites Inventory
+}
+
+func NewInventoryCounts() *InventoryCounts {
+	return &InventoryCounts{Pets: NewInventory(), Favorites: NewInventory()}
+}
+
+// InventoryReport holds the total counts and their breakdowns by category and tag.
+// Pets without a category are counted under an empty name, pets with many tags are counted for each of them.
+type InventoryReport struct {
+	Total      *InventoryCounts
+	Categories map[string]*InventoryCounts
+	Tags       map[string]*InventoryCounts
+}
+
+func NewInventoryReport() *InventoryReport {
+	return &InventoryReport{
+		Total:      NewInventoryCounts(),
+		Categories: map[string]*InventoryCounts{},
+		Tags:       map[string]*InventoryCounts{},
+	}
+}
@@ -1,7 +1,137 @@
 package models
 
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Invoice statuses, an invoice is pending from when its orders and credit notes are claimed
+// until its documents are stored.
+const (
+	InvoiceStatusPending = "pending"
+	InvoiceStatusIssued  = "issued"
+)
+
+// Invoice sums the orders and credit notes of a period, the documents are kept in the storage in every rendered format.
+// StorageKey and ContentType are of the first document. Invoices are numbered in sequence without gaps.
 type Invoice struct {
-	ID          int    `json:"id"`
-	Body        string `json:"body" db:"body"`
-	CreatedDate int64  `json:"createdDate" db:"created_date"`
+	ID     int    `json:"id"`
+	Number int    `json:"number" db:"number"`
+	Status string `json:"status"`
+	// Body is only kept by invoices made before they were stored
+	Body          string           `json:"-" db:"body"`
+	CreatedDate   int64            `json:"createdDate" db:"created_date"`
+	PeriodStart   time.Time        `json:"periodStart" db:"period_start"`
+	PeriodEnd     time.Time        `json:"periodEnd" db:"period_end"`
+	OrderIDs      pq.Int64Array    `json:"orderIds" db:"order_ids"`
+	CreditNoteIDs pq.Int64Array    `json:"creditNoteIds" db:"credit_note_ids"`
+	Totals        InvoiceTotals    `json:"totals"`
+	StorageKey    string           `json:"storageKey" db:"storage_key"`
+	ContentType   string           `json:"contentType" db:"content_type"`
+	Documents     InvoiceDocuments `json:"documents"`
+	// RetryAt ends the lease of a pending invoice to the run storing its documents
+	RetryAt time.Time `json:"-" db:"retry_at"`
+}
+
+// InvoiceDocument is the invoice rendered in a format.
+type InvoiceDocument struct {
+	Format      string `json:"format"`
+	StorageKey  string `json:"storageKey"`
+	ContentType string `json:"contentType"`
+}
+
+// InvoiceDocuments are the documents of an invoice stored as json.
+type InvoiceDocuments []*InvoiceDocument
+
+// Find returns the document in the format, nil if the invoice has none.
+func (d InvoiceDocuments) Find(format string) *InvoiceDocument {
+	for _, document := range d {
+		if document.Format == format {
+			return document
+		}
+	}
+	return nil
+}
+
+func (d InvoiceDocuments) Value() (driver.Value, error) {
+	if d == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (d *InvoiceDocuments) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*d = InvoiceDocuments{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("incompatible type for InvoiceDocuments")
+	}
+	documents := InvoiceDocuments{}
+	err := json.Unmarshal(data, &documents)
+	if err != nil {
+		return err
+	}
+	*d = documents
+	return nil
+}
+
+// InvoiceTotal sums the amounts of invoiced orders and credit notes in one currency.
+type InvoiceTotal struct {
+	Currency string  `json:"currency"`
+	Subtotal Decimal `json:"subtotal"`
+	Discount Decimal `json:"discount"`
+	Total    Decimal `json:"total"`
+	Credited Decimal `json:"credited"`
+	Net      Decimal `json:"net"` // total less credited
+}
+
+// InvoiceTotals are the totals of an invoice by currency stored as json.
+type InvoiceTotals []*InvoiceTotal
+
+func (t InvoiceTotals) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (t *InvoiceTotals) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*t = InvoiceTotals{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("incompatible type for InvoiceTotals")
+	}
+	totals := InvoiceTotals{}
+	err := json.Unmarshal(data, &totals)
+	if err != nil {
+		return err
+	}
+	*t = totals
+	return nil
 }
@@ -2,9 +2,7 @@ package models
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"strconv"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -12,64 +10,144 @@ import (
 	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
 )
 
-var allowedOrderStatuses = []string{"placed", "approved", "delivered"}
+const (
+	OrderStatusPlaced    = "placed"
+	OrderStatusApproved  = "approved"
+	OrderStatusDelivered = "delivered"
+	OrderStatusCancelled = "cancelled"
+)
+
+var allowedOrderStatuses = []string{OrderStatusPlaced, OrderStatusApproved, OrderStatusDelivered, OrderStatusCancelled}
+
+// orderTransitions maps an order status to the statuses it can move to
+var orderTransitions = map[string][]string{
+	OrderStatusPlaced:   {OrderStatusApproved, OrderStatusCancelled},
+	OrderStatusApproved: {OrderStatusDelivered, OrderStatusCancelled},
+}
 
-//"2019-08-26T11:55:56.457Z"
-const ShipDateFormat = "2006-01-02T15:04:05.999Z0700"
+// ShipDateFormat is the layout order times are encoded with, always in UTC, e.g. "2019-08-26T11:55:56.457Z"
+const ShipDateFormat = "2006-01-02T15:04:05.999Z07:00"
 
 type Order struct {
-	ID       int    `json:"id"`
-	PetID    int    `json:"petId" db:"pet_id"`
-	Quantity int    `json:"quantity"`
-	ShipDate string `json:"shipDate" db:"ship_date"`
-	Complete bool   `json:"complete"`
-	Status   string `json:"status"`
+	ID         int        `json:"id"`
+	PetID      int        `json:"petId" db:"pet_id"`
+	UserID     *int       `json:"userId,omitempty" db:"user_id"` // customer, nil for anonymous orders
+	Quantity   int        `json:"quantity"`
+	ShipDate   *time.Time `json:"shipDate" db:"ship_date"` // requested delivery date
+	Complete   bool       `json:"complete"`
+	Status     string     `json:"status"`
+	Items      OrderItems `json:"items" db:"items"`
+	Currency   string     `json:"currency"` // amounts are computed from the items when the order is placed
+	Subtotal   Decimal    `json:"subtotal"`
+	Discount   Decimal    `json:"discount"`
+	Total      Decimal    `json:"total"`
+	CouponCode *string    `json:"couponCode,omitempty" db:"coupon_code"`
+	InvoiceID  *int       `json:"invoiceId,omitempty" db:"invoice_id"` // nil until the order is invoiced
+	Version    int        `json:"-" db:"version"`
+	CreatedAt  time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updatedAt" db:"updated_at"`
+	DeletedAt  *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
 }
 
-func (o *Order) MarshalJSON() (output []byte, err error) {
+func (o Order) MarshalJSON() ([]byte, error) {
 	type Alias Order
-	i, err := strconv.ParseInt(o.ShipDate, 10, 64)
-	if err != nil {
-		return
+	var shipDate *string
+	if o.ShipDate != nil {
+		s := o.ShipDate.UTC().Format(ShipDateFormat)
+		shipDate = &s
 	}
-	unixTimeUTC := time.Unix(i, 0)
-	unitTimeInRFC3339 := unixTimeUTC.Format(ShipDateFormat)
-	o.ShipDate = unitTimeInRFC3339
-	return json.Marshal((*Alias)(o))
+	return json.Marshal(struct {
+		Alias
+		ShipDate  *string `json:"shipDate"`
+		CreatedAt string  `json:"createdAt"`
+		UpdatedAt string  `json:"updatedAt"`
+	}{
+		Alias:     Alias(o),
+		ShipDate:  shipDate,
+		CreatedAt: o.CreatedAt.UTC().Format(ShipDateFormat),
+		UpdatedAt: o.UpdatedAt.UTC().Format(ShipDateFormat),
+	})
 }
 
+// UnmarshalJSON accepts the ship date with any offset and keeps it in UTC,
+// the creation and update times are set by the server and ignored.
+// The single pet payload of petId and quantity without items becomes an order of one line.
 func (o *Order) UnmarshalJSON(bytes []byte) error {
 	type Alias Order
-	alias := &Alias{}
-	err := json.Unmarshal(bytes, alias)
+	aux := struct {
+		*Alias
+		ShipDate  *string     `json:"shipDate"`
+		CreatedAt interface{} `json:"createdAt"`
+		UpdatedAt interface{} `json:"updatedAt"`
+	}{Alias: (*Alias)(o)}
+	err := json.Unmarshal(bytes, &aux)
 	if err != nil {
 		return err
 	}
-	t, err := time.Parse(ShipDateFormat, alias.ShipDate)
-	if err != nil {
-		return err
+	o.ShipDate = nil
+	if aux.ShipDate != nil {
+		t, err := parseOrderTime(*aux.ShipDate)
+		if err != nil {
+			return err
+		}
+		o.ShipDate = &t
+	}
+	if len(o.Items) == 0 && o.PetID != 0 {
+		o.Items = OrderItems{{PetID: o.PetID, Quantity: o.Quantity}}
 	}
-	alias.ShipDate = fmt.Sprintf("%d", t.Unix())
-	*o = *((*Order)(alias))
-
 	return nil
 }
 
+// parseOrderTime parses RFC 3339 times, offsets may be written with or without a colon.
+func parseOrderTime(s string) (time.Time, error) {
+	for _, layout := range []string{ShipDateFormat, "2006-01-02T15:04:05.999999999Z0700"} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, ValidationError(fmt.Sprintf("invalid ship date %q, expected format like %v", s, ShipDateFormat))
+}
+
 func (o *Order) Validate() error {
 	err := o.checkStatus()
 	if err != nil {
 		logrus.Error(err)
 		return err
 	}
-	if o.Quantity < 1 {
-		return errors.New("invalid quantity")
+	err = o.Items.Validate()
+	if err != nil {
+		return err
+	}
+	// petId and quantity summarize the lines for clients of single pet orders
+	o.PetID = o.Items[0].PetID
+	o.Quantity = o.Items.Quantity()
+	if o.ShipDate != nil && !o.ShipDate.After(time.Now()) {
+		return ValidationError("ship date must be in the future")
 	}
 	return nil
 }
 
 func (o *Order) checkStatus() error {
-	if utils.ContainsString(o.Status, allowedOrderStatuses) {
+	return Order{}.CheckStatus(o.Status)
+}
+
+func (Order) CheckStatus(status string) error {
+	if utils.ContainsString(status, allowedOrderStatuses) {
 		return nil
 	}
 	return ValidationError("not allowed status for order model")
 }
+
+// IsOpen tells whether the order has not been delivered or cancelled yet, open orders hold their pets.
+func (o *Order) IsOpen() bool {
+	return utils.ContainsString(OrderStatusCancelled, orderTransitions[o.Status])
+}
+
+// CheckTransition returns a ValidationError when the order cannot move to the status.
+func (o *Order) CheckTransition(status string) error {
+	if utils.ContainsString(status, orderTransitions[o.Status]) {
+		return nil
+	}
+	return ValidationError(fmt.Sprintf("order %d is %v and cannot become %v", o.ID, o.Status, status))
+}
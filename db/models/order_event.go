@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Order timeline events, status changes are recorded under the name of the new status
+const (
+	OrderEventPlaced           = OrderStatusPlaced
+	OrderEventApproved         = OrderStatusApproved
+	OrderEventDelivered        = OrderStatusDelivered
+	OrderEventCancelled        = OrderStatusCancelled
+	OrderEventPaymentSucceeded = "payment_succeeded"
+	OrderEventPaymentFailed    = "payment_failed"
+	OrderEventRefunded         = "refunded"
+	OrderEventReturnRequested  = "return_requested"
+	OrderEventReturnApproved   = "return_approved"
+	OrderEventReturnRejected   = "return_rejected"
+	OrderEventReturnRefunded   = "return_refunded"
+	OrderEventCreditNote       = "credit_note"
+)
+
+// OrderEvent is a step in the life of an order, Note describes it for people.
+type OrderEvent struct {
+	ID        int       `json:"id"`
+	OrderID   int       `json:"orderId" db:"order_id"`
+	Event     string    `json:"event"`
+	Actor     string    `json:"actor"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
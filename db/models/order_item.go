@@ -0,0 +1,82 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// OrderItem is a line of an order, the unit price and currency are copied from the pet when ordering.
+type OrderItem struct {
+	ID        int     `json:"id"`
+	OrderID   int     `json:"-" db:"order_id"`
+	PetID     int     `json:"petId" db:"pet_id"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice Decimal `json:"unitPrice" db:"unit_price"`
+	Currency  string  `json:"currency"`
+	Total     Decimal `json:"total"` // unit price times quantity
+}
+
+// OrderItems are read from the database as a json array aggregated per order.
+type OrderItems []*OrderItem
+
+func (oi *OrderItems) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*oi = OrderItems{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("incompatible type for OrderItems")
+	}
+	items := OrderItems{}
+	err := json.Unmarshal(data, &items)
+	if err != nil {
+		return err
+	}
+	*oi = items
+	return nil
+}
+
+// Validate checks every line, a pet can be ordered only once per order.
+func (oi OrderItems) Validate() error {
+	if len(oi) == 0 {
+		return ValidationError("order has no items")
+	}
+	pets := make(map[int]bool, len(oi))
+	for i, item := range oi {
+		if item == nil || item.PetID < 1 {
+			return ValidationError(fmt.Sprintf("invalid pet id of item %d", i+1))
+		}
+		if item.Quantity < 1 {
+			return ValidationError(fmt.Sprintf("invalid quantity of item %d", i+1))
+		}
+		if pets[item.PetID] {
+			return ValidationError(fmt.Sprintf("pet %d is ordered more than once", item.PetID))
+		}
+		pets[item.PetID] = true
+	}
+	return nil
+}
+
+// Quantity returns the quantity of all lines.
+func (oi OrderItems) Quantity() int {
+	quantity := 0
+	for _, item := range oi {
+		quantity += item.Quantity
+	}
+	return quantity
+}
+
+// Subtotal returns the sum of line totals.
+func (oi OrderItems) Subtotal() Decimal {
+	var subtotal Decimal
+	for _, item := range oi {
+		subtotal += item.Total
+	}
+	return subtotal
+}
@@ -0,0 +1,17 @@
+package models
+
+import "testing"
+
+func TestOrderIsOpen(t *testing.T) {
+	tests := map[string]bool{
+		OrderStatusPlaced:    true,
+		OrderStatusApproved:  true,
+		OrderStatusDelivered: false,
+		OrderStatusCancelled: false,
+	}
+	for status, open := range tests {
+		if (&Order{Status: status}).IsOpen() != open {
+			t.Errorf("%v order: got open %v, want %v", status, !open, open)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Payment statuses, a payment is pending until the provider has answered
+// and succeeds only when the provider confirms it through the webhook.
+const (
+	PaymentStatusPending    = "pending"
+	PaymentStatusAuthorized = "authorized"
+	PaymentStatusCaptured   = "captured"
+	PaymentStatusSucceeded  = "succeeded"
+	PaymentStatusFailed     = "failed"
+	PaymentStatusRefunded   = "refunded"
+)
+
+// Payment is an attempt to pay an order at a payment provider.
+type Payment struct {
+	ID        int       `json:"id"`
+	OrderID   int       `json:"orderId" db:"order_id"`
+	Provider  string    `json:"provider"`
+	Reference *string   `json:"reference,omitempty"` // id of the payment at the provider, nil until it answers
+	Status    string    `json:"status"`
+	Amount    Decimal   `json:"amount"`
+	Refunded  Decimal   `json:"refunded"`
+	Currency  string    `json:"currency"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// Open reports whether the payment may still take the money of its order.
+func (p *Payment) Open() bool {
+	switch p.Status {
+	case PaymentStatusPending, PaymentStatusAuthorized, PaymentStatusCaptured, PaymentStatusSucceeded:
+		return true
+	}
+	return false
+}
+
+// Refund statuses, a refund is pending from before the provider is asked until its answer is recorded.
+const (
+	RefundStatusPending   = "pending"
+	RefundStatusSucceeded = "succeeded"
+	RefundStatusFailed    = "failed"
+)
+
+// Refund gives back money of a succeeded payment through its provider. Pending refunds hold their amount,
+// so the payment cannot be refunded beyond what it took while the provider has not answered.
+type Refund struct {
+	ID        int       `json:"id"`
+	PaymentID int       `json:"paymentId" db:"payment_id"`
+	ReturnID  *int      `json:"returnId,omitempty" db:"return_id"` // return completed by the refund
+	Amount    Decimal   `json:"amount"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// Key identifies the refund at the provider.
+func (r *Refund) Key() string {
+	return fmt.Sprintf("refund_%d", r.ID)
+}
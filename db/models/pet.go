@@ -1,32 +1,211 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
 	"github.com/sirupsen/logrus"
 
 	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
 )
 
-var allowedPetStatuses = []string{"available", "pending", "sold"}
+const (
+	PetStatusAvailable = "available"
+	PetStatusPending   = "pending"
+	PetStatusSold      = "sold"
+)
+
+const (
+	PetSexMale    = "male"
+	PetSexFemale  = "female"
+	PetSexUnknown = "unknown"
+)
+
+const DefaultCurrency = "USD"
+
+var allowedPetStatuses = []string{PetStatusAvailable, PetStatusPending, PetStatusSold}
+
+var allowedPetSexes = []string{PetSexMale, PetSexFemale, PetSexUnknown}
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
 
 type Pet struct {
-	ID         int      `json:"id"`
-	Name       string   `json:"name"`
-	Status     string   `json:"status"`
-	PhotoURLs  []string `json:"photoUrls" db:"photo_urls"`
-	Tags       []Tag    `json:"tags"`
-	Category   Category `json:"category"`
-	CategoryID int      `json:"-" db:"category_id"`
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	Species     string     `json:"species"`
+	Breed       string     `json:"breed"`
+	BirthDate   *Date      `json:"birthDate" db:"birth_date"`
+	Sex         string     `json:"sex"`
+	Description string     `json:"description"`
+	Weight      *float64   `json:"weight"` // kilograms
+	Price       Decimal    `json:"price"`
+	Currency    string     `json:"currency"`
+	PhotoURLs   []string   `json:"photoUrls" db:"photo_urls"`
+	Images      PetImages  `json:"images" db:"images"`
+	Tags        []Tag      `json:"tags"`
+	Category    Category   `json:"category"`
+	CategoryID  int        `json:"-" db:"category_id"`
+	Favorites   int        `json:"favorites"` // count of users who favorited the pet
+	Version     int        `json:"-" db:"version"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
+}
+
+// MarshalJSON adds the age computed from the birth date.
+func (p Pet) MarshalJSON() ([]byte, error) {
+	type Alias Pet
+	var age *Age
+	if p.BirthDate != nil {
+		a := p.BirthDate.AgeAt(time.Now())
+		age = &a
+	}
+	return json.Marshal(struct {
+		Alias
+		Age *Age `json:"age"`
+	}{Alias(p), age})
+}
+
+// PetImage is an uploaded image, Variants maps a size name to the storage key of that size.
+type PetImage struct {
+	Key      string            `json:"key"`
+	Variants map[string]string `json:"variants"`
+}
+
+type PetImages []PetImage
+
+func (pi PetImages) Value() (driver.Value, error) {
+	if pi == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(pi)
+}
+
+func (pi *PetImages) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*pi = PetImages{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("incompatible type for PetImages")
+	}
+	images := PetImages{}
+	err := json.Unmarshal(data, &images)
+	if err != nil {
+		return err
+	}
+	*pi = images
+	return nil
+}
+
+// Find returns the image with the given key or nil.
+func (pi PetImages) Find(key string) *PetImage {
+	for i := range pi {
+		if pi[i].Key == key {
+			return &pi[i]
+		}
+	}
+	return nil
+}
+
+// Remove returns images without the one with the given key.
+func (pi PetImages) Remove(key string) PetImages {
+	images := make(PetImages, 0, len(pi))
+	for _, image := range pi {
+		if image.Key != key {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// Reorder returns images in the order of the given keys, every image key must be listed exactly once.
+func (pi PetImages) Reorder(keys []string) (PetImages, error) {
+	if len(keys) != len(pi) {
+		return nil, ValidationError("every image key must be listed exactly once")
+	}
+	images := make(PetImages, 0, len(pi))
+	for _, key := range keys {
+		image := pi.Find(key)
+		if image == nil || images.Find(key) != nil {
+			return nil, ValidationError("every image key must be listed exactly once")
+		}
+		images = append(images, *image)
+	}
+	return images, nil
+}
+
+// Filenames returns storage keys of every variant of every image.
+func (pi PetImages) Filenames() []string {
+	var filenames []string
+	for _, image := range pi {
+		for _, filename := range image.Variants {
+			filenames = append(filenames, filename)
+		}
+	}
+	return filenames
 }
 
+// Validate checks the pet and fills the sex and currency left empty with their defaults.
 func (p *Pet) Validate() error {
 	err := p.CheckStatus(p.Status)
 	if err != nil {
 		logrus.Error(err)
 		return err
 	}
+	if p.Sex == "" {
+		p.Sex = PetSexUnknown
+	}
+	if !utils.ContainsString(p.Sex, allowedPetSexes) {
+		return ValidationError("sex must be one of: " + strings.Join(allowedPetSexes, ", "))
+	}
+	if p.Currency == "" {
+		p.Currency = DefaultCurrency
+	}
+	if !currencyCode.MatchString(p.Currency) {
+		return ValidationError("currency must be an ISO 4217 code")
+	}
+	if p.Price < 0 {
+		return ValidationError("price must not be negative")
+	}
+	if p.Weight != nil && *p.Weight <= 0 {
+		return ValidationError("weight must be positive")
+	}
+	if p.BirthDate != nil && p.BirthDate.After(time.Now()) {
+		return ValidationError("birth date must not be in the future")
+	}
 	return nil
 }
 
+// StripImageLinks drops photo urls which point to uploaded images,
+// they are generated on every read and must not be stored as external urls.
+func (p *Pet) StripImageLinks(images PetImages) {
+	urls := make([]string, 0, len(p.PhotoURLs))
+	for _, url := range p.PhotoURLs {
+		if !images.references(url) {
+			urls = append(urls, url)
+		}
+	}
+	p.PhotoURLs = urls
+}
+
+func (pi PetImages) references(url string) bool {
+	for _, image := range pi {
+		if strings.Contains(url, image.Key) {
+			return true
+		}
+	}
+	return false
+}
+
 func (Pet) CheckStatus(status string) error {
 	if utils.ContainsString(status, allowedPetStatuses) {
 		return nil
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+const (
+	PetActionCreate  = "create"
+	PetActionUpdate  = "update"
+	PetActionImages  = "images"
+	PetActionDelete  = "delete"
+	PetActionRestore = "restore"
+)
+
+// PetHistory is a single pet mutation, OldValues is nil for created pets and NewValues is nil for deleted ones.
+type PetHistory struct {
+	ID        int       `json:"id"`
+	PetID     int       `json:"petId"`
+	Action    string    `json:"action"`
+	OldValues *Pet      `json:"oldValues"`
+	NewValues *Pet      `json:"newValues"`
+	Actor     string    `json:"actor"`
+	ChangedAt time.Time `json:"changedAt"`
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Roles allowed to change pet statuses
+const (
+	PetRoleCustomer = "customer"
+	PetRoleAdmin    = "admin"
+	// PetRoleAnonymous is used for requests without a user, it cannot change pet statuses
+	PetRoleAnonymous = "anonymous"
+	// PetRoleSystem is used for changes made by the application itself, e.g. reservations and orders
+	PetRoleSystem = "system"
+)
+
+// petTransitions maps a status to the statuses it can move to and the roles allowed to do it
+var petTransitions = map[string]map[string][]string{
+	PetStatusAvailable: {
+		PetStatusPending: {PetRoleCustomer, PetRoleAdmin, PetRoleSystem},
+		PetStatusSold:    {PetRoleAdmin, PetRoleSystem},
+	},
+	PetStatusPending: {
+		PetStatusAvailable: {PetRoleAdmin, PetRoleSystem},
+		PetStatusSold:      {PetRoleAdmin, PetRoleSystem},
+	},
+	PetStatusSold: {
+		// returned pets
+		PetStatusAvailable: {PetRoleAdmin},
+	},
+}
+
+// CheckTransition returns a ValidationError describing why the role cannot move a pet
+// from one status to another, keeping the status is always allowed.
+func (Pet) CheckTransition(from, to, role string) error {
+	if from == to {
+		return nil
+	}
+	roles, ok := petTransitions[from][to]
+	if !ok {
+		allowed := make([]string, 0, len(petTransitions[from]))
+		for _, status := range allowedPetStatuses {
+			if _, ok := petTransitions[from][status]; ok {
+				allowed = append(allowed, status)
+			}
+		}
+		if len(allowed) == 0 {
+			return ValidationError(fmt.Sprintf("pet status cannot change from %v", from))
+		}
+		return ValidationError(fmt.Sprintf("pet status cannot change from %v to %v, allowed: %v",
+			from, to, strings.Join(allowed, ", ")))
+	}
+	for _, r := range roles {
+		if r == role {
+			return nil
+		}
+	}
+	return ValidationError(fmt.Sprintf("pet status change from %v to %v is allowed only for: %v",
+		from, to, strings.Join(roles, ", ")))
+}
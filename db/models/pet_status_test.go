@@ -0,0 +1,34 @@
+package models
+
+import "testing"
+
+func TestPetCheckTransition(t *testing.T) {
+	tests := []struct {
+		from, to, role string
+		allowed        bool
+	}{
+		{PetStatusAvailable, PetStatusPending, PetRoleCustomer, true},
+		{PetStatusAvailable, PetStatusPending, PetRoleAnonymous, false},
+		{PetStatusAvailable, PetStatusSold, PetRoleCustomer, false},
+		{PetStatusAvailable, PetStatusSold, PetRoleAdmin, true},
+		{PetStatusPending, PetStatusAvailable, PetRoleCustomer, false},
+		{PetStatusPending, PetStatusAvailable, PetRoleSystem, true},
+		{PetStatusPending, PetStatusSold, PetRoleAnonymous, false},
+		{PetStatusSold, PetStatusAvailable, PetRoleAdmin, true},
+		{PetStatusSold, PetStatusAvailable, PetRoleSystem, false},
+		{PetStatusSold, PetStatusPending, PetRoleAdmin, false},
+		// keeping the status is not a transition
+		{PetStatusSold, PetStatusSold, PetRoleAnonymous, true},
+	}
+	for _, test := range tests {
+		err := Pet{}.CheckTransition(test.from, test.to, test.role)
+		if test.allowed && err != nil {
+			t.Errorf("%v -> %v by %v: got %v, want allowed", test.from, test.to, test.role, err)
+		}
+		if !test.allowed {
+			if _, ok := err.(ValidationError); !ok {
+				t.Errorf("%v -> %v by %v: got %v, want ValidationError", test.from, test.to, test.role, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"sort"
+	"testing"
+)
+
+func testImages() PetImages {
+	return PetImages{
+		{Key: "a", Variants: map[string]string{"original": "1/a/original.png", "thumbnail": "1/a/thumbnail.png"}},
+		{Key: "b", Variants: map[string]string{"original": "1/b/original.png"}},
+		{Key: "c", Variants: map[string]string{"original": "1/c/original.png"}},
+	}
+}
+
+func imageKeys(images PetImages) []string {
+	keys := []string{}
+	for _, image := range images {
+		keys = append(keys, image.Key)
+	}
+	return keys
+}
+
+func TestPetImagesReorder(t *testing.T) {
+	images, err := testImages().Reorder([]string{"c", "a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := imageKeys(images); keys[0] != "c" || keys[1] != "a" || keys[2] != "b" {
+		t.Errorf("got %v, want [c a b]", keys)
+	}
+
+	invalid := [][]string{
+		{"a", "b"},
+		{"a", "b", "c", "c"},
+		{"a", "a", "b"},
+		{"a", "b", "x"},
+	}
+	for _, keys := range invalid {
+		_, err = testImages().Reorder(keys)
+		if _, ok := err.(ValidationError); !ok {
+			t.Errorf("keys %v: got %v, want ValidationError", keys, err)
+		}
+	}
+}
+
+func TestPetImagesRemove(t *testing.T) {
+	images := testImages()
+	left := images.Remove("b")
+	if keys := imageKeys(left); len(keys) != 2 || keys[0] != "a" || keys[1] != "c" {
+		t.Errorf("got %v, want [a c]", keys)
+	}
+	if len(images) != 3 {
+		t.Errorf("the removed image is gone from the original images")
+	}
+	if left := images.Remove("x"); len(left) != 3 {
+		t.Errorf("removing an unknown key changed the images: %v", imageKeys(left))
+	}
+}
+
+func TestPetImagesFilenames(t *testing.T) {
+	filenames := testImages().Filenames()
+	sort.Strings(filenames)
+	want := []string{"1/a/original.png", "1/a/thumbnail.png", "1/b/original.png", "1/c/original.png"}
+	if len(filenames) != len(want) {
+		t.Fatalf("got %v, want %v", filenames, want)
+	}
+	for i := range want {
+		if filenames[i] != want[i] {
+			t.Errorf("got %v, want %v", filenames, want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	ReservationActive    = "active"
+	ReservationCancelled = "cancelled"
+	ReservationExpired   = "expired"
+	// ReservationFulfilled reservations have been closed by an order of the pet placed by their holder
+	ReservationFulfilled = "fulfilled"
+)
+
+type Reservation struct {
+	ID         int        `json:"id"`
+	PetID      int        `json:"petId" db:"pet_id"`
+	UserID     int        `json:"userId" db:"user_id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"createdAt" db:"created_at"`
+	ExpiresAt  time.Time  `json:"expiresAt" db:"expires_at"`
+	ReleasedAt *time.Time `json:"releasedAt,omitempty" db:"released_at"`
+	// PetStatus is the status the pet is left in by the release of the reservation, empty when it has been deleted
+	PetStatus string `json:"-" db:"-"`
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Return statuses, a requested return is approved or rejected by the staff,
+// an approved one is refunded once the money is back with the customer.
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+	ReturnStatusRefunded  = "refunded"
+)
+
+// ReturnReasonMaxLength caps the reason given by the customer
+const ReturnReasonMaxLength = 1000
+
+// Return is a request of the customer to send pets of a delivered order back.
+type Return struct {
+	ID           int         `json:"id"`
+	OrderID      int         `json:"orderId" db:"order_id"`
+	UserID       *int        `json:"userId,omitempty" db:"user_id"`
+	Status       string      `json:"status"`
+	Reason       string      `json:"reason"`
+	Resolution   string      `json:"resolution,omitempty"` // note of the staff approving or rejecting the return
+	Items        ReturnItems `json:"items" db:"items"`
+	Amount       Decimal     `json:"amount"` // refund due, the order discount is shared among the lines
+	Currency     string      `json:"currency"`
+	CreditNoteID *int        `json:"creditNoteId,omitempty" db:"credit_note_id"`
+	PaymentID    *int        `json:"paymentId,omitempty" db:"payment_id"` // payment refunded, nil for refunds made outside the provider
+	CreatedAt    time.Time   `json:"createdAt" db:"created_at"`
+	UpdatedAt    time.Time   `json:"updatedAt" db:"updated_at"`
+	ResolvedAt   *time.Time  `json:"resolvedAt,omitempty" db:"resolved_at"`
+}
+
+// ReturnItem is a returned line of the order, the whole line goes back.
+type ReturnItem struct {
+	PetID    int     `json:"petId" db:"pet_id"`
+	Quantity int     `json:"quantity"`
+	Amount   Decimal `json:"amount"`
+}
+
+// ReturnItems are read from the database as a json array aggregated per return.
+type ReturnItems []*ReturnItem
+
+func (ri *ReturnItems) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*ri = ReturnItems{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("incompatible type for ReturnItems")
+	}
+	items := ReturnItems{}
+	err := json.Unmarshal(data, &items)
+	if err != nil {
+		return err
+	}
+	*ri = items
+	return nil
+}
+
+// Validate checks the request of the customer, no items mean every line of the order.
+func (r *Return) Validate() error {
+	r.Reason = strings.TrimSpace(r.Reason)
+	if r.Reason == "" {
+		return ValidationError("reason is required")
+	}
+	if len(r.Reason) > ReturnReasonMaxLength {
+		return ValidationError(fmt.Sprintf("reason must be at most %d characters", ReturnReasonMaxLength))
+	}
+	pets := make(map[int]bool, len(r.Items))
+	for i, item := range r.Items {
+		if item == nil || item.PetID < 1 {
+			return ValidationError(fmt.Sprintf("invalid pet id of item %d", i+1))
+		}
+		if pets[item.PetID] {
+			return ValidationError(fmt.Sprintf("pet %d is returned more than once", item.PetID))
+		}
+		pets[item.PetID] = true
+	}
+	return nil
+}
+
+// Price sets the amounts of the items returning the lines of the order. The order total, so its discount,
+// is shared among the lines by their totals and never beyond what is left of it after the returned amount.
+// The return of the last lines which have not been returned takes the rest of the total,
+// so rounding of the shares neither keeps a cent nor refunds one too many.
+func (r *Return) Price(order *Order, lines []*OrderItem, last bool, returned Decimal) {
+	left := order.Total - returned
+	r.Amount = 0
+	for i, item := range r.Items {
+		item.Amount = order.Total.Share(lines[i].Total, order.Subtotal)
+		if item.Amount > left-r.Amount {
+			item.Amount = left - r.Amount
+		}
+		r.Amount += item.Amount
+	}
+	if last && len(r.Items) > 0 {
+		r.Items[len(r.Items)-1].Amount += left - r.Amount
+		r.Amount = left
+	}
+}
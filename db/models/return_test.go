@@ -0,0 +1,84 @@
+package models
+
+import "testing"
+
+// discountedOrder has lines of 10.00, 20.00 and 30.00 with 10.00 off.
+func discountedOrder() (*Order, []*OrderItem) {
+	lines := []*OrderItem{{PetID: 1, Total: 1000}, {PetID: 2, Total: 2000}, {PetID: 3, Total: 3000}}
+	return &Order{Subtotal: 6000, Discount: 1000, Total: 5000}, lines
+}
+
+func returnOf(petIDs ...int) *Return {
+	r := &Return{}
+	for _, id := range petIDs {
+		r.Items = append(r.Items, &ReturnItem{PetID: id})
+	}
+	return r
+}
+
+func TestReturnPriceSharesDiscount(t *testing.T) {
+	order, lines := discountedOrder()
+
+	r := returnOf(1, 2, 3)
+	r.Price(order, lines, true, 0)
+	if r.Amount != order.Total {
+		t.Errorf("whole order: got %v, want the total %v", r.Amount, order.Total)
+	}
+	for i, want := range []Decimal{833, 1667, 2500} {
+		if r.Items[i].Amount != want {
+			t.Errorf("line %d: got %v, want %v", i+1, r.Items[i].Amount, want)
+		}
+	}
+
+	// returned one line at a time, the amounts add up to the total
+	var returned Decimal
+	for i := range lines {
+		r := returnOf(lines[i].PetID)
+		r.Price(order, lines[i:i+1], i == len(lines)-1, returned)
+		returned += r.Amount
+	}
+	if returned != order.Total {
+		t.Errorf("line by line: got %v returned, want %v", returned, order.Total)
+	}
+}
+
+func TestReturnPriceNeverExceedsTotal(t *testing.T) {
+	// every share of 0.02 out of four equal lines rounds 0.005 up
+	lines := []*OrderItem{{PetID: 1, Total: 100}, {PetID: 2, Total: 100}, {PetID: 3, Total: 100}, {PetID: 4, Total: 100}}
+	order := &Order{Subtotal: 400, Discount: 398, Total: 2}
+	var returned Decimal
+	for i := range lines {
+		r := returnOf(lines[i].PetID)
+		r.Price(order, lines[i:i+1], i == len(lines)-1, returned)
+		if r.Amount < 0 {
+			t.Errorf("line %d: got negative amount %v", i+1, r.Amount)
+		}
+		returned += r.Amount
+		if returned > order.Total {
+			t.Fatalf("line %d: got %v returned out of %v", i+1, returned, order.Total)
+		}
+	}
+	if returned != order.Total {
+		t.Errorf("got %v returned, want %v", returned, order.Total)
+	}
+}
+
+func TestReturnValidate(t *testing.T) {
+	valid := returnOf(1, 2)
+	valid.Reason = "  wrong color  "
+	if err := valid.Validate(); err != nil || valid.Reason != "wrong color" {
+		t.Errorf("got %v, reason %q", err, valid.Reason)
+	}
+	invalid := []*Return{
+		{Reason: " "},
+		{Reason: string(make([]byte, ReturnReasonMaxLength+1))},
+		{Reason: "twice", Items: ReturnItems{{PetID: 1}, {PetID: 1}}},
+		{Reason: "no pet", Items: ReturnItems{{PetID: 0}}},
+		{Reason: "nil item", Items: ReturnItems{nil}},
+	}
+	for i, r := range invalid {
+		if _, ok := r.Validate().(ValidationError); !ok {
+			t.Errorf("return %d has been accepted", i+1)
+		}
+	}
+}
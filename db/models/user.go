@@ -13,6 +13,7 @@ type User struct {
 	Password   string `json:"password"`
 	Phone      string `json:"phone"`
 	UserStatus int    `json:"userStatus" db:"user_status"`
+	Version    int    `json:"-" db:"version"`
 }
 
 func (u *User) MarshalJSON() (output []byte, err error) {
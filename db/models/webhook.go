@@ -0,0 +1,101 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+)
+
+// Webhook event types
+const (
+	EventPetCreated       = "pet.created"
+	EventPetUpdated       = "pet.updated"
+	EventPetDeleted       = "pet.deleted"
+	EventPetRestored      = "pet.restored"
+	EventPetStatusChanged = "pet.status_changed"
+	EventUserCreated      = "user.created"
+	EventUserUpdated      = "user.updated"
+	EventUserDeleted      = "user.deleted"
+	// EventOrderPrefix is followed by the order timeline event, e.g. order.placed or order.payment_succeeded
+	EventOrderPrefix = "order."
+)
+
+// WebhookEventTypes are the event types subscriptions can choose from.
+var WebhookEventTypes = []string{
+	EventPetCreated, EventPetUpdated, EventPetDeleted, EventPetRestored, EventPetStatusChanged,
+	EventUserCreated, EventUserUpdated, EventUserDeleted,
+	EventOrderPrefix + OrderEventPlaced,
+	EventOrderPrefix + OrderEventApproved,
+	EventOrderPrefix + OrderEventDelivered,
+	EventOrderPrefix + OrderEventCancelled,
+	EventOrderPrefix + OrderEventPaymentSucceeded,
+	EventOrderPrefix + OrderEventPaymentFailed,
+	EventOrderPrefix + OrderEventRefunded,
+	EventOrderPrefix + OrderEventReturnRequested,
+	EventOrderPrefix + OrderEventReturnApproved,
+	EventOrderPrefix + OrderEventReturnRejected,
+	EventOrderPrefix + OrderEventReturnRefunded,
+	EventOrderPrefix + OrderEventCreditNote,
+}
+
+// Webhook delivery statuses
+const (
+	// DeliveryPending deliveries are sent when NextAttemptAt comes
+	DeliveryPending = "pending"
+	// DeliveryDelivered deliveries have been accepted by the receiver with a 2xx response
+	DeliveryDelivered = "delivered"
+	// DeliveryDead deliveries have failed every attempt, they are only sent again when redelivered
+	DeliveryDead = "dead"
+)
+
+// WebhookSubscription sends events of the chosen types to the URL, no event types means every event.
+// The secret signs the deliveries, it is generated by the store and shown once when the subscription is created.
+type WebhookSubscription struct {
+	ID          int            `json:"id"`
+	URL         string         `json:"url"`
+	Secret      string         `json:"secret,omitempty"`
+	EventTypes  pq.StringArray `json:"eventTypes" db:"event_types"`
+	Description string         `json:"description"`
+	Active      bool           `json:"active"`
+	CreatedAt   time.Time      `json:"createdAt" db:"created_at"`
+	UpdatedAt   time.Time      `json:"updatedAt" db:"updated_at"`
+}
+
+func (s *WebhookSubscription) Validate() error {
+	target, err := url.Parse(s.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return ValidationError("url must be an absolute http or https URL")
+	}
+	if s.EventTypes == nil {
+		s.EventTypes = pq.StringArray{}
+	}
+	for _, eventType := range s.EventTypes {
+		if !utils.ContainsString(eventType, WebhookEventTypes) {
+			return ValidationError(fmt.Sprintf("unknown event type %v", eventType))
+		}
+	}
+	return nil
+}
+
+// WebhookDelivery is an event sent to a subscription, Attempts counts the requests made so far.
+type WebhookDelivery struct {
+	ID             int             `json:"id"`
+	EventID        int64           `json:"eventId" db:"event_id"`
+	SubscriptionID int             `json:"subscriptionId" db:"subscription_id"`
+	EventType      string          `json:"eventType" db:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	EventCreatedAt time.Time       `json:"eventCreatedAt" db:"event_created_at"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt" db:"next_attempt_at"`
+	ResponseCode   *int            `json:"responseCode,omitempty" db:"response_code"`
+	LastError      string          `json:"lastError,omitempty" db:"last_error"`
+	DeliveredAt    *time.Time      `json:"deliveredAt,omitempty" db:"delivered_at"`
+	CreatedAt      time.Time       `json:"createdAt" db:"created_at"`
+	UpdatedAt      time.Time       `json:"updatedAt" db:"updated_at"`
+}
@@ -3,6 +3,7 @@ package jwt
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -66,7 +67,7 @@ func (jwa AuthService) Authenticate(w http.ResponseWriter, r *http.Request, user
 	jwa.mx.Lock()
 	jwa.users[tokenString] = user
 	jwa.mx.Unlock()
-	w.Header().Set("X-Expires-After", string(expiresAt.Unix()))
+	w.Header().Set("X-Expires-After", strconv.FormatInt(expiresAt.Unix(), 10))
 
 	return nil
 }
@@ -11,6 +11,8 @@ import (
 
 type Config struct {
 	Type string
+	// Admins are usernames of the store staff with administrative access
+	Admins []string
 }
 
 type ServiceInterface interface {
@@ -23,8 +25,10 @@ type ServiceInterface interface {
 }
 
 var service ServiceInterface
+var admins []string
 
 func Init(config Config) {
+	admins = config.Admins
 	switch config.Type {
 	case "jwt":
 		service = jwt2.NewJwtAuthService()
@@ -40,3 +44,15 @@ func GetAuthService() ServiceInterface {
 	}
 	return service
 }
+
+func IsAdmin(user *models.User) bool {
+	if user == nil {
+		return false
+	}
+	for _, username := range admins {
+		if username == user.Username {
+			return true
+		}
+	}
+	return false
+}
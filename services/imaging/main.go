@@ -0,0 +1,142 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	SizeOriginal  = "original"
+	SizeMedium    = "medium"
+	SizeThumbnail = "thumbnail"
+)
+
+const jpegQuality = 85
+
+// maxPixels limits the size of decoded images, a small file can declare a huge image
+const maxPixels = 40 * 1000 * 1000
+
+// longest side in pixels for every generated variant
+var variantBounds = map[string]int{
+	SizeThumbnail: 150,
+	SizeMedium:    600,
+}
+
+var formats = map[string]struct {
+	contentType string
+	extension   string
+}{
+	"jpeg": {contentType: "image/jpeg", extension: "jpg"},
+	"png":  {contentType: "image/png", extension: "png"},
+}
+
+var Sizes = []string{SizeOriginal, SizeMedium, SizeThumbnail}
+
+type Variant struct {
+	Size        string
+	ContentType string
+	Extension   string
+	Data        []byte
+}
+
+// Variants returns the original image followed by its resized copies.
+// Resized copies keep the format of the original.
+// Images larger than maxPixels are refused before they are decoded.
+func Variants(data []byte) ([]Variant, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "image decode error")
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, errors.Errorf("image of %dx%d pixels is too large", config.Width, config.Height)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "image decode error")
+	}
+	f, ok := formats[format]
+	if !ok {
+		return nil, errors.Errorf("unsupported image format %v", format)
+	}
+
+	variants := []Variant{{Size: SizeOriginal, ContentType: f.contentType, Extension: f.extension, Data: data}}
+	for _, size := range []string{SizeMedium, SizeThumbnail} {
+		var buf bytes.Buffer
+		err = encode(&buf, Resize(img, variantBounds[size]), format)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%v variant encode error", size)
+		}
+		variants = append(variants, Variant{Size: size, ContentType: f.contentType, Extension: f.extension, Data: buf.Bytes()})
+	}
+	return variants, nil
+}
+
+func IsSize(size string) bool {
+	for _, s := range Sizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// Resize scales the image down so its longest side fits maxSide, averaging
+// every source pixel that falls into a destination pixel.
+// Images that already fit are returned as is.
+func Resize(src image.Image, maxSide int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSide && h <= maxSide {
+		return src
+	}
+	dw, dh := maxSide, maxSide
+	if w >= h {
+		dh = h * maxSide / w
+	} else {
+		dw = w * maxSide / h
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := b.Min.Y+y*h/dh, b.Min.Y+(y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := b.Min.X+x*w/dw, b.Min.X+(x+1)*w/dw
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
+
+func encode(buf *bytes.Buffer, img image.Image, format string) error {
+	if format == "png" {
+		return png.Encode(buf, img)
+	}
+	return jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQuality})
+}
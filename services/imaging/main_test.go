@@ -0,0 +1,85 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestVariants(t *testing.T) {
+	data := testPNG(t, 800, 400)
+	variants, err := Variants(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variants) != 3 {
+		t.Fatalf("got %d variants, want 3", len(variants))
+	}
+	if variants[0].Size != SizeOriginal || !bytes.Equal(variants[0].Data, data) {
+		t.Errorf("first variant is not the original")
+	}
+	bounds := map[string]image.Point{SizeMedium: {600, 300}, SizeThumbnail: {150, 75}}
+	for _, v := range variants[1:] {
+		if v.ContentType != "image/png" || v.Extension != "png" {
+			t.Errorf("%v variant is %v %v, want the png format of the original", v.Size, v.ContentType, v.Extension)
+		}
+		img, err := png.Decode(bytes.NewReader(v.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := img.Bounds().Size(); size != bounds[v.Size] {
+			t.Errorf("%v variant is %v, want %v", v.Size, size, bounds[v.Size])
+		}
+	}
+}
+
+func TestVariantsRefusesLargeImages(t *testing.T) {
+	data := testPNG(t, 1, 1)
+	// the header declares 100000x100000 pixels, the pixel data stays tiny
+	ihdr := data[8+8 : 8+8+13]
+	binary.BigEndian.PutUint32(ihdr[0:4], 100000)
+	binary.BigEndian.PutUint32(ihdr[4:8], 100000)
+	binary.BigEndian.PutUint32(data[8+8+13:], crc32.ChecksumIEEE(data[8+4:8+8+13]))
+
+	_, err := Variants(data)
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("image of 10^10 pixels: got %v, want too large error", err)
+	}
+}
+
+func TestVariantsRefusesOtherData(t *testing.T) {
+	_, err := Variants([]byte("not an image"))
+	if err == nil {
+		t.Fatal("invalid data has been accepted")
+	}
+}
+
+func TestResizeKeepsSmallImages(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 20))
+	if Resize(img, 150) != img {
+		t.Error("image smaller than the bounds has been resized")
+	}
+	if size := Resize(img, 5).Bounds().Size(); size != (image.Point{X: 2, Y: 5}) {
+		t.Errorf("resized to %v, want (2,5)", size)
+	}
+}
@@ -0,0 +1,86 @@
+package invoicing
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"text/template"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+const (
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+	FormatPDF      = "pdf"
+)
+
+const (
+	markdownTemplatePath = "resources/templates/invoice.tmpl"
+	htmlTemplatePath     = "resources/templates/invoice.html"
+)
+
+// DefaultFormats are rendered when no formats are configured
+var DefaultFormats = []string{FormatMarkdown}
+
+// Data is the content of an invoice, the totals are by currency.
+type Data struct {
+	Invoice       *models.Invoice
+	Orders        []*models.Order
+	CreditNotes   []*models.CreditNote
+	Date          string
+	TotalQuantity int
+	Totals        models.InvoiceTotals
+}
+
+// Renderer writes invoices in a single format.
+type Renderer interface {
+	Render(w io.Writer, data *Data) error
+}
+
+var formats = map[string]struct {
+	contentType string
+	extension   string
+	renderer    Renderer
+}{
+	FormatMarkdown: {contentType: "text/markdown; charset=UTF-8", extension: "md", renderer: markdownRenderer{}},
+	FormatHTML:     {contentType: "text/html; charset=UTF-8", extension: "html", renderer: htmlRenderer{}},
+	FormatPDF:      {contentType: "application/pdf", extension: "pdf", renderer: pdfRenderer{}},
+}
+
+func NewRenderer(format string) (Renderer, error) {
+	f, ok := formats[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported invoice format %v", format)
+	}
+	return f.renderer, nil
+}
+
+func ContentType(format string) string {
+	return formats[format].contentType
+}
+
+// Extension is the file name extension of the documents in the format, without the dot.
+func Extension(format string) string {
+	return formats[format].extension
+}
+
+type markdownRenderer struct{}
+
+func (markdownRenderer) Render(w io.Writer, data *Data) error {
+	t, err := template.New("invoice.tmpl").ParseFiles(markdownTemplatePath)
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, data)
+}
+
+type htmlRenderer struct{}
+
+func (htmlRenderer) Render(w io.Writer, data *Data) error {
+	t, err := htmltemplate.New("invoice.html").ParseFiles(htmlTemplatePath)
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, data)
+}
@@ -0,0 +1,203 @@
+package invoicing
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// PDF invoices are A4 landscape, sizes are in points
+const (
+	pdfPageWidth   = 842
+	pdfPageHeight  = 595
+	pdfMargin      = 36
+	pdfFontSize    = 8
+	pdfRowHeight   = 12
+	pdfCellPadding = 4
+	pdfTimeFormat  = "2006-01-02 15:04"
+	pdfDateFormat  = "2006-01-02"
+)
+
+// pdfColumn is a table column, its width is in characters
+type pdfColumn struct {
+	title string
+	width int
+	right bool
+}
+
+type pdfRenderer struct{}
+
+// Render lays the invoice out as a header with the period, tables of the orders, their lines,
+// credit notes and totals, and the page numbers in the footer. Tables continue on next pages with their header.
+func (pdfRenderer) Render(w io.Writer, data *Data) error {
+	title := "Invoice"
+	if data.Invoice != nil && data.Invoice.Number != 0 {
+		title = fmt.Sprintf("Invoice %d", data.Invoice.Number)
+	}
+	l := &pdfLayout{doc: newPDFDocument(pdfPageWidth, pdfPageHeight)}
+	l.newPage()
+	l.doc.Text(pdfFontTitle, 18, pdfMargin, l.y+18, title)
+	l.y += 30
+	if data.Invoice != nil {
+		l.text(fmt.Sprintf("Period: %v - %v UTC",
+			data.Invoice.PeriodStart.UTC().Format(pdfTimeFormat), data.Invoice.PeriodEnd.UTC().Format(pdfTimeFormat)))
+	}
+	l.text("Date: " + data.Date)
+
+	if len(data.Orders) > 0 {
+		l.heading("Orders")
+		rows := make([][]string, 0, len(data.Orders))
+		for _, o := range data.Orders {
+			shipDate, coupon := "", ""
+			if o.ShipDate != nil {
+				shipDate = o.ShipDate.UTC().Format(pdfDateFormat)
+			}
+			if o.CouponCode != nil {
+				coupon = *o.CouponCode
+			}
+			rows = append(rows, []string{strconv.Itoa(o.ID), strconv.Itoa(o.Quantity), o.CreatedAt.UTC().Format(pdfTimeFormat),
+				shipDate, strconv.FormatBool(o.Complete), o.Status, o.Subtotal.String(), o.Discount.String(), coupon,
+				o.Total.String(), o.Currency})
+		}
+		l.table([]pdfColumn{{"Order", 8, true}, {"Quantity", 8, true}, {"Created", 16, false}, {"Ship date", 10, false},
+			{"Complete", 8, false}, {"Status", 9, false}, {"Subtotal", 12, true}, {"Discount", 12, true},
+			{"Coupon", 16, false}, {"Total", 12, true}, {"Currency", 8, false}}, rows)
+
+		l.heading("Lines")
+		rows = rows[:0]
+		for _, o := range data.Orders {
+			for _, item := range o.Items {
+				rows = append(rows, []string{strconv.Itoa(o.ID), strconv.Itoa(item.PetID), strconv.Itoa(item.Quantity),
+					item.UnitPrice.String(), item.Total.String(), item.Currency})
+			}
+		}
+		l.table([]pdfColumn{{"Order", 8, true}, {"Pet", 8, true}, {"Quantity", 8, true}, {"Unit price", 12, true},
+			{"Total", 12, true}, {"Currency", 8, false}}, rows)
+		l.text(fmt.Sprintf("Total orders: %d, total sold pets count: %d", len(data.Orders), data.TotalQuantity))
+	} else {
+		l.heading("No orders for that period")
+	}
+
+	if len(data.CreditNotes) > 0 {
+		l.heading("Credit notes")
+		rows := make([][]string, 0, len(data.CreditNotes))
+		for _, n := range data.CreditNotes {
+			returnID := ""
+			if n.ReturnID != nil {
+				returnID = strconv.Itoa(*n.ReturnID)
+			}
+			rows = append(rows, []string{strconv.Itoa(n.ID), strconv.Itoa(n.OrderID), returnID,
+				n.CreatedAt.UTC().Format(pdfTimeFormat), n.Reason, "-" + n.Amount.String(), n.Currency})
+		}
+		l.table([]pdfColumn{{"Credit note", 11, true}, {"Order", 8, true}, {"Return", 8, true}, {"Created", 16, false},
+			{"Reason", 40, false}, {"Amount", 12, true}, {"Currency", 8, false}}, rows)
+	}
+
+	l.heading("Totals")
+	rows := make([][]string, 0, len(data.Totals))
+	for _, t := range data.Totals {
+		rows = append(rows, []string{t.Currency, t.Subtotal.String(), t.Discount.String(), t.Total.String(),
+			t.Credited.String(), t.Net.String()})
+	}
+	l.table([]pdfColumn{{"Currency", 8, false}, {"Subtotal", 14, true}, {"Discount", 14, true}, {"Total", 14, true},
+		{"Credited", 14, true}, {"Net", 14, true}}, rows)
+
+	pages := l.doc.PageCount()
+	for page := 1; page <= pages; page++ {
+		l.doc.SetPage(page)
+		footer := fmt.Sprintf("Page %d of %d", page, pages)
+		l.doc.Text(pdfFontRegular, pdfFontSize, pdfMargin, pdfPageHeight-pdfMargin/2, title)
+		l.doc.Text(pdfFontRegular, pdfFontSize, pdfPageWidth-pdfMargin-textWidth(footer), pdfPageHeight-pdfMargin/2, footer)
+	}
+	return l.doc.Write(w)
+}
+
+// pdfLayout places content from the top of the page down, y is the top of the next content.
+type pdfLayout struct {
+	doc *pdfDocument
+	y   float64
+}
+
+func (l *pdfLayout) newPage() {
+	l.doc.AddPage()
+	l.y = pdfMargin
+}
+
+// ensure starts a new page unless the height fits on the current one and tells whether it has.
+func (l *pdfLayout) ensure(height float64) bool {
+	if l.y+height <= pdfPageHeight-pdfMargin {
+		return false
+	}
+	l.newPage()
+	return true
+}
+
+func (l *pdfLayout) heading(text string) {
+	// a heading is kept together with the header and the first row of its table
+	l.ensure(20 + 2*pdfRowHeight)
+	l.y += 20
+	l.doc.Text(pdfFontTitle, 11, pdfMargin, l.y-4, text)
+}
+
+func (l *pdfLayout) text(text string) {
+	l.ensure(pdfRowHeight)
+	l.doc.Text(pdfFontRegular, pdfFontSize+1, pdfMargin, l.y+pdfRowHeight-3, text)
+	l.y += pdfRowHeight
+}
+
+func (l *pdfLayout) table(columns []pdfColumn, rows [][]string) {
+	var width float64
+	titles := make([]string, len(columns))
+	for i, column := range columns {
+		width += columnWidth(column)
+		titles[i] = column.title
+	}
+	header := func() {
+		l.doc.Box(pdfMargin, l.y, width, pdfRowHeight, 0.85)
+		l.row(pdfFontBold, columns, titles)
+	}
+	l.ensure(2 * pdfRowHeight)
+	header()
+	for _, cells := range rows {
+		if l.ensure(pdfRowHeight) {
+			header()
+		}
+		l.row(pdfFontRegular, columns, cells)
+		l.doc.Line(pdfMargin, l.y, pdfMargin+width, l.y, 0.25)
+	}
+}
+
+func (l *pdfLayout) row(font string, columns []pdfColumn, cells []string) {
+	x := float64(pdfMargin)
+	for i, column := range columns {
+		text := fit(cells[i], column.width)
+		offset := float64(pdfCellPadding)
+		if column.right {
+			offset += float64(column.width)*pdfFontSize*pdfCharWidth - textWidth(text)
+		}
+		l.doc.Text(font, pdfFontSize, x+offset, l.y+pdfRowHeight-3.5, text)
+		x += columnWidth(column)
+	}
+	l.y += pdfRowHeight
+}
+
+func columnWidth(column pdfColumn) float64 {
+	return float64(column.width)*pdfFontSize*pdfCharWidth + 2*pdfCellPadding
+}
+
+// textWidth is the width of the text in the regular font.
+func textWidth(text string) float64 {
+	return float64(len([]rune(text))) * pdfFontSize * pdfCharWidth
+}
+
+// fit shortens the text to the count of characters, cut texts end with dots.
+func fit(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	if width <= 3 {
+		return string(runes[:width])
+	}
+	return string(runes[:width-3]) + "..."
+}
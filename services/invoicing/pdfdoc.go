@@ -0,0 +1,148 @@
+package invoicing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Fonts of pdfDocument, they are standard PDF fonts every reader has, so nothing is embedded
+const (
+	pdfFontRegular = "F1"
+	pdfFontBold    = "F2"
+	pdfFontTitle   = "F3"
+)
+
+var pdfFonts = []struct {
+	name     string
+	baseFont string
+}{
+	{pdfFontRegular, "Courier"},
+	{pdfFontBold, "Courier-Bold"},
+	{pdfFontTitle, "Helvetica-Bold"},
+}
+
+// pdfCharWidth is the width of every character of the Courier fonts in units of the font size
+const pdfCharWidth = 0.6
+
+// pdfDocument is a minimal PDF writer for text, lines and shaded boxes.
+// Positions are in points from the top left corner of the page, text is placed by its baseline.
+type pdfDocument struct {
+	width   float64
+	height  float64
+	pages   []*bytes.Buffer
+	current int
+}
+
+func newPDFDocument(width, height float64) *pdfDocument {
+	return &pdfDocument{width: width, height: height}
+}
+
+// AddPage adds a page after the others and makes it the current one.
+func (d *pdfDocument) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.current = len(d.pages) - 1
+}
+
+func (d *pdfDocument) PageCount() int {
+	return len(d.pages)
+}
+
+// SetPage makes the page of the number counted from 1 the current one, e.g. to add footers at the end.
+func (d *pdfDocument) SetPage(number int) {
+	d.current = number - 1
+}
+
+func (d *pdfDocument) Text(font string, size, x, y float64, text string) {
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, d.height-y, pdfString(text))
+}
+
+func (d *pdfDocument) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(d.page(), "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, d.height-y1, x2, d.height-y2)
+}
+
+// Box fills the rectangle with the gray level, 0 is black and 1 is white.
+func (d *pdfDocument) Box(x, y, width, height, gray float64) {
+	fmt.Fprintf(d.page(), "%.2f g %.2f %.2f %.2f %.2f re f 0 g\n", gray, x, d.height-y-height, width, height)
+}
+
+func (d *pdfDocument) page() *bytes.Buffer {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	return d.pages[d.current]
+}
+
+// Write writes the whole document, the page contents are compressed.
+func (d *pdfDocument) Write(w io.Writer) error {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	out := &bytes.Buffer{}
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	// catalog, page tree and fonts come first, every page is followed by its contents
+	firstPage := 3 + len(pdfFonts)
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	fonts := make([]string, len(pdfFonts))
+	for i, font := range pdfFonts {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", font.baseFont))
+		fonts[i] = fmt.Sprintf("/%s %d 0 R", font.name, 3+i)
+	}
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			d.width, d.height, strings.Join(fonts, " "), firstPage+2*i+1))
+		var content bytes.Buffer
+		zw := zlib.NewWriter(&content)
+		_, err := zw.Write(page.Bytes())
+		if err != nil {
+			return err
+		}
+		err = zw.Close()
+		if err != nil {
+			return err
+		}
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", content.Len(), content.Bytes()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	_, err := out.WriteTo(w)
+	return err
+}
+
+// pdfString encodes the text as a literal string of a WinAnsiEncoding font,
+// characters the encoding does not have are replaced with question marks.
+func pdfString(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n' || r == '\r' || r == '\t':
+			b.WriteByte(' ')
+		case r >= 0x20 && r < 0x7f || r >= 0xa0 && r <= 0xff:
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
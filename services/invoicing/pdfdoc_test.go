@@ -0,0 +1,99 @@
+package invoicing
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPDFDocumentCrossReference(t *testing.T) {
+	d := newPDFDocument(200, 100)
+	d.Text(pdfFontRegular, 8, 10, 20, "first page")
+	d.AddPage()
+	d.Box(10, 10, 50, 20, 0.9)
+	d.SetPage(1)
+	d.Line(10, 30, 100, 30, 0.5)
+	var out bytes.Buffer
+	if err := d.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	pdf := out.Bytes()
+
+	trailer := regexp.MustCompile(`trailer\n<< /Size (\d+) /Root 1 0 R >>\nstartxref\n(\d+)\n%%EOF\n$`).FindSubmatch(pdf)
+	if trailer == nil {
+		t.Fatalf("no trailer at the end of %q", pdf[len(pdf)-64:])
+	}
+	size, _ := strconv.Atoi(string(trailer[1]))
+	xref, _ := strconv.Atoi(string(trailer[2]))
+	// catalog, page tree, three fonts and two objects of each of the two pages
+	if want := 1 + 2 + len(pdfFonts) + 2*2; size != want {
+		t.Errorf("got /Size %d, want %d", size, want)
+	}
+	if !bytes.HasPrefix(pdf[xref:], []byte(fmt.Sprintf("xref\n0 %d\n0000000000 65535 f \n", size))) {
+		t.Fatalf("startxref %d does not point at the cross reference table: %q", xref, pdf[xref:xref+32])
+	}
+	entries := strings.Split(string(pdf[xref:]), "\n")[3 : 3+size-1]
+	for i, entry := range entries {
+		if len(entry) != 19 || !strings.HasSuffix(entry, " 00000 n ") {
+			t.Fatalf("entry %d is %q, want 20 bytes with the line end", i+1, entry)
+		}
+		offset, _ := strconv.Atoi(entry[:10])
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(pdf[offset:], []byte(want)) {
+			t.Errorf("object %d: offset %d points at %q", i+1, offset, pdf[offset:offset+16])
+		}
+	}
+
+	object := func(number int) string {
+		entry := entries[number-1]
+		offset, _ := strconv.Atoi(entry[:10])
+		body := string(pdf[offset:])
+		return body[:strings.Index(body, "endobj")]
+	}
+	if !strings.Contains(object(1), "/Type /Catalog /Pages 2 0 R") {
+		t.Errorf("object 1 is not the catalog: %q", object(1))
+	}
+	if !strings.Contains(object(2), "/Kids [6 0 R 8 0 R] /Count 2") {
+		t.Errorf("object 2 does not list the pages: %q", object(2))
+	}
+	for i, font := range pdfFonts {
+		if !strings.Contains(object(3+i), "/BaseFont /"+font.baseFont) {
+			t.Errorf("object %d is not font %v: %q", 3+i, font.baseFont, object(3+i))
+		}
+	}
+	for _, page := range []int{6, 8} {
+		fonts := fmt.Sprintf("/%s 3 0 R /%s 4 0 R /%s 5 0 R", pdfFontRegular, pdfFontBold, pdfFontTitle)
+		if !strings.Contains(object(page), "/Type /Page /Parent 2 0 R") || !strings.Contains(object(page), fonts) ||
+			!strings.Contains(object(page), fmt.Sprintf("/Contents %d 0 R", page+1)) {
+			t.Errorf("object %d is not a page with its contents next: %q", page, object(page))
+		}
+		contents := regexp.MustCompile(`(?s)^\d+ 0 obj\n<< /Length (\d+) /Filter /FlateDecode >>\nstream\n(.*)\nendstream\n$`).
+			FindStringSubmatch(object(page + 1))
+		if contents == nil {
+			t.Fatalf("object %d is not a content stream", page+1)
+		}
+		if length, _ := strconv.Atoi(contents[1]); length != len(contents[2]) {
+			t.Errorf("object %d: got /Length %v of a %d bytes stream", page+1, contents[1], len(contents[2]))
+		}
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Invoice 12", "Invoice 12"},
+		{`a (b) \c`, `a \(b\) \\c`},
+		{"two\nlines\ttab", "two lines tab"},
+		{"café", "caf\xe9"},
+		{"€ 5 🐶", "? 5 ?"},
+	}
+	for _, test := range tests {
+		if got := pdfString(test.text); got != test.want {
+			t.Errorf("pdfString(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
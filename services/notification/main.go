@@ -0,0 +1,46 @@
+package notification
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+type Config struct {
+	Type string
+}
+
+type Notifier interface {
+	Notify(user *models.User, subject, message string) error
+}
+
+var notifier Notifier
+
+func Init(config Config) {
+	switch config.Type {
+	case "log", "":
+		notifier = LogNotifier{}
+		logrus.Info("log notifier initialized")
+	default:
+		logrus.Fatalf("unsupported notification type")
+	}
+}
+
+func GetNotifier() Notifier {
+	if notifier == nil {
+		logrus.Fatalf("notifier has not initialized")
+	}
+	return notifier
+}
+
+// LogNotifier writes notifications to the application log, it is meant for development.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(user *models.User, subject, message string) error {
+	logrus.WithFields(logrus.Fields{
+		"username": user.Username,
+		"email":    user.Email,
+		"subject":  subject,
+	}).Info(message)
+	return nil
+}
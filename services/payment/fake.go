@@ -0,0 +1,187 @@
+package payment
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+const (
+	// SignatureHeader carries the hex encoded HMAC-SHA256 of the webhook body
+	SignatureHeader = "X-Payment-Signature"
+	// FakeDeclinedMethod is the payment method the fake provider always declines
+	FakeDeclinedMethod = "fake_declined"
+)
+
+type FakeConfig struct {
+	// Secret signs webhook events
+	Secret string
+	// WebhookURL receives events of captured payments when set, e.g. "http://localhost:8080/payments/webhook"
+	WebhookURL string
+}
+
+// fakeEvent is the webhook body sent by the fake provider.
+type fakeEvent struct {
+	Reference string `json:"reference"`
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+}
+
+type fakePayment struct {
+	amount   models.Decimal
+	refunded models.Decimal
+	status   string
+}
+
+// FakeProvider keeps payments in memory and signs its webhook events, it is meant for development and tests.
+// Every method but FakeDeclinedMethod is authorized, captured payments succeed.
+type FakeProvider struct {
+	secret     []byte
+	webhookURL string
+	client     *http.Client
+	mx         *sync.Mutex
+	payments   map[string]*fakePayment
+	// refunds holds the results of refunds by their keys
+	refunds map[string]Result
+}
+
+func NewFakeProvider(config FakeConfig) FakeProvider {
+	return FakeProvider{
+		secret:     []byte(config.Secret),
+		webhookURL: config.WebhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		mx:         &sync.Mutex{},
+		payments:   map[string]*fakePayment{},
+		refunds:    map[string]Result{},
+	}
+}
+
+func (FakeProvider) Name() string {
+	return "fake"
+}
+
+func (f FakeProvider) Authorize(req AuthorizeRequest) (Result, error) {
+	if req.Method == FakeDeclinedMethod {
+		return Result{Status: StatusFailed}, DeclinedError("payment declined by the fake provider")
+	}
+	if req.Amount <= 0 {
+		return Result{Status: StatusFailed}, DeclinedError("amount must be positive")
+	}
+	reference := "fake_" + randomHex(12)
+	f.mx.Lock()
+	f.payments[reference] = &fakePayment{amount: req.Amount, status: StatusAuthorized}
+	f.mx.Unlock()
+	return Result{Reference: reference, Status: StatusAuthorized}, nil
+}
+
+// Capture takes the authorized payment, its success is sent to the webhook when WebhookURL is set.
+func (f FakeProvider) Capture(reference string) (Result, error) {
+	f.mx.Lock()
+	p, ok := f.payments[reference]
+	if ok && p.status == StatusAuthorized {
+		p.status = StatusCaptured
+	}
+	f.mx.Unlock()
+	if !ok {
+		return Result{}, fmt.Errorf("unknown payment %v", reference)
+	}
+	if p.status != StatusCaptured {
+		return Result{Reference: reference, Status: p.status}, fmt.Errorf("payment %v is %v", reference, p.status)
+	}
+	if f.webhookURL != "" {
+		go f.deliver(fakeEvent{Reference: reference, Status: StatusSucceeded})
+	}
+	return Result{Reference: reference, Status: StatusCaptured}, nil
+}
+
+func (f FakeProvider) Refund(reference, key string, amount models.Decimal) (Result, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if result, ok := f.refunds[key]; ok {
+		return result, nil
+	}
+	p, ok := f.payments[reference]
+	if !ok {
+		return Result{}, DeclinedError(fmt.Sprintf("unknown payment %v", reference))
+	}
+	if p.status != StatusCaptured && p.status != StatusRefunded {
+		return Result{Reference: reference, Status: p.status}, DeclinedError(fmt.Sprintf("payment %v is %v", reference, p.status))
+	}
+	if amount <= 0 || p.refunded+amount > p.amount {
+		return Result{Reference: reference, Status: p.status}, DeclinedError("refund exceeds the captured amount")
+	}
+	p.refunded += amount
+	if p.refunded == p.amount {
+		p.status = StatusRefunded
+	}
+	f.refunds[key] = Result{Reference: reference, Status: StatusRefunded}
+	return f.refunds[key], nil
+}
+
+func (f FakeProvider) ParseWebhook(header http.Header, body []byte) (*Event, error) {
+	signature, err := hex.DecodeString(header.Get(SignatureHeader))
+	if err != nil || len(f.secret) == 0 || !hmac.Equal(signature, f.sign(body)) {
+		return nil, ErrInvalidSignature
+	}
+	e := fakeEvent{}
+	err = json.Unmarshal(body, &e)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{Reference: e.Reference, Status: e.Status, Message: e.Message}, nil
+}
+
+// Sign returns the signature header value of the webhook body.
+func (f FakeProvider) Sign(body []byte) string {
+	return hex.EncodeToString(f.sign(body))
+}
+
+func (f FakeProvider) sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, f.secret)
+	_, _ = mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func (f FakeProvider) deliver(e fakeEvent) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		logrus.Error("fake payment event encode error: ", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, f.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		logrus.Error("fake payment webhook request error: ", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, f.Sign(body))
+	resp, err := f.client.Do(req)
+	if err != nil {
+		logrus.Error("fake payment webhook delivery failed: ", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		logrus.Errorf("fake payment webhook of %v answered %v", e.Reference, resp.Status)
+	}
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	_, err := rand.Read(buf)
+	if err != nil {
+		logrus.Fatal("random source failed: ", err)
+	}
+	return hex.EncodeToString(buf)
+}
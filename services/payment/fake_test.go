@@ -0,0 +1,113 @@
+package payment
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+func TestFakeProviderPayment(t *testing.T) {
+	f := NewFakeProvider(FakeConfig{Secret: "secret"})
+	_, err := f.Authorize(AuthorizeRequest{OrderID: 1, Amount: 1000, Currency: "USD", Method: FakeDeclinedMethod})
+	if _, ok := err.(DeclinedError); !ok {
+		t.Errorf("declined method: got %v, want DeclinedError", err)
+	}
+
+	result, err := f.Authorize(AuthorizeRequest{OrderID: 1, Amount: 1000, Currency: "USD", Method: "card"})
+	if err != nil || result.Status != StatusAuthorized || result.Reference == "" {
+		t.Fatalf("authorize: got %+v, %v", result, err)
+	}
+	reference := result.Reference
+	_, err = f.Refund(reference, "refund_0", 100)
+	if _, ok := err.(DeclinedError); !ok {
+		t.Errorf("refund of an uncaptured payment: got %v, want DeclinedError", err)
+	}
+	result, err = f.Capture(reference)
+	if err != nil || result.Status != StatusCaptured {
+		t.Fatalf("capture: got %+v, %v", result, err)
+	}
+
+	if _, err = f.Refund(reference, "refund_1", 600); err != nil {
+		t.Fatal(err)
+	}
+	// the same refund asked again is made once
+	if _, err = f.Refund(reference, "refund_1", 600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Refund(reference, "refund_2", 401); err == nil {
+		t.Error("refund beyond the captured amount has been made")
+	}
+	if _, err = f.Refund(reference, "refund_3", 400); err != nil {
+		t.Errorf("refund of the rest: %v", err)
+	}
+	if p := f.payments[reference]; p.refunded != 1000 || p.status != StatusRefunded {
+		t.Errorf("got %v refunded, status %v, want all refunded", p.refunded, p.status)
+	}
+}
+
+func TestFakeProviderParseWebhook(t *testing.T) {
+	f := NewFakeProvider(FakeConfig{Secret: "secret"})
+	body := []byte(`{"reference": "fake_1", "status": "succeeded"}`)
+	header := http.Header{}
+	header.Set(SignatureHeader, f.Sign(body))
+	event, err := f.ParseWebhook(header, body)
+	if err != nil || event.Reference != "fake_1" || event.Status != StatusSucceeded {
+		t.Fatalf("got %+v, %v", event, err)
+	}
+
+	tampered := []byte(`{"reference": "fake_2", "status": "succeeded"}`)
+	if _, err = f.ParseWebhook(header, tampered); err != ErrInvalidSignature {
+		t.Errorf("tampered body: got %v, want ErrInvalidSignature", err)
+	}
+	other := NewFakeProvider(FakeConfig{Secret: "other"})
+	if _, err = other.ParseWebhook(header, body); err != ErrInvalidSignature {
+		t.Errorf("other secret: got %v, want ErrInvalidSignature", err)
+	}
+	if _, err = f.ParseWebhook(http.Header{}, body); err != ErrInvalidSignature {
+		t.Errorf("unsigned body: got %v, want ErrInvalidSignature", err)
+	}
+	// without a secret anybody could sign events
+	unsigned := NewFakeProvider(FakeConfig{})
+	header.Set(SignatureHeader, unsigned.Sign(body))
+	if _, err = unsigned.ParseWebhook(header, body); err != ErrInvalidSignature {
+		t.Errorf("empty secret: got %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestFakeProviderSendsSignedEvents(t *testing.T) {
+	received := make(chan *Event, 1)
+	var f FakeProvider
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		event, err := f.ParseWebhook(r.Header, body)
+		if err != nil {
+			t.Errorf("webhook: %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received <- event
+	}))
+	defer server.Close()
+	f = NewFakeProvider(FakeConfig{Secret: "secret", WebhookURL: server.URL})
+
+	result, err := f.Authorize(AuthorizeRequest{OrderID: 1, Amount: models.Decimal(1000), Currency: "USD", Method: "card"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Capture(result.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-received:
+		if event.Reference != result.Reference || event.Status != StatusSucceeded {
+			t.Errorf("got event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event has been sent")
+	}
+}
@@ -0,0 +1,93 @@
+package payment
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+// Statuses of payments reported by providers
+const (
+	StatusAuthorized = models.PaymentStatusAuthorized
+	StatusCaptured   = models.PaymentStatusCaptured
+	StatusSucceeded  = models.PaymentStatusSucceeded
+	StatusFailed     = models.PaymentStatusFailed
+	StatusRefunded   = models.PaymentStatusRefunded
+)
+
+// ErrInvalidSignature is returned by ParseWebhook for requests which were not signed by the provider.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// DeclinedError is returned when the provider refuses the payment, e.g. for insufficient funds.
+type DeclinedError string
+
+func (e DeclinedError) Error() string {
+	return string(e)
+}
+
+type Config struct {
+	Type string
+	Fake FakeConfig
+}
+
+// AuthorizeRequest asks to hold the amount for the order, Method is the payment method token from the client.
+type AuthorizeRequest struct {
+	OrderID  int
+	Amount   models.Decimal
+	Currency string
+	Method   string
+}
+
+// Result is the state of a payment at the provider.
+type Result struct {
+	Reference string
+	Status    string
+}
+
+// Event is a payment status change the provider reported to the webhook.
+type Event struct {
+	Reference string
+	Status    string
+	Message   string
+}
+
+// Provider takes payments for orders. Authorize holds the amount, Capture takes it,
+// and the final outcome arrives to the webhook, which is verified by ParseWebhook.
+type Provider interface {
+	Name() string
+	Authorize(req AuthorizeRequest) (Result, error)
+	Capture(reference string) (Result, error)
+	// Refund gives back the amount of a captured payment, key identifies the refund,
+	// so a refund asked again with the same key is made once.
+	Refund(reference, key string, amount models.Decimal) (Result, error)
+	ParseWebhook(header http.Header, body []byte) (*Event, error)
+}
+
+var provider Provider
+
+// Init sets up the provider of the type, which has to be configured explicitly,
+// so a deployment never takes fake payments by accident.
+func Init(config Config) {
+	switch config.Type {
+	case "fake":
+		if config.Fake.Secret == "" {
+			logrus.Fatalf("fake payment provider needs a webhook secret")
+		}
+		provider = NewFakeProvider(config.Fake)
+		logrus.Info("fake payment provider initialized")
+	case "":
+		logrus.Fatalf("payment provider type is not configured")
+	default:
+		logrus.Fatalf("unsupported payment provider type %q", config.Type)
+	}
+}
+
+func GetProvider() Provider {
+	if provider == nil {
+		logrus.Fatalf("payment provider has not initialized")
+	}
+	return provider
+}
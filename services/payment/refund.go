@@ -0,0 +1,38 @@
+package payment
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+// RefundRecorder stores the answers of the provider to pending refunds.
+type RefundRecorder interface {
+	CompleteRefund(refundID int) (*models.Payment, error)
+	FailRefund(refundID int, message string) error
+}
+
+// SettleRefund asks the provider for the pending refund of the payment and records the answer.
+// A refund the provider declines fails, other errors leave its outcome unknown,
+// so it stays pending and is asked again with the same key later.
+func SettleRefund(recorder RefundRecorder, p *models.Payment, refund *models.Refund) (*models.Payment, error) {
+	provider := GetProvider()
+	var err error
+	if p.Provider != provider.Name() || p.Reference == nil {
+		err = DeclinedError(fmt.Sprintf("payment %d cannot be refunded through %v", p.ID, provider.Name()))
+	} else {
+		_, err = provider.Refund(*p.Reference, refund.Key(), refund.Amount)
+	}
+	if err != nil {
+		if _, ok := err.(DeclinedError); ok {
+			failErr := recorder.FailRefund(refund.ID, err.Error())
+			if failErr != nil {
+				logrus.Errorf("declined refund %d has not been recorded: %v", refund.ID, failErr)
+			}
+		}
+		return nil, err
+	}
+	return recorder.CompleteRefund(refund.ID)
+}
@@ -0,0 +1,68 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+// refundRecorder records the refunds completed and failed.
+type refundRecorder struct {
+	completed []int
+	failed    []int
+}
+
+func (r *refundRecorder) CompleteRefund(refundID int) (*models.Payment, error) {
+	r.completed = append(r.completed, refundID)
+	return &models.Payment{}, nil
+}
+
+func (r *refundRecorder) FailRefund(refundID int, message string) error {
+	r.failed = append(r.failed, refundID)
+	return nil
+}
+
+// unavailableProvider does not answer refunds.
+type unavailableProvider struct {
+	FakeProvider
+}
+
+func (unavailableProvider) Refund(reference, key string, amount models.Decimal) (Result, error) {
+	return Result{}, errors.New("connection reset")
+}
+
+func TestSettleRefund(t *testing.T) {
+	fake := NewFakeProvider(FakeConfig{Secret: "secret"})
+	provider = fake
+	defer func() { provider = nil }()
+	result, _ := fake.Authorize(AuthorizeRequest{OrderID: 1, Amount: 1000, Currency: "USD", Method: "card"})
+	fake.Capture(result.Reference)
+	paid := &models.Payment{ID: 1, Provider: fake.Name(), Reference: &result.Reference, Amount: 1000}
+
+	recorder := &refundRecorder{}
+	_, err := SettleRefund(recorder, paid, &models.Refund{ID: 1, Amount: 600})
+	if err != nil || len(recorder.completed) != 1 || len(recorder.failed) != 0 {
+		t.Errorf("made refund: got %v, completed %v, failed %v", err, recorder.completed, recorder.failed)
+	}
+
+	recorder = &refundRecorder{}
+	_, err = SettleRefund(recorder, paid, &models.Refund{ID: 2, Amount: 600})
+	if _, ok := err.(DeclinedError); !ok || len(recorder.failed) != 1 || len(recorder.completed) != 0 {
+		t.Errorf("declined refund: got %v, completed %v, failed %v", err, recorder.completed, recorder.failed)
+	}
+
+	recorder = &refundRecorder{}
+	other := "other_1"
+	_, err = SettleRefund(recorder, &models.Payment{ID: 2, Provider: "other", Reference: &other}, &models.Refund{ID: 3, Amount: 1})
+	if err == nil || len(recorder.failed) != 1 {
+		t.Errorf("payment of another provider: got %v, failed %v", err, recorder.failed)
+	}
+
+	provider = unavailableProvider{fake}
+	recorder = &refundRecorder{}
+	_, err = SettleRefund(recorder, paid, &models.Refund{ID: 4, Amount: 100})
+	if err == nil || len(recorder.completed) != 0 || len(recorder.failed) != 0 {
+		t.Errorf("unanswered refund must stay pending: got %v, completed %v, failed %v", err, recorder.completed, recorder.failed)
+	}
+}
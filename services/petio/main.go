@@ -0,0 +1,269 @@
+package petio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// listSeparator joins tags and photo urls inside a single csv cell
+const listSeparator = "|"
+
+var csvHeader = []string{"id", "name", "status", "category", "tags", "photoUrls",
+	"species", "breed", "birthDate", "sex", "description", "weight", "price", "currency"}
+
+var contentTypes = map[string]string{
+	FormatCSV:    "text/csv; charset=utf-8",
+	FormatNDJSON: "application/x-ndjson",
+}
+
+// RowError is a problem with a single row, reading can go on after it.
+type RowError struct {
+	Line int    `json:"line"`
+	Err  string `json:"error"`
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+type Reader interface {
+	// Next returns the next pet with its line number, io.EOF after the last one.
+	// Malformed rows are reported as RowError.
+	Next() (int, *models.Pet, error)
+}
+
+type Writer interface {
+	Write(*models.Pet) error
+	Flush() error
+}
+
+// FormatFromContentType maps a request content type to a format name.
+func FormatFromContentType(contentType string) string {
+	switch {
+	case strings.HasPrefix(contentType, "text/csv"):
+		return FormatCSV
+	case strings.HasPrefix(contentType, "application/x-ndjson"),
+		strings.HasPrefix(contentType, "application/ndjson"):
+		return FormatNDJSON
+	}
+	return ""
+}
+
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+func NewReader(format string, r io.Reader) (Reader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatNDJSON:
+		return &ndjsonReader{scanner: bufio.NewScanner(r)}, nil
+	}
+	return nil, errors.Errorf("unsupported format %v", format)
+}
+
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		writer := &csvWriter{writer: csv.NewWriter(w)}
+		return writer, writer.writer.Write(csvHeader)
+	case FormatNDJSON:
+		return &ndjsonWriter{writer: bufio.NewWriter(w)}, nil
+	}
+	return nil, errors.Errorf("unsupported format %v", format)
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	line    int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "csv header read error")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("csv header must contain name column")
+	}
+	return &csvReader{reader: reader, columns: columns, line: 1}, nil
+}
+
+func (c *csvReader) Next() (int, *models.Pet, error) {
+	record, err := c.reader.Read()
+	c.line++
+	if err == io.EOF {
+		return c.line, nil, io.EOF
+	}
+	if err != nil {
+		if _, ok := err.(*csv.ParseError); ok {
+			return c.line, nil, RowError{Line: c.line, Err: err.Error()}
+		}
+		return c.line, nil, err
+	}
+	get := func(column string) string {
+		i, ok := c.columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	pet := &models.Pet{
+		Name:        get("name"),
+		Status:      get("status"),
+		Species:     get("species"),
+		Breed:       get("breed"),
+		Sex:         get("sex"),
+		Description: get("description"),
+		Currency:    get("currency"),
+		Category:    models.Category{Name: get("category")},
+		PhotoURLs:   splitList(get("photoUrls")),
+	}
+	if id := get("id"); id != "" {
+		pet.ID, err = strconv.Atoi(id)
+		if err != nil {
+			return c.line, nil, RowError{Line: c.line, Err: "invalid id"}
+		}
+	}
+	if birthDate := get("birthDate"); birthDate != "" {
+		date, err := time.Parse(models.DateFormat, birthDate)
+		if err != nil {
+			return c.line, nil, RowError{Line: c.line, Err: "invalid birthDate"}
+		}
+		pet.BirthDate = &models.Date{Time: date}
+	}
+	if weight := get("weight"); weight != "" {
+		value, err := strconv.ParseFloat(weight, 64)
+		if err != nil {
+			return c.line, nil, RowError{Line: c.line, Err: "invalid weight"}
+		}
+		pet.Weight = &value
+	}
+	if price := get("price"); price != "" {
+		pet.Price, err = models.ParseDecimal(price)
+		if err != nil {
+			return c.line, nil, RowError{Line: c.line, Err: "invalid price"}
+		}
+	}
+	for _, name := range splitList(get("tags")) {
+		pet.Tags = append(pet.Tags, models.Tag{Name: name})
+	}
+	return c.line, pet, nil
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (n *ndjsonReader) Next() (int, *models.Pet, error) {
+	for n.scanner.Scan() {
+		n.line++
+		data := bytes.TrimSpace(n.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		pet := &models.Pet{}
+		err := json.Unmarshal(data, pet)
+		if err != nil {
+			return n.line, nil, RowError{Line: n.line, Err: err.Error()}
+		}
+		return n.line, pet, nil
+	}
+	if err := n.scanner.Err(); err != nil {
+		return n.line, nil, err
+	}
+	return n.line, nil, io.EOF
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func (c *csvWriter) Write(pet *models.Pet) error {
+	tags := make([]string, 0, len(pet.Tags))
+	for _, tag := range pet.Tags {
+		tags = append(tags, tag.Name)
+	}
+	var birthDate, weight string
+	if pet.BirthDate != nil {
+		birthDate = pet.BirthDate.Format(models.DateFormat)
+	}
+	if pet.Weight != nil {
+		weight = strconv.FormatFloat(*pet.Weight, 'f', -1, 64)
+	}
+	return c.writer.Write([]string{
+		strconv.Itoa(pet.ID),
+		pet.Name,
+		pet.Status,
+		pet.Category.Name,
+		strings.Join(tags, listSeparator),
+		strings.Join(pet.PhotoURLs, listSeparator),
+		pet.Species,
+		pet.Breed,
+		birthDate,
+		pet.Sex,
+		pet.Description,
+		weight,
+		pet.Price.String(),
+		pet.Currency,
+	})
+}
+
+func (c *csvWriter) Flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type ndjsonWriter struct {
+	writer *bufio.Writer
+}
+
+func (n *ndjsonWriter) Write(pet *models.Pet) error {
+	data, err := json.Marshal(pet)
+	if err != nil {
+		return err
+	}
+	_, err = n.writer.Write(append(data, '\n'))
+	return err
+}
+
+func (n *ndjsonWriter) Flush() error {
+	return n.writer.Flush()
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, listSeparator) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultFilesystemRoot = "static/uploads"
+
+type FilesystemConfig struct {
+	Root string
+}
+
+type FilesystemStorage struct {
+	root string
+}
+
+func InitFilesystem(config FilesystemConfig) {
+	logrus.Info("init FilesystemStorage")
+	root := config.Root
+	if root == "" {
+		root = defaultFilesystemRoot
+	}
+	for _, bucketName := range []string{InvoicesBucket, ImagesBucket} {
+		err := os.MkdirAll(filepath.Join(root, bucketName), 0755)
+		if err != nil {
+			logrus.Fatal("problem with directory creating: ", err)
+		}
+	}
+	storage = FilesystemStorage{root: root}
+}
+
+func (fs FilesystemStorage) path(destination, filename string) string {
+	return filepath.Join(fs.root, destination, filepath.FromSlash(filename))
+}
+
+func (fs FilesystemStorage) Save(destination, filename, contentType, filePath string) error {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = src.Close()
+		if err != nil {
+			logrus.Error(err)
+		}
+	}()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	return fs.Put(destination, filename, contentType, src, info.Size())
+}
+
+func (fs FilesystemStorage) Put(destination, filename, contentType string, reader io.Reader, size int64) error {
+	fullPath := fs.path(destination, filename)
+	err := os.MkdirAll(filepath.Dir(fullPath), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = f.Close()
+		if err != nil {
+			logrus.Error(err)
+		}
+	}()
+	written, err := io.Copy(f, reader)
+	if err != nil {
+		return err
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("short write: %d of %d bytes", written, size)
+	}
+	logrus.Infof("file saved to filesystem storage destination=%s filename=%s", destination, filename)
+	return nil
+}
+
+func (fs FilesystemStorage) GetLink(destination, filename string) (string, error) {
+	return "", ErrLinkNotSupported
+}
+
+func (fs FilesystemStorage) Get(destination, filename string) (ReadSeekCloser, ObjectInfo, error) {
+	f, err := os.Open(fs.path(destination, filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ObjectInfo{}, NotFoundError(fmt.Sprintf("file %v not found", filename))
+		}
+		return nil, ObjectInfo{}, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		if closeErr := f.Close(); closeErr != nil {
+			logrus.Error(closeErr)
+		}
+		return nil, ObjectInfo{}, err
+	}
+	info := ObjectInfo{
+		Key:          filename,
+		Size:         stat.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(filename)),
+		ETag:         fmt.Sprintf("%x-%x", stat.ModTime().UnixNano(), stat.Size()),
+		LastModified: stat.ModTime(),
+	}
+	return f, info, nil
+}
+
+func (fs FilesystemStorage) List(destination, prefix string) ([]ObjectInfo, error) {
+	dir := filepath.Join(fs.root, destination)
+	var objects []ObjectInfo
+	err := filepath.Walk(dir, func(path string, stat os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if stat.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         stat.Size(),
+			ContentType:  mime.TypeByExtension(filepath.Ext(key)),
+			ETag:         fmt.Sprintf("%x-%x", stat.ModTime().UnixNano(), stat.Size()),
+			LastModified: stat.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (fs FilesystemStorage) Update(destination, filename string) error {
+	return nil
+}
+
+func (fs FilesystemStorage) Delete(destination, filename string) error {
+	err := os.Remove(fs.path(destination, filename))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -1,19 +1,52 @@
 package storage
 
 import (
+	"errors"
+	"io"
+	"time"
+
 	"github.com/sirupsen/logrus"
 )
 
+const ImagesBucket = "images"
+
+// ErrLinkNotSupported is returned by GetLink when the backend cannot hand out direct links,
+// such files have to be streamed by the application.
+var ErrLinkNotSupported = errors.New("storage does not support direct links")
+
+type NotFoundError string
+
+func (e NotFoundError) Error() string {
+	return string(e)
+}
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+}
+
+type ReadSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
 var storage Storage
 
 type Config struct {
-	Type  string
-	Minio MinioConfig
+	Type       string
+	Minio      MinioConfig
+	Filesystem FilesystemConfig
 }
 
 type Storage interface {
 	GetLink(destination, filename string) (string, error)
+	Get(destination, filename string) (ReadSeekCloser, ObjectInfo, error)
+	List(destination, prefix string) ([]ObjectInfo, error)
 	Save(destination, filename, contentType, filePath string) error
+	Put(destination, filename, contentType string, reader io.Reader, size int64) error
 	Update(destination, filename string) error
 	Delete(destination, filename string) error
 }
@@ -23,7 +56,7 @@ func Init(config Config) {
 	case "minio":
 		InitMinio(config.Minio)
 	case "filesystem":
-		//TODO (OR NOT)
+		InitFilesystem(config.Filesystem)
 	default:
 		logrus.Fatalf("unsupported storage type")
 	}
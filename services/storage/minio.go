@@ -2,14 +2,20 @@ package storage
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"net/url"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+
 	"github.com/minio/minio-go/v6"
 )
 
 const InvoicesBucket = "invoices"
+const defaultLinkExpiry = time.Hour
 
 type MinioConfig struct {
 	Host      string
@@ -17,9 +23,14 @@ type MinioConfig struct {
 	AccessKey string
 	SecretKey string
 	SSL       bool
+	// PublicHost is the host:port clients reach minio with, presigned links are signed for it
+	PublicHost string
+	LinkExpiry utils.Duration
 }
 type MinioStorage struct {
-	minioClient *minio.Client
+	minioClient   *minio.Client
+	presignClient *minio.Client
+	linkExpiry    time.Duration
 }
 
 func InitMinio(config MinioConfig) {
@@ -29,21 +40,33 @@ func InitMinio(config MinioConfig) {
 	if err != nil {
 		log.Fatal("minio create error", err)
 	}
-	bucketName := InvoicesBucket
 	location := "us-east-1"
-	err = minioClient.MakeBucket(bucketName, location)
+	publicEndpoint := endpoint
+	if config.PublicHost != "" {
+		publicEndpoint = config.PublicHost
+	}
+	// region is set explicitly, so presigning never has to reach the public endpoint
+	presignClient, err := minio.NewWithRegion(publicEndpoint, config.AccessKey, config.SecretKey, config.SSL, location)
 	if err != nil {
-		exists, errBucketExists := minioClient.BucketExists(bucketName)
-		if errBucketExists == nil && exists {
-			logrus.Printf("Bucket exists: %s", bucketName)
-		} else {
+		log.Fatal("minio create error", err)
+	}
+	linkExpiry := config.LinkExpiry.Duration
+	if linkExpiry <= 0 {
+		linkExpiry = defaultLinkExpiry
+	}
+	for _, bucketName := range []string{InvoicesBucket, ImagesBucket} {
+		err = minioClient.MakeBucket(bucketName, location)
+		if err != nil {
+			exists, errBucketExists := minioClient.BucketExists(bucketName)
+			if errBucketExists == nil && exists {
+				logrus.Printf("Bucket exists: %s", bucketName)
+				continue
+			}
 			logrus.Fatal("problem with bucket creating: ", err)
 		}
+		logrus.Printf("Successfully created %s\n", bucketName)
 	}
-	logrus.Info("bucket successfully created ")
-
-	logrus.Printf("Successfully created %s\n", bucketName)
-	storage = MinioStorage{minioClient: minioClient}
+	storage = MinioStorage{minioClient: minioClient, presignClient: presignClient, linkExpiry: linkExpiry}
 
 }
 
@@ -57,14 +80,82 @@ func (mc MinioStorage) Save(bucketName, filename, contentType, filePath string)
 	return err
 }
 
+func (mc MinioStorage) Put(bucketName, filename, contentType string, reader io.Reader, size int64) error {
+	_, err := mc.minioClient.PutObject(
+		bucketName,
+		filename,
+		reader,
+		size,
+		minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return err
+	}
+	logrus.Infof("file saved to minio storage bucket=%s filename=%s", bucketName, filename)
+	return nil
+}
+
 func (mc MinioStorage) GetLink(bucket, filename string) (string, error) {
-	return "", nil
+	u, err := mc.presignClient.PresignedGetObject(bucket, filename, mc.linkExpiry, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (mc MinioStorage) Get(bucket, filename string) (ReadSeekCloser, ObjectInfo, error) {
+	object, err := mc.minioClient.GetObject(bucket, filename, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	stat, err := object.Stat()
+	if err != nil {
+		if closeErr := object.Close(); closeErr != nil {
+			logrus.Error(closeErr)
+		}
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ObjectInfo{}, NotFoundError(fmt.Sprintf("object %v not found", filename))
+		}
+		return nil, ObjectInfo{}, err
+	}
+	info := ObjectInfo{
+		Key:          stat.Key,
+		Size:         stat.Size,
+		ContentType:  stat.ContentType,
+		ETag:         stat.ETag,
+		LastModified: stat.LastModified,
+	}
+	return object, info, nil
 }
 
 func (mc MinioStorage) Update(bucket, filename string) error {
 	return nil
 }
 
+func (mc MinioStorage) List(bucket, prefix string) ([]ObjectInfo, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	var objects []ObjectInfo
+	for object := range mc.minioClient.ListObjectsV2(bucket, prefix, true, doneCh) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          object.Key,
+			Size:         object.Size,
+			ContentType:  object.ContentType,
+			ETag:         object.ETag,
+			LastModified: object.LastModified,
+		})
+	}
+	return objects, nil
+}
+
 func (mc MinioStorage) Delete(bucket, filename string) error {
+	err := mc.minioClient.RemoveObject(bucket, filename)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("file removed from minio storage bucket=%s filename=%s", bucket, filename)
 	return nil
 }
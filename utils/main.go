@@ -2,11 +2,9 @@ package utils
 
 import (
 	"errors"
-	"fmt"
-	"io"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
-	"os"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -14,11 +12,6 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-var extensions = map[string]string{
-	"image/jpeg": "jpg",
-	"image/png":  "png",
-}
-
 type Duration struct {
 	time.Duration
 }
@@ -65,14 +58,15 @@ func GetFormParam(r *http.Request, paramName string) string {
 	return r.Form.Get(paramName)
 }
 
-func SaveFile(r *http.Request, fileKey, path, filename string, allowedMimeTypes []string) (string, error) {
+// ReadFormFile reads the whole multipart file if its mimetype is allowed.
+func ReadFormFile(r *http.Request, fileKey string, allowedMimeTypes []string) ([]byte, error) {
 	err := r.ParseMultipartForm(32 << 20)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	file, handler, err := r.FormFile(fileKey)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer func() {
 		err = file.Close()
@@ -83,31 +77,14 @@ func SaveFile(r *http.Request, fileKey, path, filename string, allowedMimeTypes
 
 	mimeType := handler.Header.Get("Content-Type")
 	if !ContainsString(mimeType, allowedMimeTypes) {
-		return "", errors.New("not allowed mimetype")
-
-	}
-	extension, ok := extensions[mimeType]
-	if !ok {
-		return "", errors.New("unknown mimetype")
-	}
-
-	fullPath := fmt.Sprintf("%v/%v.%v", path, filename, extension)
-	f, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
-		return "", err
+		return nil, errors.New("not allowed mimetype")
 	}
-	defer func() {
-		err = f.Close()
-		if err != nil {
-			logrus.Error(err)
-		}
-	}()
 
-	_, err = io.Copy(f, file)
+	data, err := ioutil.ReadAll(file)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return fullPath, err
+	return data, nil
 }
 
 func GetHash(pwd string) (string, error) {
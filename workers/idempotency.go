@@ -0,0 +1,37 @@
+package workers
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+)
+
+type IdempotencyPurgeConfig struct {
+	Interval utils.Duration
+}
+
+// IdempotencyPurgeJob removes recorded responses of idempotency keys which have expired.
+type IdempotencyPurgeJob struct {
+	DB *sqlx.DB
+}
+
+func (j IdempotencyPurgeJob) Execute() {
+	purged, err := mappers.IdempotencyMapper{DB: j.DB}.Purge(time.Now())
+	if err != nil {
+		logrus.Error("idempotency keys purge failed: ", err)
+		return
+	}
+	if purged > 0 {
+		logrus.Infof("idempotency keys purge removed %d expired keys", purged)
+	}
+}
+
+func DispatchIdempotencyPurgeWorker(config IdempotencyPurgeConfig, db *sqlx.DB) {
+	dispatchPeriodicWorker("idempotency keys purge", config.Interval.Duration, func() Job {
+		return IdempotencyPurgeJob{DB: db}
+	})
+}
@@ -0,0 +1,120 @@
+package workers
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/storage"
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+)
+
+const defaultImageGCGracePeriod = 24 * time.Hour
+
+type ImageGCConfig struct {
+	Interval    utils.Duration
+	GracePeriod utils.Duration
+	DryRun      bool
+}
+
+// ImageGCReport describes a single garbage collector run.
+type ImageGCReport struct {
+	StartedAt  time.Time
+	DryRun     bool
+	Scanned    int
+	Referenced int
+	// Recent objects are not referenced yet but are younger than the grace period
+	Recent  int
+	Orphans []string
+	Deleted int
+	Failed  int
+}
+
+// ImageGCJob removes stored images no pet references.
+// Only objects older than GracePeriod are removed, so uploads still in progress are kept.
+type ImageGCJob struct {
+	DB          *sqlx.DB
+	GracePeriod time.Duration
+	DryRun      bool
+}
+
+func (j ImageGCJob) Execute() {
+	logrus.Info("image gc job start")
+	report, err := j.Run()
+	if err != nil {
+		logrus.Error("image gc failed: ", err)
+		return
+	}
+	for _, orphan := range report.Orphans {
+		logrus.WithField("dryRun", report.DryRun).Info("image gc orphan: ", orphan)
+	}
+	logrus.WithFields(logrus.Fields{
+		"startedAt":  report.StartedAt.Format(time.RFC3339),
+		"dryRun":     report.DryRun,
+		"scanned":    report.Scanned,
+		"referenced": report.Referenced,
+		"recent":     report.Recent,
+		"orphans":    len(report.Orphans),
+		"deleted":    report.Deleted,
+		"failed":     report.Failed,
+	}).Info("image gc job finished")
+}
+
+func (j ImageGCJob) Run() (*ImageGCReport, error) {
+	report := &ImageGCReport{StartedAt: time.Now(), DryRun: j.DryRun}
+
+	// objects are listed before the references are loaded,
+	// so an image saved in between is either recent or referenced
+	store := storage.GetStorage()
+	objects, err := store.List(storage.ImagesBucket, "")
+	if err != nil {
+		return nil, err
+	}
+	allImages, err := mappers.PetMapper{DB: j.DB}.FindAllImages()
+	if err != nil {
+		return nil, err
+	}
+	referenced := map[string]struct{}{}
+	for _, images := range allImages {
+		for _, filename := range images.Filenames() {
+			referenced[filename] = struct{}{}
+		}
+	}
+
+	threshold := report.StartedAt.Add(-j.GracePeriod)
+	for _, object := range objects {
+		report.Scanned++
+		if _, ok := referenced[object.Key]; ok {
+			report.Referenced++
+			continue
+		}
+		if object.LastModified.After(threshold) {
+			report.Recent++
+			continue
+		}
+		report.Orphans = append(report.Orphans, object.Key)
+		if j.DryRun {
+			continue
+		}
+		err = store.Delete(storage.ImagesBucket, object.Key)
+		if err != nil {
+			logrus.Errorf("image gc cannot remove %v: %v", object.Key, err)
+			report.Failed++
+			continue
+		}
+		report.Deleted++
+	}
+	return report, nil
+}
+
+func DispatchImageGCWorker(config ImageGCConfig, db *sqlx.DB) {
+	gracePeriod := config.GracePeriod.Duration
+	if gracePeriod <= 0 {
+		gracePeriod = defaultImageGCGracePeriod
+	}
+	dispatchPeriodicWorker("image gc", config.Interval.Duration, func() Job {
+		return ImageGCJob{DB: db, GracePeriod: gracePeriod, DryRun: config.DryRun}
+	})
+}
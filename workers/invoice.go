@@ -3,13 +3,11 @@ package workers
 import (
 	"bytes"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"os"
-	"text/template"
+	"sort"
 	"time"
 
 	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/invoicing"
 	"gitlab.com/i4s-edu/petstore-kovalyk/services/storage"
 	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
 
@@ -18,131 +16,215 @@ import (
 	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
 )
 
-const invoiceTemplatePath = "resources/templates/invoice.tmpl"
-const tempDirectory = "tmp"
+const (
+	defaultInvoiceBatchSize = 500
+	// defaultInvoiceRetryDelay leaves invoices being stored to the run which has claimed them
+	defaultInvoiceRetryDelay = 10 * time.Minute
+)
 
 type InvoiceConfig struct {
 	Count    int
 	Interval utils.Duration
+	// Formats are rendered and stored for every invoice, the first one is the main document
+	Formats []string
+	// BatchSize is the most orders and the most credit notes put on a single invoice
+	BatchSize int
+	// RetryDelay is how long a run storing the documents of an invoice holds it,
+	// the invoice is taken by another run when it is still pending afterwards
+	RetryDelay utils.Duration
 }
 
+// InvoiceJob issues invoices of the orders and credit notes made since the last one,
+// the ones missed by a late or failed run are taken by the next invoice.
 type InvoiceJob struct {
-	DB       *sqlx.DB
-	Interval time.Duration
+	InvoiceMapper mappers.InvoiceMapperInterface
+	Formats       []string
+	BatchSize     int
+	RetryDelay    time.Duration
 }
 
+// Execute first stores the documents of invoices left pending by earlier runs, then claims invoices
+// of full batches until everything is invoiced. Invoices are claimed and committed before their documents
+// are rendered, so they keep their numbers when the storage fails and are issued by a later run.
+// Pending invoices are leased to the run storing them for RetryDelay, so no other run stores
+// or removes their documents meanwhile.
 func (i InvoiceJob) Execute() {
 	logrus.Info("invoice job start")
-	now := time.Now()
-	point := now.Unix() - int64(i.Interval.Seconds())
-	orders, err := mappers.OrderMapper{DB: i.DB}.GetOldest(point)
+	pending, err := i.InvoiceMapper.ClaimPending(i.BatchSize, i.RetryDelay)
 	if err != nil {
-		logrus.Error(err)
+		logrus.Error("claim pending invoices, error: ", err)
 		return
 	}
-	if len(orders) == 0 {
-		logrus.Error("SKIPPING JOB: No orders for that period")
-		return
+	for _, invoice := range pending {
+		orders, creditNotes, err := i.InvoiceMapper.FindItems(invoice)
+		if err == nil {
+			err = i.issue(invoice, orders, creditNotes)
+		}
+		if err != nil {
+			logrus.Errorf("invoice %d stays pending, error: %v", invoice.Number, err)
+			return
+		}
 	}
-	t, err := template.New("invoice.tmpl").ParseFiles(invoiceTemplatePath)
-	if err != nil {
-		logrus.Error("invoice template parse error: ", err)
-		return
+
+	for {
+		now := time.Now()
+		invoice := &models.Invoice{
+			CreatedDate: now.Unix(),
+			PeriodEnd:   now,
+		}
+		orders, creditNotes, err := i.InvoiceMapper.Claim(invoice, i.BatchSize, i.RetryDelay)
+		if err != nil {
+			if _, ok := err.(mappers.NotFoundError); ok {
+				logrus.Info("SKIPPING JOB: No orders to invoice")
+				return
+			}
+			logrus.Error("create invoice, error: ", err)
+			return
+		}
+		err = i.issue(invoice, orders, creditNotes)
+		if err != nil {
+			logrus.Errorf("invoice %d stays pending, error: %v", invoice.Number, err)
+			return
+		}
+		if len(orders) < i.BatchSize && len(creditNotes) < i.BatchSize {
+			return
+		}
 	}
+}
 
-	date := now.Format(time.RFC3339)
+// issue stores the documents of the claimed invoice and finishes it.
+func (i InvoiceJob) issue(invoice *models.Invoice, orders []*models.Order, creditNotes []*models.CreditNote) error {
 	var totalQuantity int
 	for _, v := range orders {
 		totalQuantity += v.Quantity
 	}
-	data := struct {
-		Orders        []*models.Order
-		Date          string
-		TotalQuantity int
-	}{
+	invoice.Totals = invoiceTotals(orders, creditNotes)
+	err := i.store(invoice, &invoicing.Data{
+		Invoice:       invoice,
 		Orders:        orders,
-		Date:          date,
+		CreditNotes:   creditNotes,
+		Date:          time.Unix(invoice.CreatedDate, 0).Format(time.RFC3339),
 		TotalQuantity: totalQuantity,
-	}
-
-	var tpl bytes.Buffer
-	err = t.Execute(&tpl, data)
+		Totals:        invoice.Totals,
+	})
 	if err != nil {
-		logrus.Error("invoice template execute error: ", err)
-		return
+		return err
 	}
-	//
-	//newInvoice := &models.Invoice{}
-	//newInvoice.Body = tpl.String()
-	//newInvoice.CreatedDate = now.Unix()
-	//
-	//err = mappers.InvoiceMapper{DB: i.DB}.Create(newInvoice)
-	//if err != nil {
-	//	logrus.Error("create invoice, error: ", err)
-	//	return
-	//}
-
-	invoiceFilename := fmt.Sprintf("invoice_%v.md", date)
-	file, err := ioutil.TempFile(tempDirectory, invoiceFilename)
+	err = i.InvoiceMapper.Finish(invoice)
 	if err != nil {
-		log.Fatal(err)
-	}
-	logrus.Info("template output", tpl.String())
-	if _, err = fmt.Fprint(file, tpl.String()); err != nil {
-		logrus.Errorf("cannot write to temp file: %v, %v", file.Name(), err)
+		if _, ok := err.(mappers.ConflictError); ok {
+			// issued by another run with the same documents
+			logrus.Infof("invoice %d has been issued meanwhile", invoice.Number)
+			return nil
+		}
+		return err
 	}
+	logrus.Infof("Invoice worker finished, created invoice %d: %v", invoice.Number, invoice.StorageKey)
+	return nil
+}
 
-	store := storage.GetStorage()
-	err = store.Save("invoices", invoiceFilename, "text/markdown; charset=UTF-8", file.Name())
-	if err != nil {
-		logrus.Error("invoice save error", err)
+// store renders the invoice in every format and puts the documents into the storage next to each other,
+// the documents already stored are deleted when a later format fails.
+func (i InvoiceJob) store(invoice *models.Invoice, data *invoicing.Data) error {
+	formats := i.Formats
+	if len(formats) == 0 {
+		formats = invoicing.DefaultFormats
 	}
-	defer func() {
-		err := os.Remove(file.Name())
+	invoice.Documents = models.InvoiceDocuments{}
+	for _, format := range formats {
+		document, err := i.storeDocument(invoice, data, format)
 		if err != nil {
-			logrus.Error("unable to remove temp invoice file:", file.Name(), err)
+			deleteInvoiceDocuments(invoice.Documents)
+			invoice.Documents = nil
+			return err
 		}
-	}()
-	logrus.Info("Invoice worker finished, created file :", invoiceFilename)
-
-}
-
-type InvoiceJobCollector struct {
-	Jobs     chan Job
-	Interval time.Duration
-	DB       *sqlx.DB
-	die      chan struct{}
+		invoice.Documents = append(invoice.Documents, document)
+	}
+	invoice.StorageKey = invoice.Documents[0].StorageKey
+	invoice.ContentType = invoice.Documents[0].ContentType
+	return nil
 }
 
-func (i *InvoiceJobCollector) Collect() {
-	logrus.Info("invoice collect starts, interval=", i.Interval)
-	i.Jobs <- InvoiceJob{Interval: i.Interval, DB: i.DB}
-	time.Sleep(i.Interval)
+func (i InvoiceJob) storeDocument(invoice *models.Invoice, data *invoicing.Data, format string) (*models.InvoiceDocument, error) {
+	renderer, err := invoicing.NewRenderer(format)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	err = renderer.Render(&body, data)
+	if err != nil {
+		return nil, fmt.Errorf("invoice %v render error: %v", format, err)
+	}
+	document := &models.InvoiceDocument{
+		Format:      format,
+		StorageKey:  fmt.Sprintf("invoice-%06d.%v", invoice.ID, invoicing.Extension(format)),
+		ContentType: invoicing.ContentType(format),
+	}
+	err = storage.GetStorage().Put(storage.InvoicesBucket, document.StorageKey, document.ContentType,
+		bytes.NewReader(body.Bytes()), int64(body.Len()))
+	if err != nil {
+		return nil, err
+	}
+	return document, nil
 }
 
-func (i *InvoiceJobCollector) Start() {
-	logrus.Info("invoice worker started")
-	go func() {
-		for {
-			select {
-			case <-i.die:
-				return
-			default:
-				i.Collect()
-			}
+// deleteInvoiceDocuments removes stored documents of an invoice which is not recorded.
+func deleteInvoiceDocuments(documents models.InvoiceDocuments) {
+	for _, document := range documents {
+		err := storage.GetStorage().Delete(storage.InvoicesBucket, document.StorageKey)
+		if err != nil {
+			logrus.Errorf("invoice document %v cannot be removed: %v", document.StorageKey, err)
 		}
-	}()
+	}
 }
 
-func (i *InvoiceJobCollector) End() {
-	i.die <- struct{}{}
+// invoiceTotals returns the totals of the orders and credit notes by currency, sorted by the currency code.
+func invoiceTotals(orders []*models.Order, creditNotes []*models.CreditNote) models.InvoiceTotals {
+	byCurrency := map[string]*models.InvoiceTotal{}
+	totals := models.InvoiceTotals{}
+	get := func(currency string) *models.InvoiceTotal {
+		total, ok := byCurrency[currency]
+		if !ok {
+			total = &models.InvoiceTotal{Currency: currency}
+			byCurrency[currency] = total
+			totals = append(totals, total)
+		}
+		return total
+	}
+	for _, o := range orders {
+		total := get(o.Currency)
+		total.Subtotal += o.Subtotal
+		total.Discount += o.Discount
+		total.Total += o.Total
+	}
+	for _, n := range creditNotes {
+		get(n.Currency).Credited += n.Amount
+	}
+	for _, total := range totals {
+		total.Net = total.Total - total.Credited
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		return totals[i].Currency < totals[j].Currency
+	})
+	return totals
 }
 
-func DispatchInvoiceWorker(interval time.Duration, db *sqlx.DB) {
-	logrus.Info("dispatch invoice worker")
-	jobs := make(chan Job)
-	worker := Worker{Jobs: jobs}
-	collector := InvoiceJobCollector{Interval: interval, Jobs: jobs, DB: db}
-	worker.Start()
-	collector.Start()
+func DispatchInvoiceWorker(config InvoiceConfig, db *sqlx.DB) {
+	for _, format := range config.Formats {
+		if _, err := invoicing.NewRenderer(format); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultInvoiceBatchSize
+	}
+	retryDelay := config.RetryDelay.Duration
+	if retryDelay <= 0 {
+		retryDelay = defaultInvoiceRetryDelay
+	}
+	dispatchPeriodicWorker("invoice", config.Interval.Duration, func() Job {
+		return InvoiceJob{InvoiceMapper: mappers.InvoiceMapper{DB: db}, Formats: config.Formats, BatchSize: batchSize,
+			RetryDelay: retryDelay}
+	})
 }
@@ -0,0 +1,235 @@
+package workers
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/invoicing"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/storage"
+)
+
+func TestInvoiceStoreRemovesDocumentsOnFailure(t *testing.T) {
+	root, err := ioutil.TempDir("", "invoices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	storage.InitFilesystem(storage.FilesystemConfig{Root: root})
+
+	data := &invoicing.Data{Orders: []*models.Order{{ID: 1, Quantity: 1, Currency: "USD"}}}
+	invoice := &models.Invoice{ID: 4, Number: 4}
+	err = InvoiceJob{Formats: []string{invoicing.FormatPDF, "unknown"}}.store(invoice, data)
+	if err == nil {
+		t.Fatal("store succeeded with an unknown format")
+	}
+	if len(invoice.Documents) != 0 {
+		t.Errorf("got documents %v of a failed invoice", invoice.Documents)
+	}
+	objects, err := storage.GetStorage().List(storage.InvoicesBucket, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("got %d stored documents of a failed invoice, want none", len(objects))
+	}
+
+	invoice = &models.Invoice{ID: 5, Number: 5}
+	err = InvoiceJob{Formats: []string{invoicing.FormatPDF}}.store(invoice, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoice.Documents) != 1 || invoice.StorageKey != "invoice-000005.pdf" {
+		t.Errorf("got documents %v with main %v", invoice.Documents, invoice.StorageKey)
+	}
+	objects, _ = storage.GetStorage().List(storage.InvoicesBucket, "")
+	if len(objects) != 1 {
+		t.Errorf("got %d stored documents, want 1", len(objects))
+	}
+}
+
+func TestInvoiceTotals(t *testing.T) {
+	orders := []*models.Order{
+		{ID: 1, Currency: "USD", Subtotal: 1000, Discount: 100, Total: 900},
+		{ID: 2, Currency: "EUR", Subtotal: 1999, Total: 1999},
+		{ID: 3, Currency: "USD", Subtotal: 250, Discount: 25, Total: 225},
+	}
+	creditNotes := []*models.CreditNote{
+		{ID: 1, Currency: "USD", Amount: 300},
+		{ID: 2, Currency: "GBP", Amount: 150},
+	}
+	want := models.InvoiceTotals{
+		{Currency: "EUR", Subtotal: 1999, Total: 1999, Net: 1999},
+		{Currency: "GBP", Credited: 150, Net: -150},
+		{Currency: "USD", Subtotal: 1250, Discount: 125, Total: 1125, Credited: 300, Net: 825},
+	}
+	totals := invoiceTotals(orders, creditNotes)
+	if len(totals) != len(want) {
+		t.Fatalf("got %d totals, want %d", len(totals), len(want))
+	}
+	for i, total := range totals {
+		if *total != *want[i] {
+			t.Errorf("total %d: got %+v, want %+v", i, *total, *want[i])
+		}
+	}
+	if totals := invoiceTotals(nil, nil); len(totals) != 0 {
+		t.Errorf("got totals %v of nothing", totals)
+	}
+}
+
+// memoryInvoiceMapper claims orders and credit notes like InvoiceMapper does.
+type memoryInvoiceMapper struct {
+	mappers.InvoiceMapperInterface
+	orders      []*models.Order
+	creditNotes []*models.CreditNote
+	invoiceOf   map[int]int
+	invoices    []*models.Invoice
+}
+
+func (m *memoryInvoiceMapper) Claim(i *models.Invoice, limit int, lease time.Duration) ([]*models.Order, []*models.CreditNote, error) {
+	orders := []*models.Order{}
+	for _, o := range m.orders {
+		if _, ok := m.invoiceOf[o.ID]; ok || len(orders) == limit {
+			continue
+		}
+		if o.Status == models.OrderStatusApproved || o.Status == models.OrderStatusDelivered {
+			orders = append(orders, o)
+		}
+	}
+	creditNotes := []*models.CreditNote{}
+	for _, n := range m.creditNotes {
+		if _, billed := m.invoiceOf[n.OrderID]; billed && n.InvoiceID == nil && len(creditNotes) < limit {
+			creditNotes = append(creditNotes, n)
+		}
+	}
+	if len(orders) == 0 && len(creditNotes) == 0 {
+		return nil, nil, mappers.NotFoundError("nothing to invoice")
+	}
+	i.ID = len(m.invoices) + 100
+	i.Number = len(m.invoices) + 1
+	i.Status = models.InvoiceStatusPending
+	i.RetryAt = time.Now().Add(lease)
+	for _, o := range orders {
+		m.invoiceOf[o.ID] = i.ID
+	}
+	for _, n := range creditNotes {
+		n.InvoiceID = &i.ID
+	}
+	claimed := *i
+	m.invoices = append(m.invoices, &claimed)
+	return orders, creditNotes, nil
+}
+
+func (m *memoryInvoiceMapper) ClaimPending(limit int, lease time.Duration) ([]*models.Invoice, error) {
+	pending := []*models.Invoice{}
+	for _, i := range m.invoices {
+		if i.Status == models.InvoiceStatusPending && !i.RetryAt.After(time.Now()) && len(pending) < limit {
+			i.RetryAt = time.Now().Add(lease)
+			claimed := *i
+			pending = append(pending, &claimed)
+		}
+	}
+	return pending, nil
+}
+
+func (m *memoryInvoiceMapper) FindItems(i *models.Invoice) ([]*models.Order, []*models.CreditNote, error) {
+	orders := []*models.Order{}
+	for _, o := range m.orders {
+		if m.invoiceOf[o.ID] == i.ID {
+			orders = append(orders, o)
+		}
+	}
+	creditNotes := []*models.CreditNote{}
+	for _, n := range m.creditNotes {
+		if n.InvoiceID != nil && *n.InvoiceID == i.ID {
+			creditNotes = append(creditNotes, n)
+		}
+	}
+	return orders, creditNotes, nil
+}
+
+func (m *memoryInvoiceMapper) Finish(i *models.Invoice) error {
+	claimed := m.invoices[i.Number-1]
+	if claimed.Status != models.InvoiceStatusPending {
+		return mappers.ConflictError("invoice is not pending")
+	}
+	*claimed = *i
+	claimed.Status = models.InvoiceStatusIssued
+	return nil
+}
+
+func TestInvoiceJobNumbersWithoutGaps(t *testing.T) {
+	root, err := ioutil.TempDir("", "invoices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	storage.InitFilesystem(storage.FilesystemConfig{Root: root})
+
+	mapper := &memoryInvoiceMapper{invoiceOf: map[int]int{}}
+	statuses := []string{models.OrderStatusApproved, models.OrderStatusCancelled, models.OrderStatusDelivered,
+		models.OrderStatusPlaced, models.OrderStatusApproved, models.OrderStatusDelivered, models.OrderStatusApproved}
+	for id, status := range statuses {
+		mapper.orders = append(mapper.orders, &models.Order{ID: id + 1, Status: status, Quantity: 1, Currency: "USD", Total: 100})
+	}
+	// the cancelled order 2 has never been billed, its credit note is not invoiced
+	mapper.creditNotes = []*models.CreditNote{{ID: 1, OrderID: 1, Currency: "USD", Amount: 100},
+		{ID: 2, OrderID: 2, Currency: "USD", Amount: 100}}
+	job := InvoiceJob{InvoiceMapper: mapper, Formats: []string{invoicing.FormatPDF}, BatchSize: 2,
+		RetryDelay: time.Hour}
+
+	job.Execute()
+	// five approved or delivered orders in batches of two
+	if len(mapper.invoices) != 3 {
+		t.Fatalf("got %d invoices, want 3", len(mapper.invoices))
+	}
+	for _, id := range []int{2, 4} {
+		if _, ok := mapper.invoiceOf[id]; ok {
+			t.Errorf("order %d of status %v has been invoiced", id, statuses[id-1])
+		}
+	}
+	if len(mapper.invoiceOf) != 5 || mapper.creditNotes[0].InvoiceID == nil || mapper.creditNotes[1].InvoiceID != nil {
+		t.Errorf("got %d orders invoiced and credit notes on %v and %v, want 5 and the first credit note",
+			len(mapper.invoiceOf), mapper.creditNotes[0].InvoiceID, mapper.creditNotes[1].InvoiceID)
+	}
+	if mapper.creditNotes[0].InvoiceID != nil && *mapper.creditNotes[0].InvoiceID == mapper.invoiceOf[1] {
+		t.Error("credit note is on the invoice of its order, want a later one")
+	}
+
+	// a failed store keeps the claimed number, the next run issues the same invoice
+	mapper.orders[3].Status = models.OrderStatusApproved
+	failing := job
+	failing.Formats = []string{invoicing.FormatPDF, "unknown"}
+	failing.Execute()
+	if len(mapper.invoices) != 4 || mapper.invoices[3].Status != models.InvoiceStatusPending {
+		t.Fatalf("got %d invoices, want the fourth one pending", len(mapper.invoices))
+	}
+	// the lease of the failed run keeps the invoice from other runs
+	job.Execute()
+	if len(mapper.invoices) != 4 || mapper.invoices[3].Status != models.InvoiceStatusPending {
+		t.Errorf("got %d invoices while the pending one is leased, want 4 with the last one pending",
+			len(mapper.invoices))
+	}
+	mapper.invoices[3].RetryAt = time.Now()
+	job.Execute()
+	if len(mapper.invoices) != 4 {
+		t.Fatalf("got %d invoices after the retry, want 4", len(mapper.invoices))
+	}
+
+	for n, invoice := range mapper.invoices {
+		if invoice.Number != n+1 || invoice.Status != models.InvoiceStatusIssued || invoice.StorageKey == "" {
+			t.Errorf("invoice %d: got number %d, status %v and document %q", n+1, invoice.Number, invoice.Status,
+				invoice.StorageKey)
+		}
+	}
+	if mapper.invoiceOf[4] != mapper.invoices[3].ID || mapper.invoices[3].Totals[0].Total != 100 {
+		t.Errorf("order 4 is on invoice %d with totals %v", mapper.invoiceOf[4], mapper.invoices[3].Totals)
+	}
+	objects, _ := storage.GetStorage().List(storage.InvoicesBucket, "")
+	if len(objects) != 4 {
+		t.Errorf("got %d stored documents, want one of each invoice", len(objects))
+	}
+}
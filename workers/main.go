@@ -1,11 +1,19 @@
 package workers
 
 import (
+	"time"
+
 	"github.com/sirupsen/logrus"
 )
 
 type Config struct {
-	Invoice InvoiceConfig
+	Invoice      InvoiceConfig
+	ImageGC      ImageGCConfig
+	Reservations ReservationExpiryConfig
+	Trash        TrashPurgeConfig
+	Idempotency  IdempotencyPurgeConfig
+	Webhooks     WebhookDeliveryConfig
+	Refunds      RefundReconcileConfig
 }
 type Job interface {
 	Execute()
@@ -37,3 +45,51 @@ func (w *Worker) Stop() {
 	logrus.Infof("worker [%d] is stopping", w.ID)
 	w.die <- struct{}{}
 }
+
+// PeriodicJobCollector sends a new job built by NewJob to the workers every Interval.
+type PeriodicJobCollector struct {
+	Name     string
+	Jobs     chan Job
+	Interval time.Duration
+	NewJob   func() Job
+	die      chan struct{}
+}
+
+func (c *PeriodicJobCollector) Collect() {
+	logrus.Infof("%v collect starts, interval=%v", c.Name, c.Interval)
+	c.Jobs <- c.NewJob()
+	time.Sleep(c.Interval)
+}
+
+func (c *PeriodicJobCollector) Start() {
+	logrus.Infof("%v worker started", c.Name)
+	go func() {
+		for {
+			select {
+			case <-c.die:
+				return
+			default:
+				c.Collect()
+			}
+		}
+	}()
+}
+
+func (c *PeriodicJobCollector) End() {
+	c.die <- struct{}{}
+}
+
+// dispatchPeriodicWorker starts a worker running the job built by newJob every interval,
+// a zero interval disables the worker.
+func dispatchPeriodicWorker(name string, interval time.Duration, newJob func() Job) {
+	if interval <= 0 {
+		logrus.Infof("%v worker disabled", name)
+		return
+	}
+	logrus.Infof("dispatch %v worker", name)
+	jobs := make(chan Job)
+	worker := Worker{Jobs: jobs}
+	collector := PeriodicJobCollector{Name: name, Interval: interval, Jobs: jobs, NewJob: newJob, die: make(chan struct{})}
+	worker.Start()
+	collector.Start()
+}
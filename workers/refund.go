@@ -0,0 +1,60 @@
+package workers
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/payment"
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+)
+
+// defaultRefundRetryDelay leaves refunds in progress to the requests which have started them
+const defaultRefundRetryDelay = 5 * time.Minute
+
+type RefundReconcileConfig struct {
+	Interval utils.Duration
+	// RetryDelay is how long a refund stays pending before the provider is asked for it again
+	RetryDelay utils.Duration
+}
+
+// RefundReconcileJob asks the provider again for refunds left pending, e.g. by a timeout or a crash
+// after the provider has been asked. The provider makes a refund asked again with the same key once.
+type RefundReconcileJob struct {
+	DB         *sqlx.DB
+	RetryDelay time.Duration
+}
+
+func (j RefundReconcileJob) Execute() {
+	mapper := mappers.PaymentMapper{DB: j.DB, Actor: "payment:" + payment.GetProvider().Name()}
+	refunds, err := mapper.FindPendingRefunds(time.Now().Add(-j.RetryDelay))
+	if err != nil {
+		logrus.Error("refund reconciliation failed: ", err)
+		return
+	}
+	for _, refund := range refunds {
+		attempt, err := mapper.FindByID(refund.PaymentID)
+		if err != nil {
+			logrus.Errorf("refund reconciliation cannot find payment %d: %v", refund.PaymentID, err)
+			continue
+		}
+		_, err = payment.SettleRefund(mapper, attempt, refund)
+		if err != nil {
+			logrus.Errorf("refund %d of payment %d has not been settled: %v", refund.ID, refund.PaymentID, err)
+			continue
+		}
+		logrus.Infof("pending refund %d of payment %d settled", refund.ID, refund.PaymentID)
+	}
+}
+
+func DispatchRefundReconcileWorker(config RefundReconcileConfig, db *sqlx.DB) {
+	retryDelay := config.RetryDelay.Duration
+	if retryDelay <= 0 {
+		retryDelay = defaultRefundRetryDelay
+	}
+	dispatchPeriodicWorker("refund reconciliation", config.Interval.Duration, func() Job {
+		return RefundReconcileJob{DB: db, RetryDelay: retryDelay}
+	})
+}
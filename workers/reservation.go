@@ -0,0 +1,67 @@
+package workers
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/notification"
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+)
+
+type ReservationExpiryConfig struct {
+	Interval utils.Duration
+}
+
+// ReservationExpiryJob releases expired pet holds and notifies their holders.
+type ReservationExpiryJob struct {
+	DB *sqlx.DB
+}
+
+func (j ReservationExpiryJob) Execute() {
+	reservations, err := mappers.ReservationMapper{DB: j.DB}.ReleaseExpired()
+	if err != nil {
+		logrus.Error("release expired reservations error: ", err)
+		return
+	}
+	userMapper := mappers.UserMapper{DB: j.DB}
+	for _, reservation := range reservations {
+		user, err := userMapper.FindByID(reservation.UserID)
+		if err != nil {
+			logrus.Errorf("cannot notify holder of reservation %d: %v", reservation.ID, err)
+			continue
+		}
+		err = notification.GetNotifier().Notify(user, "Reservation expired", expiryMessage(reservation))
+		if err != nil {
+			logrus.Errorf("cannot notify holder of reservation %d: %v", reservation.ID, err)
+		}
+	}
+	if len(reservations) > 0 {
+		logrus.Infof("reservation expiry job released %d reservations", len(reservations))
+	}
+}
+
+// expiryMessage tells the holder what has become of the pet of the expired reservation.
+func expiryMessage(r *models.Reservation) string {
+	expiredAt := r.ExpiresAt.Format("2006-01-02 15:04 MST")
+	switch r.PetStatus {
+	case models.PetStatusAvailable:
+		return fmt.Sprintf("Your reservation of pet %d has expired at %v and the pet is available again.",
+			r.PetID, expiredAt)
+	case "":
+		return fmt.Sprintf("Your reservation of pet %d has expired at %v, the pet is no longer offered.",
+			r.PetID, expiredAt)
+	default:
+		return fmt.Sprintf("Your reservation of pet %d has expired at %v, the pet is %v and no longer available.",
+			r.PetID, expiredAt, r.PetStatus)
+	}
+}
+
+func DispatchReservationExpiryWorker(config ReservationExpiryConfig, db *sqlx.DB) {
+	dispatchPeriodicWorker("reservation expiry", config.Interval.Duration, func() Job {
+		return ReservationExpiryJob{DB: db}
+	})
+}
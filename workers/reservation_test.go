@@ -0,0 +1,31 @@
+package workers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+)
+
+func TestExpiryMessage(t *testing.T) {
+	expiresAt := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		petStatus string
+		want      string
+	}{
+		{models.PetStatusAvailable, "the pet is available again"},
+		{models.PetStatusSold, "the pet is sold and no longer available"},
+		{models.PetStatusPending, "the pet is pending and no longer available"},
+		{"", "the pet is no longer offered"},
+	}
+	for _, test := range tests {
+		message := expiryMessage(&models.Reservation{PetID: 3, ExpiresAt: expiresAt, PetStatus: test.petStatus})
+		if !strings.Contains(message, "pet 3 has expired at 2026-03-01 12:30 UTC") || !strings.Contains(message, test.want) {
+			t.Errorf("pet %q: got %q, want it to say %q", test.petStatus, message, test.want)
+		}
+		if test.petStatus != models.PetStatusAvailable && strings.Contains(message, "available again") {
+			t.Errorf("pet %q: message says the pet is available again", test.petStatus)
+		}
+	}
+}
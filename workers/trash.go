@@ -0,0 +1,116 @@
+package workers
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/services/storage"
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+)
+
+const (
+	defaultTrashRetentionDays = 30
+	// defaultAnonymousCartDays matches the lifetime of the cart cookie
+	defaultAnonymousCartDays    = 30
+	defaultWebhookRetentionDays = 30
+)
+
+type TrashPurgeConfig struct {
+	Interval utils.Duration
+	// RetentionDays is how long deleted pets and orders can still be restored
+	RetentionDays int
+	// AnonymousCartDays is how long carts of anonymous sessions are kept after their last change
+	AnonymousCartDays int
+	// WebhookRetentionDays is how long dispatched events of the outbox and their finished deliveries are kept
+	WebhookRetentionDays int
+}
+
+// TrashPurgeJob permanently removes pets and orders deleted more than RetentionDays ago
+// together with the images of the pets, carts of anonymous sessions abandoned for AnonymousCartDays
+// and webhook events dispatched more than WebhookRetentionDays ago whose deliveries are finished.
+type TrashPurgeJob struct {
+	DB                   *sqlx.DB
+	RetentionDays        int
+	AnonymousCartDays    int
+	WebhookRetentionDays int
+}
+
+func (j TrashPurgeJob) Execute() {
+	j.purgeCarts()
+	j.purgeWebhookEvents()
+
+	deletedBefore := time.Now().AddDate(0, 0, -j.RetentionDays)
+
+	// orders go first, so pets referenced only by purged orders are purged in the same run
+	orders, err := mappers.OrderMapper{DB: j.DB}.Purge(deletedBefore)
+	if err != nil {
+		logrus.Error("trash purge failed: ", err)
+		return
+	}
+	pets, err := mappers.PetMapper{DB: j.DB}.Purge(deletedBefore)
+	if err != nil {
+		logrus.Error("trash purge failed: ", err)
+		return
+	}
+	for _, pet := range pets {
+		for _, filename := range pet.Images.Filenames() {
+			err = storage.GetStorage().Delete(storage.ImagesBucket, filename)
+			if err != nil {
+				// left to the image gc
+				logrus.Errorf("trash purge cannot remove image %v of pet %d: %v", filename, pet.ID, err)
+			}
+		}
+	}
+	if orders > 0 || len(pets) > 0 {
+		logrus.Infof("trash purge removed %d pets and %d orders deleted before %v",
+			len(pets), orders, deletedBefore.Format(time.RFC3339))
+	}
+}
+
+func (j TrashPurgeJob) purgeCarts() {
+	updatedBefore := time.Now().AddDate(0, 0, -j.AnonymousCartDays)
+	carts, err := mappers.CartMapper{DB: j.DB}.PurgeAnonymous(updatedBefore)
+	if err != nil {
+		logrus.Error("anonymous carts purge failed: ", err)
+		return
+	}
+	if carts > 0 {
+		logrus.Infof("trash purge removed %d anonymous carts not changed since %v",
+			carts, updatedBefore.Format(time.RFC3339))
+	}
+}
+
+func (j TrashPurgeJob) purgeWebhookEvents() {
+	dispatchedBefore := time.Now().AddDate(0, 0, -j.WebhookRetentionDays)
+	events, err := mappers.WebhookMapper{DB: j.DB}.Purge(dispatchedBefore)
+	if err != nil {
+		logrus.Error("webhook events purge failed: ", err)
+		return
+	}
+	if events > 0 {
+		logrus.Infof("trash purge removed %d webhook events dispatched before %v with their deliveries",
+			events, dispatchedBefore.Format(time.RFC3339))
+	}
+}
+
+func DispatchTrashPurgeWorker(config TrashPurgeConfig, db *sqlx.DB) {
+	retentionDays := config.RetentionDays
+	if retentionDays <= 0 {
+		retentionDays = defaultTrashRetentionDays
+	}
+	anonymousCartDays := config.AnonymousCartDays
+	if anonymousCartDays <= 0 {
+		anonymousCartDays = defaultAnonymousCartDays
+	}
+	webhookRetentionDays := config.WebhookRetentionDays
+	if webhookRetentionDays <= 0 {
+		webhookRetentionDays = defaultWebhookRetentionDays
+	}
+	dispatchPeriodicWorker("trash purge", config.Interval.Duration, func() Job {
+		return TrashPurgeJob{DB: db, RetentionDays: retentionDays, AnonymousCartDays: anonymousCartDays,
+			WebhookRetentionDays: webhookRetentionDays}
+	})
+}
@@ -0,0 +1,200 @@
+package workers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/mappers"
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+)
+
+// Headers of webhook requests
+const (
+	WebhookEventHeader    = "X-Petstore-Event"
+	WebhookDeliveryHeader = "X-Petstore-Delivery"
+	// WebhookSignatureHeader is "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>" keyed by the secret>"
+	WebhookSignatureHeader = "X-Petstore-Signature"
+)
+
+const (
+	defaultWebhookBatchSize   = 100
+	defaultWebhookMaxAttempts = 10
+	defaultWebhookBackoffBase = 30 * time.Second
+	defaultWebhookBackoffMax  = 6 * time.Hour
+	defaultWebhookTimeout     = 10 * time.Second
+	// webhookErrorMaxLength bounds the part of the response kept as the error of a failed attempt
+	webhookErrorMaxLength = 512
+)
+
+type WebhookDeliveryConfig struct {
+	Interval utils.Duration
+	// BatchSize is the count of events dispatched and of deliveries sent by a run
+	BatchSize int
+	// MaxAttempts failed attempts make a delivery dead
+	MaxAttempts int
+	// BackoffBase is the delay after the first failed attempt, it doubles after each next one up to BackoffMax
+	BackoffBase utils.Duration
+	BackoffMax  utils.Duration
+	// Timeout bounds a single request to a receiver
+	Timeout utils.Duration
+}
+
+// WebhookDeliveryJob turns events of the outbox into deliveries to the subscriptions
+// and sends the deliveries which are due, failed ones are retried with exponential backoff.
+type WebhookDeliveryJob struct {
+	DB     *sqlx.DB
+	Config WebhookDeliveryConfig
+	Client *http.Client
+}
+
+func (j WebhookDeliveryJob) Execute() {
+	mapper := mappers.WebhookMapper{DB: j.DB}
+	batchSize := j.Config.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultWebhookBatchSize
+	}
+	dispatched, err := mapper.Dispatch(batchSize)
+	if err != nil {
+		logrus.Error("webhook events dispatch failed: ", err)
+	} else if dispatched > 0 {
+		logrus.Infof("webhook delivery job dispatched %d events", dispatched)
+	}
+
+	// deliveries are sent one by one, they must not come due again before the whole batch is sent
+	lease := time.Duration(batchSize+1) * j.Client.Timeout
+	deliveries, err := mapper.ClaimDue(batchSize, lease)
+	if err != nil {
+		logrus.Error("webhook deliveries claim failed: ", err)
+		return
+	}
+	subscriptions := map[int]*models.WebhookSubscription{}
+	for _, delivery := range deliveries {
+		subscription, ok := subscriptions[delivery.SubscriptionID]
+		if !ok {
+			subscription, err = mapper.FindByID(delivery.SubscriptionID)
+			if err != nil {
+				logrus.Errorf("webhook delivery %d: %v", delivery.ID, err)
+				continue
+			}
+			subscriptions[delivery.SubscriptionID] = subscription
+		}
+		j.attempt(delivery, subscription)
+		err = mapper.SaveAttempt(delivery)
+		if err != nil {
+			logrus.Errorf("webhook delivery %d: %v", delivery.ID, err)
+		}
+	}
+}
+
+// attempt sends the delivery and sets its outcome, a 2xx response delivers it,
+// anything else schedules a retry or makes it dead after the last attempt.
+func (j WebhookDeliveryJob) attempt(delivery *models.WebhookDelivery, subscription *models.WebhookSubscription) {
+	code, err := j.send(delivery, subscription)
+	delivery.ResponseCode = code
+	if err == nil {
+		now := time.Now()
+		delivery.Status = models.DeliveryDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+		return
+	}
+	delivery.LastError = err.Error()
+	maxAttempts := j.Config.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultWebhookMaxAttempts
+	}
+	if delivery.Attempts >= maxAttempts {
+		logrus.Warnf("webhook delivery %d of %v to %v is dead after %d attempts: %v",
+			delivery.ID, delivery.EventType, subscription.URL, delivery.Attempts, err)
+		delivery.Status = models.DeliveryDead
+		return
+	}
+	delivery.Status = models.DeliveryPending
+	delivery.NextAttemptAt = time.Now().Add(j.backoff(delivery.Attempts))
+}
+
+func (j WebhookDeliveryJob) send(delivery *models.WebhookDelivery, subscription *models.WebhookSubscription) (*int, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":        delivery.EventID,
+		"type":      delivery.EventType,
+		"createdAt": delivery.EventCreatedAt,
+		"data":      delivery.Payload,
+	})
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventHeader, delivery.EventType)
+	request.Header.Set(WebhookDeliveryHeader, strconv.Itoa(delivery.ID))
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(subscription.Secret, time.Now(), body))
+
+	response, err := j.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	code := response.StatusCode
+	if code >= http.StatusOK && code < http.StatusMultipleChoices {
+		_, _ = io.Copy(ioutil.Discard, response.Body)
+		return &code, nil
+	}
+	excerpt, _ := ioutil.ReadAll(io.LimitReader(response.Body, webhookErrorMaxLength))
+	return &code, fmt.Errorf("receiver responded %v: %s", response.Status, excerpt)
+}
+
+// backoff is the delay after the given count of failed attempts.
+func (j WebhookDeliveryJob) backoff(attempts int) time.Duration {
+	delay := j.Config.BackoffBase.Duration
+	if delay <= 0 {
+		delay = defaultWebhookBackoffBase
+	}
+	limit := j.Config.BackoffMax.Duration
+	if limit <= 0 {
+		limit = defaultWebhookBackoffMax
+	}
+	for i := 1; i < attempts && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// SignWebhook returns the signature header of the body sent at the time, receivers verify it
+// by computing the HMAC of "<t>.<body>" with the secret of their subscription and checking t is recent.
+func SignWebhook(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "."))
+	_, _ = mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func DispatchWebhookDeliveryWorker(config WebhookDeliveryConfig, db *sqlx.DB) {
+	timeout := config.Timeout.Duration
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	dispatchPeriodicWorker("webhook delivery", config.Interval.Duration, func() Job {
+		return WebhookDeliveryJob{DB: db, Config: config, Client: client}
+	})
+}
@@ -0,0 +1,165 @@
+package workers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/i4s-edu/petstore-kovalyk/db/models"
+	"gitlab.com/i4s-edu/petstore-kovalyk/utils"
+)
+
+// receiver records the requests it gets and answers them with the next of the codes.
+type receiver struct {
+	codes    []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	code := rc.codes[0]
+	if len(rc.codes) > 1 {
+		rc.codes = rc.codes[1:]
+	}
+	w.WriteHeader(code)
+	_, _ = w.Write([]byte("receiver says " + strconv.Itoa(code)))
+}
+
+func deliveryJob(maxAttempts int) WebhookDeliveryJob {
+	return WebhookDeliveryJob{
+		Config: WebhookDeliveryConfig{
+			MaxAttempts: maxAttempts,
+			BackoffBase: utils.Duration{Duration: time.Minute},
+			BackoffMax:  utils.Duration{Duration: time.Hour},
+		},
+		Client: &http.Client{Timeout: time.Second},
+	}
+}
+
+func verifySignature(t *testing.T, header, secret string, body []byte) {
+	parts := strings.Split(header, ",")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "t=") || !strings.HasPrefix(parts[1], "v1=") {
+		t.Fatalf("signature %q is not t=<unix>,v1=<hex>", header)
+	}
+	timestamp := strings.TrimPrefix(parts[0], "t=")
+	at, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(at, 0)) > time.Minute {
+		t.Errorf("signature timestamp %q is not recent", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "." + string(body)))
+	if !hmac.Equal([]byte(strings.TrimPrefix(parts[1], "v1=")), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		t.Errorf("signature %q does not match the body", header)
+	}
+}
+
+func TestWebhookDeliverySigned(t *testing.T) {
+	rc := &receiver{codes: []int{http.StatusNoContent}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	subscription := &models.WebhookSubscription{ID: 1, URL: server.URL, Secret: "whsec"}
+	delivery := &models.WebhookDelivery{ID: 7, EventID: 3, EventType: models.EventPetCreated,
+		Payload: json.RawMessage(`{"id":5}`), Status: models.DeliveryPending, Attempts: 1}
+	deliveryJob(3).attempt(delivery, subscription)
+
+	if len(rc.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(rc.requests))
+	}
+	request := rc.requests[0]
+	if request.Header.Get(WebhookEventHeader) != models.EventPetCreated || request.Header.Get(WebhookDeliveryHeader) != "7" {
+		t.Errorf("got event %q and delivery %q headers", request.Header.Get(WebhookEventHeader),
+			request.Header.Get(WebhookDeliveryHeader))
+	}
+	verifySignature(t, request.Header.Get(WebhookSignatureHeader), "whsec", rc.bodies[0])
+	var event struct {
+		ID   int64           `json:"id"`
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(rc.bodies[0], &event); err != nil || event.ID != 3 || event.Type != models.EventPetCreated ||
+		string(event.Data) != `{"id":5}` {
+		t.Errorf("got body %s", rc.bodies[0])
+	}
+
+	if delivery.Status != models.DeliveryDelivered || delivery.DeliveredAt == nil || delivery.LastError != "" {
+		t.Errorf("got status %v, delivered at %v, error %q", delivery.Status, delivery.DeliveredAt, delivery.LastError)
+	}
+	if delivery.ResponseCode == nil || *delivery.ResponseCode != http.StatusNoContent {
+		t.Errorf("got response code %v, want %d", delivery.ResponseCode, http.StatusNoContent)
+	}
+}
+
+func TestWebhookDeliveryRetriesUntilDead(t *testing.T) {
+	rc := &receiver{codes: []int{http.StatusInternalServerError}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	subscription := &models.WebhookSubscription{ID: 1, URL: server.URL, Secret: "whsec"}
+	delivery := &models.WebhookDelivery{ID: 7, EventType: models.EventPetCreated, Status: models.DeliveryPending}
+	job := deliveryJob(3)
+	wantDelays := []time.Duration{time.Minute, 2 * time.Minute}
+	for attempt := 1; attempt <= 3; attempt++ {
+		// ClaimDue counts the attempt before it is sent
+		delivery.Attempts = attempt
+		before := time.Now()
+		job.attempt(delivery, subscription)
+
+		if delivery.ResponseCode == nil || *delivery.ResponseCode != http.StatusInternalServerError {
+			t.Errorf("attempt %d: got response code %v", attempt, delivery.ResponseCode)
+		}
+		if !strings.Contains(delivery.LastError, "receiver says 500") {
+			t.Errorf("attempt %d: got error %q, want the response excerpt", attempt, delivery.LastError)
+		}
+		if attempt < 3 {
+			delay := delivery.NextAttemptAt.Sub(before)
+			if delivery.Status != models.DeliveryPending || delay < wantDelays[attempt-1] || delay > wantDelays[attempt-1]+time.Second {
+				t.Errorf("attempt %d: got status %v retried after %v, want pending after %v",
+					attempt, delivery.Status, delay, wantDelays[attempt-1])
+			}
+		} else if delivery.Status != models.DeliveryDead {
+			t.Errorf("attempt %d: got status %v, want dead", attempt, delivery.Status)
+		}
+	}
+	if len(rc.requests) != 3 {
+		t.Errorf("got %d requests, want 3", len(rc.requests))
+	}
+
+	// a redelivered dead delivery starts counting attempts again
+	rc.codes = []int{http.StatusOK}
+	delivery.Status, delivery.Attempts = models.DeliveryPending, 1
+	job.attempt(delivery, subscription)
+	if delivery.Status != models.DeliveryDelivered || delivery.LastError != "" {
+		t.Errorf("got status %v with error %q after redelivery, want delivered", delivery.Status, delivery.LastError)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	job := deliveryJob(10)
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{7, time.Hour},
+		{20, time.Hour},
+	}
+	for _, test := range tests {
+		if got := job.backoff(test.attempts); got != test.want {
+			t.Errorf("backoff(%d) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}